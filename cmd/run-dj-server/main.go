@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	ginzap "github.com/gin-contrib/zap"
@@ -12,6 +17,7 @@ import (
 	"github.com/rcong315/RunDJServer/internal/db"
 	"github.com/rcong315/RunDJServer/internal/service"
 	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/tempo"
 )
 
 func main() {
@@ -38,6 +44,27 @@ func main() {
 	service.InitializeLogger(logger)
 	spotify.InitializeLogger(logger)
 	db.InitializeLogger(logger)
+	tempo.InitializeLogger(logger)
+
+	// Revalidate rarely changing catalog pages instead of downloading them on every sync
+	service.ConfigureSpotifyClient()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Drain the durable job queue from the server as well when it is enabled
+	service.StartJobQueueWorker(ctx)
+
+	// Keep refresh tokens so stale libraries can be refreshed in the background
+	spotify.SetTokenObserver(service.SaveUserCredential)
+	service.StartRefreshScheduler(ctx)
+
+	// Move stored refresh tokens onto the current encryption key after a key rotation
+	go func() {
+		if err := service.ReencryptCredentials(ctx); err != nil {
+			logger.Error("Error re-encrypting user credentials", zap.Error(err))
+		}
+	}()
 
 	router := gin.New()
 
@@ -55,6 +82,12 @@ func main() {
 	router.POST("/api/v1/spotify/auth/refresh", spotify.RefreshHandler)
 
 	router.POST("/api/v1/user/register", service.RegisterHandler)
+	router.GET("/api/v1/user/sync", service.SyncStatusHandler)
+	router.DELETE("/api/v1/user/sync", service.CancelSyncHandler)
+	router.GET("/api/v1/user/profile", service.GetRunnerProfileHandler)
+	router.PUT("/api/v1/user/profile", service.SaveRunnerProfileHandler)
+	router.POST("/api/v1/user/activities", service.AddRunnerActivityHandler)
+	router.GET("/api/v1/user/cadence", service.CadenceHandler)
 
 	// router.GET("/api/v1/songs/preset", service.PresetPlaylistHandler)
 	// router.GET("/api/v1/songs/recommendations", service.RecommendationsHandler)
@@ -63,6 +96,8 @@ func main() {
 	router.POST("/api/v1/song/:songId/feedback", service.FeedbackHandler)
 
 	router.POST("/api/v1/playlist/bpm/:bpm", service.CreatePlaylistHandler)
+	router.POST("/api/v1/playlist/workout", service.WorkoutPlaylistHandler)
+	router.GET("/api/v1/playlist/cover/:bpm", service.CoverPreviewHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -70,9 +105,31 @@ func main() {
 		logger.Info("Defaulting to port", zap.String("port", port))
 	}
 
-	logger.Info("Server starting", zap.String("port", port))
-	err = router.Run(":" + port)
-	if err != nil {
-		logger.Fatal("Failed to run server", zap.Error(err))
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	go func() {
+		logger.Info("Server starting", zap.String("port", port))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("Failed to run server", zap.Error(err))
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	logger.Info("Shutting down server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down server", zap.Error(err))
+	}
+	// Cancel in-flight syncs so they record their final state before the process exits
+	if err := service.StopSyncs(shutdownCtx); err != nil {
+		logger.Error("Error stopping in-flight syncs", zap.Error(err))
 	}
+	logger.Info("Server stopped")
 }
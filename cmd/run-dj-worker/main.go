@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/service"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/tempo"
+)
+
+// run-dj-worker drains the durable Postgres job queue (JOB_QUEUE=postgres) without serving HTTP.
+func main() {
+	var logger *zap.Logger
+	var err error
+
+	if os.Getenv("DEBUG") == "true" {
+		logger, err = zap.NewDevelopment()
+		if err != nil {
+			panic(err)
+		}
+		err = godotenv.Load("../../.env")
+		if err != nil {
+			logger.Warn("Warning: .env file not found. Using system environment variables.")
+		}
+	} else {
+		logger, err = zap.NewProduction()
+		if err != nil {
+			panic(err)
+		}
+	}
+	defer logger.Sync()
+
+	service.InitializeLogger(logger)
+	spotify.InitializeLogger(logger)
+	db.InitializeLogger(logger)
+	tempo.InitializeLogger(logger)
+
+	// Revalidate rarely changing catalog pages instead of downloading them on every sync
+	service.ConfigureSpotifyClient()
+
+	if !service.DurableQueueEnabled() {
+		logger.Warn("JOB_QUEUE is not set to postgres; the server will not enqueue durable jobs for this worker")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		logger.Info("Received shutdown signal, finishing leased jobs")
+	}()
+
+	service.NewJobQueueWorker().Run(ctx)
+}
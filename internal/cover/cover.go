@@ -0,0 +1,143 @@
+// Package cover renders the cover art of RunDJ playlists: the BPM in large type over a gradient
+// whose color runs from blue for slow tempos to red for fast ones, with the playlist's tempo range
+// underneath. It only depends on the standard image packages, so covers can be rendered on their
+// own for previews.
+package cover
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strconv"
+)
+
+const (
+	// DefaultSize is the width and height of a cover in pixels
+	DefaultSize = 640
+	// MaxJPEGBytes is the largest JPEG Spotify accepts as a playlist cover, whose base64 encoding
+	// must fit in 256 KB
+	MaxJPEGBytes = 256 * 1024 * 3 / 4
+
+	// Tempos at or below slowBPM get the coldest color and tempos at or above fastBPM the warmest
+	slowBPM = 100
+	fastBPM = 200
+
+	minJPEGQuality = 40
+)
+
+// Options describe a cover
+type Options struct {
+	BPM    float64
+	MinBPM float64
+	MaxBPM float64
+	// Size is the width and height in pixels. Zero uses DefaultSize.
+	Size int
+}
+
+// Render draws the cover described by opts
+func Render(opts Options) *image.RGBA {
+	size := opts.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	// Vertical gradient from the tempo's color to a darker shade of it
+	hue := tempoHue(opts.BPM)
+	for y := range size {
+		c := hsvToRGB(hue, 0.75, gradientValue(y, size))
+		for x := range size {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	faded := color.NRGBA{R: 255, G: 255, B: 255, A: 200}
+	margin := size / 16
+
+	drawText(img, "RUNDJ", margin, margin, max(1, size/160), faded)
+
+	bpm := strconv.Itoa(int(math.Round(opts.BPM)))
+	bpmScale := max(1, min(size*4/5/textWidth(bpm, 1), size/3/glyphHeight))
+	bpmTop := (size - glyphHeight*bpmScale) / 2
+	drawTextCentered(img, bpm, bpmTop, bpmScale, white)
+
+	labelScale := max(1, size/80)
+	labelTop := bpmTop + glyphHeight*bpmScale + labelScale*3
+	drawTextCentered(img, "BPM", labelTop, labelScale, white)
+
+	if opts.MinBPM > 0 && opts.MaxBPM > 0 {
+		rangeScale := max(1, size/120)
+		tempoRange := formatBPM(opts.MinBPM) + " - " + formatBPM(opts.MaxBPM)
+		drawTextCentered(img, tempoRange, size-margin-glyphHeight*rangeScale, rangeScale, faded)
+	}
+
+	return img
+}
+
+// EncodeJPEG renders the cover and encodes it as a JPEG small enough to upload to Spotify
+func EncodeJPEG(opts Options) ([]byte, error) {
+	img := Render(opts)
+	var buf bytes.Buffer
+	for quality := 90; quality >= minJPEGQuality; quality -= 10 {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, fmt.Errorf("encoding cover: %w", err)
+		}
+		if buf.Len() <= MaxJPEGBytes {
+			return buf.Bytes(), nil
+		}
+	}
+	return nil, fmt.Errorf("cover is %d bytes even at quality %d, more than the %d allowed", buf.Len(), minJPEGQuality, MaxJPEGBytes)
+}
+
+// gradientValue is the brightness of row y of a cover size pixels high, from 0.95 at the top to 0.5
+// at the bottom
+func gradientValue(y int, size int) float64 {
+	if size <= 1 {
+		return 0.95
+	}
+	return 0.95 - 0.45*float64(y)/float64(size-1)
+}
+
+// tempoHue maps a tempo to a hue in degrees, from blue (240) for slow tempos to red (0) for fast ones
+func tempoHue(bpm float64) float64 {
+	t := (bpm - slowBPM) / (fastBPM - slowBPM)
+	return 240 * (1 - math.Max(0, math.Min(1, t)))
+}
+
+func hsvToRGB(h float64, s float64, v float64) color.RGBA {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return color.RGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 255,
+	}
+}
+
+// formatBPM drops the decimals of whole tempos, e.g. 170 but 168.5
+func formatBPM(bpm float64) string {
+	return strconv.FormatFloat(math.Round(bpm*10)/10, 'f', -1, 64)
+}
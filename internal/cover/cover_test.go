@@ -0,0 +1,52 @@
+package cover
+
+import (
+	"bytes"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+func TestEncodeJPEG(t *testing.T) {
+	for _, size := range []int{1, 2, 16, 300, 0} {
+		for _, bpm := range []float64{60, 150, 172.5, 250} {
+			data, err := EncodeJPEG(Options{BPM: bpm, MinBPM: bpm - 5, MaxBPM: bpm + 5, Size: size})
+			if err != nil {
+				t.Fatalf("encoding %d px cover at %g BPM: %v", size, bpm, err)
+			}
+			if len(data) > MaxJPEGBytes {
+				t.Errorf("%d px cover at %g BPM is %d bytes, more than %d", size, bpm, len(data), MaxJPEGBytes)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decoding %d px cover at %g BPM: %v", size, bpm, err)
+			}
+			wantSize := size
+			if wantSize == 0 {
+				wantSize = DefaultSize
+			}
+			if bounds := img.Bounds(); bounds.Dx() != wantSize || bounds.Dy() != wantSize {
+				t.Errorf("%d px cover at %g BPM is %dx%d", size, bpm, bounds.Dx(), bounds.Dy())
+			}
+		}
+	}
+}
+
+func TestGradientValue(t *testing.T) {
+	tests := []struct {
+		y, size int
+		wanted  float64
+	}{
+		{y: 0, size: 1, wanted: 0.95},
+		{y: 0, size: 2, wanted: 0.95},
+		{y: 1, size: 2, wanted: 0.5},
+		{y: 0, size: DefaultSize, wanted: 0.95},
+		{y: DefaultSize - 1, size: DefaultSize, wanted: 0.5},
+	}
+	for _, test := range tests {
+		if got := gradientValue(test.y, test.size); math.Abs(got-test.wanted) > 1e-9 {
+			t.Errorf("gradientValue(%d, %d) = %g, want %g", test.y, test.size, got, test.wanted)
+		}
+	}
+}
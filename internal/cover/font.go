@@ -0,0 +1,75 @@
+package cover
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// glyphs is a 5x7 bitmap font covering the characters drawn on covers. Each row is read left to
+// right, with '#' for a set pixel.
+var glyphs = map[rune][glyphHeight]string{
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	'.': {"     ", "     ", "     ", "     ", "     ", " ##  ", " ##  "},
+	'-': {"     ", "     ", "     ", " ### ", "     ", "     ", "     "},
+	' ': {"     ", "     ", "     ", "     ", "     ", "     ", "     "},
+	'B': {"#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "},
+	'D': {"###  ", "#  # ", "#   #", "#   #", "#   #", "#  # ", "###  "},
+	'J': {"  ###", "   # ", "   # ", "   # ", "   # ", "#  # ", " ##  "},
+	'M': {"#   #", "## ##", "# # #", "# # #", "#   #", "#   #", "#   #"},
+	'N': {"#   #", "#   #", "##  #", "# # #", "#  ##", "#   #", "#   #"},
+	'P': {"#### ", "#   #", "#   #", "#### ", "#    ", "#    ", "#    "},
+	'R': {"#### ", "#   #", "#   #", "#### ", "# #  ", "#  # ", "#   #"},
+	'U': {"#   #", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "},
+}
+
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphAdvance = glyphWidth + 1
+)
+
+// textWidth is the width of text drawn at scale, without trailing spacing
+func textWidth(text string, scale int) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*glyphAdvance - 1) * scale
+}
+
+// drawText draws text with its top left corner at (x, y), each font pixel scale pixels wide.
+// Characters without a glyph are drawn as spaces.
+func drawText(img draw.Image, text string, x int, y int, scale int, c color.Color) {
+	src := image.NewUniform(c)
+	for _, r := range text {
+		glyph, ok := glyphs[r]
+		if ok {
+			for row, line := range glyph {
+				for col, pixel := range line {
+					if pixel != '#' {
+						continue
+					}
+					rect := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+					draw.Draw(img, rect, src, image.Point{}, draw.Over)
+				}
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
+
+// drawTextCentered draws text horizontally centered on the image with its top at y
+func drawTextCentered(img draw.Image, text string, y int, scale int, c color.Color) {
+	x := img.Bounds().Min.X + (img.Bounds().Dx()-textWidth(text, scale))/2
+	drawText(img, text, x, y, scale, c)
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 
 	"go.uber.org/zap"
@@ -19,14 +20,14 @@ type Album struct {
 	ImageURLs        []string `json:"image_urls"`
 }
 
-func SaveAlbums(albums []*Album) error {
+func SaveAlbums(ctx context.Context, albums []*Album) error {
 	if len(albums) == 0 {
 		logger.Debug("SaveAlbums: No albums to save.")
 		return nil
 	}
 	logger.Debug("Attempting to save albums", zap.Int("count", len(albums)))
 
-	err := batchAndSave(albums, "album", func(item any) []any {
+	err := batchAndSave(ctx, albums, "album", func(item any) []any {
 		album := item.(*Album)
 		return []any{
 			album.AlbumId,
@@ -49,14 +50,14 @@ func SaveAlbums(albums []*Album) error {
 	return nil
 }
 
-func SaveUserSavedAlbums(userId string, albums []*Album) error {
+func SaveUserSavedAlbums(ctx context.Context, userId string, albums []*Album) error {
 	if len(albums) == 0 {
 		logger.Debug("SaveUserSavedAlbums: No saved albums to associate for user.", zap.String("userId", userId))
 		return nil
 	}
 	logger.Debug("Attempting to save user-saved album associations", zap.String("userId", userId), zap.Int("count", len(albums)))
 
-	err := batchAndSave(albums, "userSavedAlbum", func(item any) []any {
+	err := batchAndSave(ctx, albums, "userSavedAlbum", func(item any) []any {
 		album := item.(*Album)
 		return []any{
 			userId,
@@ -71,14 +72,35 @@ func SaveUserSavedAlbums(userId string, albums []*Album) error {
 	return nil
 }
 
-func SaveAlbumTracks(albumId string, tracks []*Track) error {
+// DeleteUserSavedAlbumsExcept removes the saved albums of a user that are not in keepIds
+func DeleteUserSavedAlbumsExcept(ctx context.Context, userId string, keepIds []string) (int64, error) {
+	deleted, err := deleteExcept(ctx, "userSavedAlbums", userId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale user saved albums: %v", err)
+	}
+	if deleted > 0 {
+		logger.Debug("Deleted stale user saved albums", zap.String("userId", userId), zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
+
+// CountUserSavedAlbums returns the number of user saved albums stored for a user
+func CountUserSavedAlbums(ctx context.Context, userId string) (int, error) {
+	count, err := countRows(ctx, "userSavedAlbumCount", userId)
+	if err != nil {
+		return 0, fmt.Errorf("error counting user saved albums: %v", err)
+	}
+	return count, nil
+}
+
+func SaveAlbumTracks(ctx context.Context, albumId string, tracks []*Track) error {
 	if len(tracks) == 0 {
 		logger.Debug("SaveAlbumTracks: No tracks to associate with album.", zap.String("albumId", albumId))
 		return nil
 	}
 	logger.Debug("Attempting to save album-track associations", zap.String("albumId", albumId), zap.Int("trackCount", len(tracks)))
 
-	err := batchAndSave(tracks, "albumTrack", func(item any) []any {
+	err := batchAndSave(ctx, tracks, "albumTrack", func(item any) []any {
 		track := item.(*Track)
 		return []any{
 			albumId,
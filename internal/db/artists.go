@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 
 	"go.uber.org/zap"
@@ -21,14 +22,14 @@ type Artist struct {
 	ImageURLs  []string `json:"image_urls"`
 }
 
-func SaveArtists(artists []*Artist) error {
+func SaveArtists(ctx context.Context, artists []*Artist) error {
 	if len(artists) == 0 {
 		logger.Debug("SaveArtists: No artists to save.")
 		return nil
 	}
 	logger.Debug("Attempting to save artists", zap.Int("count", len(artists)))
 
-	err := batchAndSave(artists, "artist", func(item any) []any {
+	err := batchAndSave(ctx, artists, "artist", func(item any) []any {
 		artist := item.(*Artist)
 		return []any{
 			artist.ArtistId,
@@ -47,7 +48,7 @@ func SaveArtists(artists []*Artist) error {
 	return nil
 }
 
-func SaveUserTopArtists(userId string, rankedArtists []*RankedArtist) error {
+func SaveUserTopArtists(ctx context.Context, userId string, rankedArtists []*RankedArtist) error {
 	if len(rankedArtists) == 0 {
 		logger.Debug("SaveUserTopArtists: No top artists to associate for user.",
 			zap.String("userId", userId))
@@ -73,7 +74,7 @@ func SaveUserTopArtists(userId string, rankedArtists []*RankedArtist) error {
 		}
 	}
 
-	err := batchAndSave(items, "userTopArtist", func(item any) []any {
+	err := batchAndSave(ctx, items, "userTopArtist", func(item any) []any {
 		artist := item.(userTopArtistWithRank)
 		return []any{
 			artist.userId,
@@ -91,14 +92,26 @@ func SaveUserTopArtists(userId string, rankedArtists []*RankedArtist) error {
 	return nil
 }
 
-func SaveUserFollowedArtists(userId string, artists []*Artist) error {
+// DeleteUserTopArtistsExcept removes the top artists of a user that are not in keepIds
+func DeleteUserTopArtistsExcept(ctx context.Context, userId string, keepIds []string) (int64, error) {
+	deleted, err := deleteExcept(ctx, "userTopArtists", userId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale user top artists: %v", err)
+	}
+	if deleted > 0 {
+		logger.Debug("Deleted stale user top artists", zap.String("userId", userId), zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
+
+func SaveUserFollowedArtists(ctx context.Context, userId string, artists []*Artist) error {
 	if len(artists) == 0 {
 		logger.Debug("SaveUserFollowedArtists: No followed artists to associate for user.", zap.String("userId", userId))
 		return nil
 	}
 	logger.Debug("Attempting to save user-followed artist associations", zap.String("userId", userId), zap.Int("count", len(artists)))
 
-	err := batchAndSave(artists, "userFollowedArtist", func(item any) []any {
+	err := batchAndSave(ctx, artists, "userFollowedArtist", func(item any) []any {
 		artist := item.(*Artist)
 		return []any{
 			userId,
@@ -113,8 +126,20 @@ func SaveUserFollowedArtists(userId string, artists []*Artist) error {
 	return nil
 }
 
+// DeleteUserFollowedArtistsExcept removes the followed artists of a user that are not in keepIds
+func DeleteUserFollowedArtistsExcept(ctx context.Context, userId string, keepIds []string) (int64, error) {
+	deleted, err := deleteExcept(ctx, "userFollowedArtists", userId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale user followed artists: %v", err)
+	}
+	if deleted > 0 {
+		logger.Debug("Deleted stale user followed artists", zap.String("userId", userId), zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
+
 // SaveArtistTopTracks saves artist top tracks with their specific rankings
-func SaveArtistTopTracks(artistId string, rankedTracks []*RankedTrack) error {
+func SaveArtistTopTracks(ctx context.Context, artistId string, rankedTracks []*RankedTrack) error {
 	if len(rankedTracks) == 0 {
 		logger.Debug("SaveArtistTopTracks: No top tracks to associate with artist.",
 			zap.String("artistId", artistId))
@@ -140,7 +165,7 @@ func SaveArtistTopTracks(artistId string, rankedTracks []*RankedTrack) error {
 		}
 	}
 
-	err := batchAndSave(items, "artistTopTrack", func(item any) []any {
+	err := batchAndSave(ctx, items, "artistTopTrack", func(item any) []any {
 		track := item.(artistTopTrackWithRank)
 		return []any{
 			track.artistId,
@@ -158,14 +183,14 @@ func SaveArtistTopTracks(artistId string, rankedTracks []*RankedTrack) error {
 	return nil
 }
 
-func SaveArtistAlbums(artistId string, albums []*Album) error {
+func SaveArtistAlbums(ctx context.Context, artistId string, albums []*Album) error {
 	if len(albums) == 0 {
 		logger.Debug("SaveArtistAlbums: No albums to associate with artist.", zap.String("artistId", artistId))
 		return nil
 	}
 	logger.Debug("Attempting to save artist-album associations", zap.String("artistId", artistId), zap.Int("albumCount", len(albums)))
 
-	err := batchAndSave(albums, "artistAlbum", func(item any) []any {
+	err := batchAndSave(ctx, albums, "artistAlbum", func(item any) []any {
 		album := item.(*Album)
 		return []any{
 			artistId,
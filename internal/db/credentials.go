@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// UserCredential is the stored Spotify refresh token of a user, used for background syncs.
+// The token is stored encrypted; rows written before encryption only have LegacyRefreshToken.
+type UserCredential struct {
+	UserId                 string `json:"user_id"`
+	RefreshTokenCiphertext []byte `json:"-"`
+	KeyVersion             int    `json:"key_version"`
+	LegacyRefreshToken     string `json:"-"`
+	Scope                  string `json:"scope"`
+}
+
+func SaveUserCredential(ctx context.Context, credential *UserCredential) error {
+	logger.Debug("Attempting to save user credential",
+		zap.String("userId", credential.UserId),
+		zap.Int("keyVersion", credential.KeyVersion))
+
+	sqlQuery, err := getQueryString("insert", "userCredential")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	var scope *string
+	if credential.Scope != "" {
+		scope = &credential.Scope
+	}
+
+	_, err = db.Exec(ctx, sqlQuery,
+		credential.UserId,
+		credential.RefreshTokenCiphertext,
+		credential.KeyVersion,
+		scope,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving user credential: %v", err)
+	}
+
+	logger.Debug("Successfully saved user credential", zap.String("userId", credential.UserId))
+	return nil
+}
+
+// ReencryptUserCredential replaces the stored token of credential with ciphertext under keyVersion,
+// as long as the row still holds the token credential was read with. It returns false if the row
+// changed in the meantime, e.g. because the user logged in again.
+func ReencryptUserCredential(ctx context.Context, credential *UserCredential, ciphertext []byte, keyVersion int) (bool, error) {
+	logger.Debug("Attempting to re-encrypt user credential",
+		zap.String("userId", credential.UserId),
+		zap.Int("fromKeyVersion", credential.KeyVersion),
+		zap.Int("toKeyVersion", keyVersion))
+
+	sqlQuery, err := getQueryString("update", "reencryptUserCredential")
+	if err != nil {
+		return false, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("database connection error: %v", err)
+	}
+
+	// Key versions start at 1, so 0 is a credential stored before encryption
+	var previousKeyVersion *int
+	if credential.KeyVersion != 0 {
+		previousKeyVersion = &credential.KeyVersion
+	}
+
+	tag, err := db.Exec(ctx, sqlQuery,
+		credential.UserId,
+		ciphertext,
+		keyVersion,
+		previousKeyVersion,
+		credential.RefreshTokenCiphertext,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error re-encrypting user credential: %v", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func DeleteUserCredential(ctx context.Context, userId string) error {
+	logger.Debug("Attempting to delete user credential", zap.String("userId", userId))
+
+	sqlQuery, err := getQueryString("delete", "userCredential")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	_, err = db.Exec(ctx, sqlQuery, userId)
+	if err != nil {
+		return fmt.Errorf("error deleting user credential: %v", err)
+	}
+
+	return nil
+}
+
+// ClaimUsersDueForRefresh claims up to limit registered users with a stored credential whose
+// latest sync started before staleBefore, never-synced users first. Claimed users aren't returned
+// again, to this or any other instance, until claimFor has passed.
+func ClaimUsersDueForRefresh(ctx context.Context, staleBefore time.Time, limit int, claimFor time.Duration) ([]*UserCredential, error) {
+	logger.Debug("Claiming users due for refresh", zap.Time("staleBefore", staleBefore), zap.Int("limit", limit))
+
+	sqlQuery, err := getQueryString("update", "claimUsersDueForRefresh")
+	if err != nil {
+		return nil, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("database connection error: %v", err)
+	}
+
+	rows, err := db.Query(ctx, sqlQuery, staleBefore, limit, claimFor.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming users due for refresh: %v", err)
+	}
+
+	credentials, err := scanUserCredentials(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading users due for refresh: %v", err)
+	}
+	return credentials, nil
+}
+
+// GetCredentialsToReencrypt returns up to limit credentials, ordered by user id and starting
+// after afterUserId, that are stored in plaintext or under a key version other than currentVersion
+func GetCredentialsToReencrypt(ctx context.Context, currentVersion int, afterUserId string, limit int) ([]*UserCredential, error) {
+	logger.Debug("Getting credentials to re-encrypt",
+		zap.Int("currentVersion", currentVersion),
+		zap.String("afterUserId", afterUserId))
+
+	rows, err := executeSelect(ctx, "credentialsToReencrypt", currentVersion, afterUserId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for credentials to re-encrypt: %v", err)
+	}
+
+	credentials, err := scanUserCredentials(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials to re-encrypt: %v", err)
+	}
+	return credentials, nil
+}
+
+func scanUserCredentials(rows pgx.Rows) ([]*UserCredential, error) {
+	defer rows.Close()
+
+	var credentials []*UserCredential
+	for rows.Next() {
+		credential := &UserCredential{}
+		var keyVersion *int
+		var legacyRefreshToken, scope *string
+		err := rows.Scan(
+			&credential.UserId,
+			&credential.RefreshTokenCiphertext,
+			&keyVersion,
+			&legacyRefreshToken,
+			&scope,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning user credential: %v", err)
+		}
+		if keyVersion != nil {
+			credential.KeyVersion = *keyVersion
+		}
+		if legacyRefreshToken != nil {
+			credential.LegacyRefreshToken = *legacyRefreshToken
+		}
+		if scope != nil {
+			credential.Scope = *scope
+		}
+		credentials = append(credentials, credential)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
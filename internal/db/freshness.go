@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Crawls whose last sync time is tracked, so that entities shared by many users are only re-crawled
+// once their data goes stale. Crawls without a market are tracked on the artist and album rows;
+// crawls for a market in the crawl_market table, since they only cover that market.
+const (
+	CrawlArtistTopTracks = "artistTopTracks"
+	CrawlArtistAlbums    = "artistAlbums"
+	CrawlAlbumTracks     = "albumTracks"
+)
+
+// IsFresh reports whether the crawl of an entity for market finished within ttl. Entities that were
+// never crawled for the market, or whose row has not been saved yet, are not fresh.
+func IsFresh(ctx context.Context, crawl string, id string, market string, ttl time.Duration) (bool, error) {
+	var rows pgx.Rows
+	var err error
+	if market == "" {
+		rows, err = executeSelect(ctx, crawl+"Fresh", id, ttl.Seconds())
+	} else {
+		rows, err = executeSelect(ctx, "crawlMarketFresh", crawl, id, market, ttl.Seconds())
+	}
+	if err != nil {
+		return false, fmt.Errorf("error executing select for %s freshness: %v", crawl, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return false, fmt.Errorf("error reading %s freshness: %v", crawl, err)
+		}
+		return false, nil
+	}
+
+	var fresh bool
+	if err := rows.Scan(&fresh); err != nil {
+		return false, fmt.Errorf("error scanning %s freshness: %v", crawl, err)
+	}
+	return fresh, nil
+}
+
+// MarkSynced records that the crawl of an entity for market just finished
+func MarkSynced(ctx context.Context, crawl string, id string, market string) error {
+	queryType, queryFilename, args := "update", crawl+"Synced", []any{id}
+	if market != "" {
+		queryType, queryFilename, args = "insert", "crawlMarket", []any{crawl, id, market}
+	}
+	sqlQuery, err := getQueryString(queryType, queryFilename)
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	_, err = db.Exec(ctx, sqlQuery, args...)
+	if err != nil {
+		return fmt.Errorf("error marking %s synced for %s: %v", crawl, id, err)
+	}
+
+	logger.Debug("Marked crawl synced", zap.String("crawl", crawl), zap.String("id", id), zap.String("market", market))
+	return nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HTTPCacheEntry is a stored response body with the validators it was served with
+type HTTPCacheEntry struct {
+	URL          string
+	ETag         string
+	LastModified string
+	Body         []byte
+}
+
+// GetHTTPCacheEntry returns the cached response for url, or nil if there is none
+func GetHTTPCacheEntry(ctx context.Context, url string) (*HTTPCacheEntry, error) {
+	rows, err := executeSelect(ctx, "httpCacheEntry", url)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for http cache entry: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error reading http cache entry: %v", err)
+		}
+		return nil, nil
+	}
+
+	var etag, lastModified *string
+	entry := &HTTPCacheEntry{URL: url}
+	if err := rows.Scan(&etag, &lastModified, &entry.Body); err != nil {
+		return nil, fmt.Errorf("error scanning http cache entry: %v", err)
+	}
+	if etag != nil {
+		entry.ETag = *etag
+	}
+	if lastModified != nil {
+		entry.LastModified = *lastModified
+	}
+	return entry, nil
+}
+
+func SaveHTTPCacheEntry(ctx context.Context, entry *HTTPCacheEntry) error {
+	sqlQuery, err := getQueryString("insert", "httpCacheEntry")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	var etag, lastModified *string
+	if entry.ETag != "" {
+		etag = &entry.ETag
+	}
+	if entry.LastModified != "" {
+		lastModified = &entry.LastModified
+	}
+
+	_, err = db.Exec(ctx, sqlQuery, entry.URL, etag, lastModified, entry.Body)
+	if err != nil {
+		return fmt.Errorf("error saving http cache entry: %v", err)
+	}
+	return nil
+}
+
+// DeleteHTTPCacheEntriesBefore deletes the cached responses last stored before the given time and
+// returns how many were deleted
+func DeleteHTTPCacheEntriesBefore(ctx context.Context, before time.Time) (int64, error) {
+	sqlQuery, err := getQueryString("delete", "httpCacheEntries")
+	if err != nil {
+		return 0, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("database connection error: %v", err)
+	}
+
+	tag, err := db.Exec(ctx, sqlQuery, before)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting http cache entries: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
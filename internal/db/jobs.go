@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// QueuedJob is a row of the durable job_queue table
+type QueuedJob struct {
+	JobId       int64  `json:"job_id"`
+	JobType     string `json:"job_type"`
+	Payload     []byte `json:"payload"`
+	DedupeKey   string `json:"dedupe_key"` // A sync run queues each dedupe key at most once
+	SyncRunId   *int64 `json:"sync_run_id"`
+	Stage       string `json:"stage"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"max_attempts"`
+}
+
+// JobCounts summarises the durable jobs of one sync run stage
+type JobCounts struct {
+	Stage  string   `json:"stage"`
+	Total  int64    `json:"total"`
+	Open   int64    `json:"open"`
+	Done   int64    `json:"done"`
+	Failed int64    `json:"failed"`
+	Errors []string `json:"errors"`
+}
+
+func EnqueueJobs(ctx context.Context, jobs []*QueuedJob) error {
+	if len(jobs) == 0 {
+		logger.Debug("EnqueueJobs: No jobs to enqueue.")
+		return nil
+	}
+	logger.Debug("Attempting to enqueue jobs", zap.Int("count", len(jobs)))
+
+	err := batchAndSave(ctx, jobs, "job", func(item any) []any {
+		job := item.(*QueuedJob)
+		return []any{
+			job.JobType,
+			string(job.Payload),
+			job.DedupeKey,
+			job.SyncRunId,
+			job.Stage,
+			job.MaxAttempts,
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("error enqueueing jobs: %v", err)
+	}
+
+	logger.Debug("Successfully enqueued jobs", zap.Int("count", len(jobs)))
+	return nil
+}
+
+// LeaseJobs claims up to limit runnable jobs for workerId. Claimed jobs become visible to other
+// workers again once visibilityTimeout passes without the job being completed or failed.
+func LeaseJobs(ctx context.Context, workerId string, limit int, visibilityTimeout time.Duration) ([]*QueuedJob, error) {
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("database connection error: %v", err)
+	}
+
+	expireQuery, err := getQueryString("update", "expireJobs")
+	if err != nil {
+		return nil, fmt.Errorf("error getting query string: %v", err)
+	}
+	tag, err := db.Exec(ctx, expireQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error expiring abandoned jobs: %v", err)
+	}
+	if tag.RowsAffected() > 0 {
+		logger.Warn("Marked abandoned jobs as failed", zap.Int64("count", tag.RowsAffected()))
+	}
+
+	leaseQuery, err := getQueryString("update", "leaseJobs")
+	if err != nil {
+		return nil, fmt.Errorf("error getting query string: %v", err)
+	}
+	rows, err := db.Query(ctx, leaseQuery, workerId, limit, visibilityTimeout.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error leasing jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var jobs []*QueuedJob
+	for rows.Next() {
+		job := &QueuedJob{}
+		var stage *string
+		err := rows.Scan(
+			&job.JobId,
+			&job.JobType,
+			&job.Payload,
+			&job.SyncRunId,
+			&stage,
+			&job.Attempts,
+			&job.MaxAttempts,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning leased job: %v", err)
+		}
+		if stage != nil {
+			job.Stage = *stage
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading leased jobs: %v", err)
+	}
+
+	if len(jobs) > 0 {
+		logger.Debug("Leased jobs", zap.String("workerId", workerId), zap.Int("count", len(jobs)))
+	}
+	return jobs, nil
+}
+
+func CompleteJob(ctx context.Context, jobId int64) error {
+	sqlQuery, err := getQueryString("update", "completeJob")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	_, err = db.Exec(ctx, sqlQuery, jobId)
+	if err != nil {
+		return fmt.Errorf("error completing job %d: %v", jobId, err)
+	}
+	return nil
+}
+
+// FailJob records a failed attempt. The job is requeued after retryAfter, or marked failed
+// once it has used all of its attempts.
+func FailJob(ctx context.Context, jobId int64, jobErr error, retryAfter time.Duration) error {
+	sqlQuery, err := getQueryString("update", "failJob")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	_, err = db.Exec(ctx, sqlQuery, jobId, jobErr.Error(), retryAfter.Seconds())
+	if err != nil {
+		return fmt.Errorf("error failing job %d: %v", jobId, err)
+	}
+	return nil
+}
+
+// GetJobCounts returns per-stage durable job counts for a sync run
+func GetJobCounts(ctx context.Context, syncRunId int64) (map[string]*JobCounts, error) {
+	rows, err := executeSelect(ctx, "jobCountsBySyncRun", syncRunId)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for job counts: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]*JobCounts)
+	for rows.Next() {
+		c := &JobCounts{}
+		var stage *string
+		if err := rows.Scan(&stage, &c.Total, &c.Open, &c.Done, &c.Failed, &c.Errors); err != nil {
+			return nil, fmt.Errorf("error scanning job counts: %v", err)
+		}
+		if stage != nil {
+			c.Stage = *stage
+		}
+		counts[c.Stage] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading job counts: %v", err)
+	}
+
+	return counts, nil
+}
+
+// CancelJobs marks the queued jobs of a sync run as cancelled. Jobs already leased by a worker run to completion.
+func CancelJobs(ctx context.Context, syncRunId int64) (int64, error) {
+	sqlQuery, err := getQueryString("update", "cancelJobs")
+	if err != nil {
+		return 0, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("database connection error: %v", err)
+	}
+
+	tag, err := db.Exec(ctx, sqlQuery, syncRunId)
+	if err != nil {
+		return 0, fmt.Errorf("error cancelling jobs for sync run %d: %v", syncRunId, err)
+	}
+
+	logger.Debug("Cancelled queued jobs", zap.Int64("syncRunId", syncRunId), zap.Int64("count", tag.RowsAffected()))
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// lockReleaseTimeout bounds releasing a lock, which happens after the locking context may be gone
+const lockReleaseTimeout = 10 * time.Second
+
+// Lease is a named lock held by one owner until it expires, e.g. the lock on syncing one user's
+// library across all instances. It is a row in the lease table rather than an advisory lock, so it
+// holds no pooled connection while taken and works through PgBouncer's transaction pooling.
+// Holders renew it before it expires.
+type Lease struct {
+	name  string
+	owner string
+	ttl   time.Duration
+}
+
+// TryAcquireLease takes the named lease for ttl without waiting. It returns nil if someone else
+// holds it and it hasn't expired.
+func TryAcquireLease(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	logger.Debug("Attempting to acquire lease", zap.String("name", name))
+
+	sqlQuery, err := getQueryString("insert", "lease")
+	if err != nil {
+		return nil, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("database connection error: %v", err)
+	}
+
+	ownerBytes := make([]byte, 16)
+	if _, err := rand.Read(ownerBytes); err != nil {
+		return nil, fmt.Errorf("error generating lease owner: %v", err)
+	}
+	owner := hex.EncodeToString(ownerBytes)
+
+	err = db.QueryRow(ctx, sqlQuery, name, owner, ttl.Seconds()).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Debug("Lease is held elsewhere", zap.String("name", name))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring lease %s: %v", name, err)
+	}
+
+	return &Lease{name: name, owner: owner, ttl: ttl}, nil
+}
+
+// Renew extends the lease by its ttl. It returns false if the lease expired and was taken by
+// someone else in the meantime.
+func (l *Lease) Renew(ctx context.Context) (bool, error) {
+	sqlQuery, err := getQueryString("update", "renewLease")
+	if err != nil {
+		return false, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("database connection error: %v", err)
+	}
+
+	tag, err := db.Exec(ctx, sqlQuery, l.name, l.owner, l.ttl.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("error renewing lease %s: %v", l.name, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Release gives the lease up, unless someone else has taken it over already
+func (l *Lease) Release() {
+	sqlQuery, err := getQueryString("delete", "lease")
+	if err != nil {
+		logger.Warn("Error getting query string for releasing lease", zap.String("name", l.name), zap.Error(err))
+		return
+	}
+
+	db, err := getDB()
+	if err != nil {
+		logger.Warn("Database connection error releasing lease", zap.String("name", l.name), zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockReleaseTimeout)
+	defer cancel()
+	if _, err := db.Exec(ctx, sqlQuery, l.name, l.owner); err != nil {
+		logger.Warn("Error releasing lease", zap.String("name", l.name), zap.Error(err))
+	}
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 
 	"go.uber.org/zap"
@@ -17,14 +18,14 @@ type Playlist struct {
 }
 
 // TODO: Delete deleted playlists
-func SavePlaylists(playlists []*Playlist) error {
+func SavePlaylists(ctx context.Context, playlists []*Playlist) error {
 	if len(playlists) == 0 {
 		logger.Debug("SavePlaylists: No playlists to save.")
 		return nil
 	}
 	logger.Debug("Attempting to save playlists", zap.Int("count", len(playlists)))
 
-	err := batchAndSave(playlists, "playlist", func(item any) []any {
+	err := batchAndSave(ctx, playlists, "playlist", func(item any) []any {
 		playlist := item.(*Playlist)
 		return []any{
 			playlist.PlaylistId,
@@ -44,14 +45,14 @@ func SavePlaylists(playlists []*Playlist) error {
 	return nil
 }
 
-func SaveUserPlaylists(userId string, playlists []*Playlist) error {
+func SaveUserPlaylists(ctx context.Context, userId string, playlists []*Playlist) error {
 	if len(playlists) == 0 {
 		logger.Debug("SaveUserPlaylists: No playlists to associate for user.", zap.String("userId", userId))
 		return nil
 	}
 	logger.Debug("Attempting to save user-playlist associations", zap.String("userId", userId), zap.Int("count", len(playlists)))
 
-	err := batchAndSave(playlists, "userPlaylist", func(item any) []any {
+	err := batchAndSave(ctx, playlists, "userPlaylist", func(item any) []any {
 		playlist := item.(*Playlist)
 		return []any{
 			userId,
@@ -66,14 +67,26 @@ func SaveUserPlaylists(userId string, playlists []*Playlist) error {
 	return nil
 }
 
-func SavePlaylistTracks(playlistId string, tracks []*Track) error {
+// DeleteUserPlaylistsExcept removes the playlists of a user that are not in keepIds
+func DeleteUserPlaylistsExcept(ctx context.Context, userId string, keepIds []string) (int64, error) {
+	deleted, err := deleteExcept(ctx, "userPlaylists", userId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale user playlists: %v", err)
+	}
+	if deleted > 0 {
+		logger.Debug("Deleted stale user playlists", zap.String("userId", userId), zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
+
+func SavePlaylistTracks(ctx context.Context, playlistId string, tracks []*Track) error {
 	if len(tracks) == 0 {
 		logger.Debug("SavePlaylistTracks: No tracks to associate with playlist.", zap.String("playlistId", playlistId))
 		return nil
 	}
 	logger.Debug("Attempting to save playlist-track associations", zap.String("playlistId", playlistId), zap.Int("trackCount", len(tracks)))
 
-	err := batchAndSave(tracks, "playlistTrack", func(item any) []any {
+	err := batchAndSave(ctx, tracks, "playlistTrack", func(item any) []any {
 		track := item.(*Track)
 		return []any{
 			playlistId,
@@ -87,3 +100,69 @@ func SavePlaylistTracks(playlistId string, tracks []*Track) error {
 	logger.Debug("Successfully saved playlist-track associations batch", zap.String("playlistId", playlistId), zap.Int("trackCount", len(tracks)))
 	return nil
 }
+
+// DeletePlaylistTracksExcept removes the tracks of a playlist that are not in keepIds
+func DeletePlaylistTracksExcept(ctx context.Context, playlistId string, keepIds []string) (int64, error) {
+	deleted, err := deleteExcept(ctx, "playlistTracks", playlistId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale playlist tracks: %v", err)
+	}
+	if deleted > 0 {
+		logger.Debug("Deleted stale playlist tracks", zap.String("playlistId", playlistId), zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
+
+// GetPlaylistSnapshots returns the snapshot id of the last completed track crawl of each playlist in
+// playlistIds. Playlists that were never crawled are left out.
+func GetPlaylistSnapshots(ctx context.Context, playlistIds []string) (map[string]string, error) {
+	snapshots := make(map[string]string)
+	if len(playlistIds) == 0 {
+		return snapshots, nil
+	}
+
+	rows, err := executeSelect(ctx, "playlistSnapshots", playlistIds)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for playlist snapshots: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playlistId, snapshotId string
+		if err := rows.Scan(&playlistId, &snapshotId); err != nil {
+			return nil, fmt.Errorf("error scanning playlist snapshot: %v", err)
+		}
+		snapshots[playlistId] = snapshotId
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading playlist snapshots: %v", err)
+	}
+
+	return snapshots, nil
+}
+
+// MarkPlaylistSynced records that the tracks of a playlist were crawled at snapshotId
+func MarkPlaylistSynced(ctx context.Context, playlistId string, snapshotId string) error {
+	sqlQuery, err := getQueryString("update", "playlistSynced")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	var snapshot *string
+	if snapshotId != "" {
+		snapshot = &snapshotId
+	}
+
+	_, err = db.Exec(ctx, sqlQuery, playlistId, snapshot)
+	if err != nil {
+		return fmt.Errorf("error marking playlist %s synced: %v", playlistId, err)
+	}
+
+	logger.Debug("Marked playlist synced", zap.String("playlistId", playlistId), zap.String("snapshotId", snapshotId))
+	return nil
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// GetRunDJPlaylistId returns the id of the playlist RunDJ made for a user at bpm, or "" if there is none
+func GetRunDJPlaylistId(ctx context.Context, userId string, bpm int) (string, error) {
+	rows, err := executeSelect(ctx, "rundjPlaylist", userId, bpm)
+	if err != nil {
+		return "", fmt.Errorf("error executing select for rundj playlist: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", fmt.Errorf("error reading rundj playlist: %v", err)
+		}
+		return "", nil
+	}
+
+	var playlistId string
+	if err := rows.Scan(&playlistId); err != nil {
+		return "", fmt.Errorf("error scanning rundj playlist: %v", err)
+	}
+	return playlistId, nil
+}
+
+// SaveRunDJPlaylist records the playlist RunDJ made for a user at bpm, replacing any previous one
+func SaveRunDJPlaylist(ctx context.Context, userId string, bpm int, playlistId string) error {
+	sqlQuery, err := getQueryString("insert", "rundjPlaylist")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	_, err = db.Exec(ctx, sqlQuery, userId, bpm, playlistId)
+	if err != nil {
+		return fmt.Errorf("error saving rundj playlist for user %s: %v", userId, err)
+	}
+
+	logger.Debug("Saved rundj playlist", zap.String("userId", userId), zap.Int("bpm", bpm), zap.String("playlistId", playlistId))
+	return nil
+}
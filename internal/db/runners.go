@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PaceZone is a named range of paces a runner trains at, e.g. "easy" from 6:00 to 6:45 per km
+type PaceZone struct {
+	Name string `json:"name"`
+	// MinSecondsPerKm is the fast end of the zone and MaxSecondsPerKm the slow end
+	MinSecondsPerKm float64 `json:"min_seconds_per_km"`
+	MaxSecondsPerKm float64 `json:"max_seconds_per_km"`
+}
+
+// RunnerProfile is what a user told us about their running. Zero values are unknown.
+type RunnerProfile struct {
+	UserId           string     `json:"user_id"`
+	HeightCM         float64    `json:"height_cm"`
+	LegLengthCM      float64    `json:"leg_length_cm"`
+	PreferredCadence float64    `json:"preferred_cadence"`
+	PaceZones        []PaceZone `json:"pace_zones"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// RunnerActivity is a run the user recorded, used to calibrate their cadence
+type RunnerActivity struct {
+	ActivityId      int64     `json:"activity_id"`
+	StartedAt       time.Time `json:"started_at"`
+	DistanceMeters  float64   `json:"distance_m"`
+	DurationSeconds int       `json:"duration_s"`
+	// AverageCadence is in steps per minute, 0 if the run wasn't tracked with a cadence sensor
+	AverageCadence float64 `json:"average_cadence"`
+}
+
+// GetRunnerProfile returns the runner profile of a user, or nil if they haven't set one
+func GetRunnerProfile(ctx context.Context, userId string) (*RunnerProfile, error) {
+	rows, err := executeSelect(ctx, "runnerProfile", userId)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for runner profile: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error reading runner profile: %v", err)
+		}
+		return nil, nil
+	}
+
+	var heightCM, legLengthCM, preferredCadence *float64
+	var paceZonesJSON []byte
+	profile := &RunnerProfile{UserId: userId}
+	if err := rows.Scan(&heightCM, &legLengthCM, &preferredCadence, &paceZonesJSON, &profile.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("error scanning runner profile: %v", err)
+	}
+	if heightCM != nil {
+		profile.HeightCM = *heightCM
+	}
+	if legLengthCM != nil {
+		profile.LegLengthCM = *legLengthCM
+	}
+	if preferredCadence != nil {
+		profile.PreferredCadence = *preferredCadence
+	}
+	if len(paceZonesJSON) > 0 {
+		if err := json.Unmarshal(paceZonesJSON, &profile.PaceZones); err != nil {
+			logger.Warn("Error unmarshalling stored pace zones",
+				zap.String("userId", userId),
+				zap.Error(err))
+		}
+	}
+	return profile, nil
+}
+
+// SaveRunnerProfile creates or replaces the runner profile of a user
+func SaveRunnerProfile(ctx context.Context, profile *RunnerProfile) error {
+	logger.Debug("Attempting to save runner profile", zap.String("userId", profile.UserId))
+
+	paceZones := profile.PaceZones
+	if paceZones == nil {
+		paceZones = []PaceZone{}
+	}
+	paceZonesJSON, err := json.Marshal(paceZones)
+	if err != nil {
+		return fmt.Errorf("error marshalling pace zones: %v", err)
+	}
+
+	sqlQuery, err := getQueryString("insert", "runnerProfile")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	_, err = db.Exec(ctx, sqlQuery,
+		profile.UserId,
+		nullIfZero(profile.HeightCM),
+		nullIfZero(profile.LegLengthCM),
+		nullIfZero(profile.PreferredCadence),
+		string(paceZonesJSON),
+	)
+	if err != nil {
+		return fmt.Errorf("error saving runner profile: %v", err)
+	}
+	return nil
+}
+
+// SaveRunnerActivity records a run of a user and returns its id
+func SaveRunnerActivity(ctx context.Context, userId string, activity *RunnerActivity) (int64, error) {
+	logger.Debug("Attempting to save runner activity", zap.String("userId", userId))
+
+	sqlQuery, err := getQueryString("insert", "runnerActivity")
+	if err != nil {
+		return 0, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("database connection error: %v", err)
+	}
+
+	var activityId int64
+	err = db.QueryRow(ctx, sqlQuery,
+		userId,
+		activity.StartedAt,
+		activity.DistanceMeters,
+		activity.DurationSeconds,
+		nullIfZero(activity.AverageCadence),
+	).Scan(&activityId)
+	if err != nil {
+		return 0, fmt.Errorf("error saving runner activity: %v", err)
+	}
+	return activityId, nil
+}
+
+// GetRecentRunnerActivities returns the latest limit runs of a user, newest first
+func GetRecentRunnerActivities(ctx context.Context, userId string, limit int) ([]*RunnerActivity, error) {
+	rows, err := executeSelect(ctx, "runnerActivities", userId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for runner activities: %v", err)
+	}
+	defer rows.Close()
+
+	var activities []*RunnerActivity
+	for rows.Next() {
+		var averageCadence *float64
+		activity := &RunnerActivity{}
+		if err := rows.Scan(&activity.ActivityId, &activity.StartedAt, &activity.DistanceMeters, &activity.DurationSeconds, &averageCadence); err != nil {
+			return nil, fmt.Errorf("error scanning runner activity: %v", err)
+		}
+		if averageCadence != nil {
+			activity.AverageCadence = *averageCadence
+		}
+		activities = append(activities, activity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading runner activities: %v", err)
+	}
+	return activities, nil
+}
+
+// nullIfZero stores unknown measurements as NULL
+func nullIfZero(value float64) *float64 {
+	if value == 0 {
+		return nil
+	}
+	return &value
+}
@@ -17,9 +17,11 @@ CREATE TABLE IF NOT EXISTS "track" (
     popularity INT,
     duration_ms INT,
     available_markets TEXT [] DEFAULT '{}',
+    preview_url TEXT,
     audio_features JSONB,
     bpm FLOAT,
     time_signature INT,
+    linked_from_id VARCHAR(255),
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
@@ -31,6 +33,8 @@ CREATE TABLE IF NOT EXISTS "playlist" (
     public BOOLEAN DEFAULT true,
     followers INT DEFAULT 0,
     image_urls TEXT [] DEFAULT '{}',
+    last_synced_at TIMESTAMP,
+    snapshot_id VARCHAR(255),
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
@@ -41,6 +45,8 @@ CREATE TABLE IF NOT EXISTS "artist" (
     popularity INT,
     followers INT,
     image_urls TEXT [] DEFAULT '{}',
+    top_tracks_synced_at TIMESTAMP,
+    albums_synced_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
@@ -55,6 +61,7 @@ CREATE TABLE IF NOT EXISTS "album" (
     release_date TEXT,
     available_markets TEXT [] DEFAULT '{}',
     image_urls TEXT [] DEFAULT '{}',
+    last_synced_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
@@ -161,6 +168,148 @@ CREATE TABLE IF NOT EXISTS "album_track" (
     FOREIGN KEY (album_id) REFERENCES "album" (album_id),
     FOREIGN KEY (track_id) REFERENCES "track" (track_id)
 );
+CREATE TABLE IF NOT EXISTS "sync_run" (
+    sync_run_id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    status TEXT NOT NULL,
+    progress FLOAT DEFAULT 0,
+    stages JSONB DEFAULT '[]',
+    jobs_submitted INT DEFAULT 0,
+    jobs_completed INT DEFAULT 0,
+    jobs_failed INT DEFAULT 0,
+    error_count INT DEFAULT 0,
+    errors TEXT [] DEFAULT '{}',
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP,
+    duration_ms BIGINT DEFAULT 0,
+    cancel_requested BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES "user" (user_id)
+);
+CREATE TABLE IF NOT EXISTS "user_sync_state" (
+    user_id VARCHAR(255) NOT NULL,
+    source VARCHAR(255) NOT NULL,
+    high_water_mark TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, source),
+    FOREIGN KEY (user_id) REFERENCES "user" (user_id)
+);
+CREATE TABLE IF NOT EXISTS "job_queue" (
+    job_id BIGSERIAL PRIMARY KEY,
+    job_type VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    dedupe_key VARCHAR(255),
+    sync_run_id BIGINT,
+    stage VARCHAR(255),
+    status VARCHAR(32) NOT NULL DEFAULT 'queued',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    run_after TIMESTAMP NOT NULL DEFAULT NOW(),
+    leased_by VARCHAR(255),
+    lease_expires_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (sync_run_id) REFERENCES "sync_run" (sync_run_id)
+);
+CREATE TABLE IF NOT EXISTS "user_credential" (
+    user_id VARCHAR(255) PRIMARY KEY,
+    refresh_token_ciphertext BYTEA,
+    key_version INT,
+    -- Plaintext tokens stored before encryption; cleared when the row is re-encrypted
+    refresh_token TEXT,
+    scope TEXT,
+    -- Set while a scheduler instance is refreshing the user, so other instances skip them
+    refresh_claimed_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS "lease" (
+    name VARCHAR(255) PRIMARY KEY,
+    owner VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "http_cache" (
+    url TEXT PRIMARY KEY,
+    etag TEXT,
+    last_modified TEXT,
+    body BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS "rundj_playlist" (
+    user_id VARCHAR(255) NOT NULL,
+    bpm INT NOT NULL,
+    playlist_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, bpm),
+    FOREIGN KEY (user_id) REFERENCES "user" (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS "runner_profile" (
+    user_id VARCHAR(255) PRIMARY KEY,
+    height_cm FLOAT,
+    leg_length_cm FLOAT,
+    preferred_cadence FLOAT,
+    pace_zones JSONB DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES "user" (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS "runner_activity" (
+    activity_id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    distance_m FLOAT NOT NULL,
+    duration_s INT NOT NULL,
+    average_cadence FLOAT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES "user" (user_id)
+);
+
+-- Whether a track can be played in a market, for tracks fetched for that market. Spotify leaves
+-- available_markets out of those responses.
+CREATE TABLE IF NOT EXISTS "track_market" (
+    track_id VARCHAR(255) NOT NULL,
+    market VARCHAR(2) NOT NULL,
+    is_playable BOOLEAN NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (track_id, market),
+    FOREIGN KEY (track_id) REFERENCES "track" (track_id)
+);
+
+-- When a crawl of an artist or album for a market last finished. A crawl for a market only
+-- records playability in that market, so it is fresh for users in that market only.
+CREATE TABLE IF NOT EXISTS "crawl_market" (
+    crawl VARCHAR(32) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    market VARCHAR(2) NOT NULL,
+    synced_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (crawl, entity_id, market)
+);
+
+-- Migrations for existing databases
+ALTER TABLE "playlist" ADD COLUMN IF NOT EXISTS last_synced_at TIMESTAMP;
+ALTER TABLE "artist" ADD COLUMN IF NOT EXISTS top_tracks_synced_at TIMESTAMP;
+ALTER TABLE "artist" ADD COLUMN IF NOT EXISTS albums_synced_at TIMESTAMP;
+ALTER TABLE "album" ADD COLUMN IF NOT EXISTS last_synced_at TIMESTAMP;
+ALTER TABLE "user_credential" ADD COLUMN IF NOT EXISTS refresh_token_ciphertext BYTEA;
+ALTER TABLE "user_credential" ADD COLUMN IF NOT EXISTS key_version INT;
+ALTER TABLE "user_credential" ALTER COLUMN refresh_token DROP NOT NULL;
+ALTER TABLE "playlist" ADD COLUMN IF NOT EXISTS snapshot_id VARCHAR(255);
+ALTER TABLE "track" ADD COLUMN IF NOT EXISTS preview_url TEXT;
+ALTER TABLE "track" ADD COLUMN IF NOT EXISTS linked_from_id VARCHAR(255);
+ALTER TABLE "job_queue" ADD COLUMN IF NOT EXISTS dedupe_key VARCHAR(255);
+DROP INDEX IF EXISTS idx_job_queue_sync_run_dedupe_key;
+ALTER TABLE "sync_run" ADD COLUMN IF NOT EXISTS cancel_requested BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "user_credential" ADD COLUMN IF NOT EXISTS refresh_claimed_until TIMESTAMP;
 
 -- Recommended Indexes
 CREATE INDEX IF NOT EXISTS idx_track_bpm ON "track" (bpm);
@@ -171,3 +320,10 @@ CREATE INDEX IF NOT EXISTS idx_album_track_track_id ON "album_track" (track_id);
 CREATE INDEX IF NOT EXISTS idx_artist_album_album_id ON "artist_album" (album_id);
 CREATE INDEX IF NOT EXISTS idx_artist_top_track_track_id ON "artist_top_track" (track_id);
 CREATE INDEX IF NOT EXISTS idx_playlist_track_track_id ON "playlist_track" (track_id);
+CREATE INDEX IF NOT EXISTS idx_sync_run_user_started_at ON "sync_run" (user_id, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_job_queue_status_run_after ON "job_queue" (status, run_after);
+CREATE INDEX IF NOT EXISTS idx_job_queue_sync_run_stage ON "job_queue" (sync_run_id, stage, status);
+-- Jobs outside a sync run have no sync_run_id, and NULLs never conflict, so they dedupe under 0
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_queue_run_dedupe_key ON "job_queue" ((COALESCE(sync_run_id, 0)), dedupe_key);
+CREATE INDEX IF NOT EXISTS idx_http_cache_updated_at ON "http_cache" (updated_at);
+CREATE INDEX IF NOT EXISTS idx_runner_activity_user_started_at ON "runner_activity" (user_id, started_at DESC);
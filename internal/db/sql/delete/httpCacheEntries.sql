@@ -0,0 +1,2 @@
+DELETE FROM "http_cache"
+WHERE updated_at < $1;
@@ -0,0 +1,3 @@
+DELETE FROM "lease"
+WHERE name = $1
+    AND owner = $2;
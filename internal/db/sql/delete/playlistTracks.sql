@@ -0,0 +1,3 @@
+DELETE FROM "playlist_track"
+WHERE playlist_id = $1
+    AND NOT (track_id = ANY($2));
@@ -0,0 +1,2 @@
+DELETE FROM "user_credential"
+WHERE user_id = $1;
@@ -0,0 +1,3 @@
+DELETE FROM "user_playlist"
+WHERE user_id = $1
+    AND NOT (playlist_id = ANY($2));
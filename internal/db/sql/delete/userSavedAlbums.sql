@@ -0,0 +1,3 @@
+DELETE FROM "user_saved_album"
+WHERE user_id = $1
+    AND NOT (album_id = ANY($2));
@@ -0,0 +1,3 @@
+DELETE FROM "user_saved_track"
+WHERE user_id = $1
+    AND NOT (track_id = ANY($2));
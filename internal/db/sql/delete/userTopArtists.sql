@@ -0,0 +1,3 @@
+DELETE FROM "user_top_artist"
+WHERE user_id = $1
+    AND NOT (artist_id = ANY($2));
@@ -0,0 +1,4 @@
+INSERT INTO "crawl_market" (crawl, entity_id, market)
+VALUES ($1, $2, $3) ON CONFLICT (crawl, entity_id, market) DO
+UPDATE
+SET synced_at = NOW();
@@ -0,0 +1,7 @@
+INSERT INTO "http_cache" (url, etag, last_modified, body)
+VALUES ($1, $2, $3, $4) ON CONFLICT (url) DO
+UPDATE
+SET etag = EXCLUDED.etag,
+    last_modified = EXCLUDED.last_modified,
+    body = EXCLUDED.body,
+    updated_at = NOW();
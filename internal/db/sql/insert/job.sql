@@ -0,0 +1,9 @@
+INSERT INTO "job_queue" (
+        job_type,
+        payload,
+        dedupe_key,
+        sync_run_id,
+        stage,
+        max_attempts
+    )
+VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT ((COALESCE(sync_run_id, 0)), dedupe_key) DO NOTHING;
@@ -0,0 +1,7 @@
+INSERT INTO "lease" (name, owner, expires_at)
+VALUES ($1, $2, NOW() + make_interval(secs => $3)) ON CONFLICT (name) DO
+UPDATE
+SET owner = EXCLUDED.owner,
+    expires_at = EXCLUDED.expires_at
+WHERE "lease".expires_at < NOW()
+RETURNING owner;
@@ -0,0 +1,5 @@
+INSERT INTO "rundj_playlist" (user_id, bpm, playlist_id)
+VALUES ($1, $2, $3) ON CONFLICT (user_id, bpm) DO
+UPDATE
+SET playlist_id = EXCLUDED.playlist_id,
+    updated_at = NOW();
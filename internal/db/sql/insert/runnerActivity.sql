@@ -0,0 +1,9 @@
+INSERT INTO "runner_activity" (
+        user_id,
+        started_at,
+        distance_m,
+        duration_s,
+        average_cadence
+    )
+VALUES ($1, $2, $3, $4, $5)
+RETURNING activity_id;
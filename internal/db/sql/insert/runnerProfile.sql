@@ -0,0 +1,14 @@
+INSERT INTO "runner_profile" (
+        user_id,
+        height_cm,
+        leg_length_cm,
+        preferred_cadence,
+        pace_zones
+    )
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id) DO
+UPDATE
+SET height_cm = EXCLUDED.height_cm,
+    leg_length_cm = EXCLUDED.leg_length_cm,
+    preferred_cadence = EXCLUDED.preferred_cadence,
+    pace_zones = EXCLUDED.pace_zones,
+    updated_at = NOW();
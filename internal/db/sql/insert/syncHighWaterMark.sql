@@ -0,0 +1,8 @@
+INSERT INTO "user_sync_state" (user_id, source, high_water_mark)
+VALUES ($1, $2, $3) ON CONFLICT (user_id, source) DO
+UPDATE
+SET high_water_mark = GREATEST(
+        "user_sync_state".high_water_mark,
+        EXCLUDED.high_water_mark
+    ),
+    updated_at = NOW();
@@ -0,0 +1,8 @@
+INSERT INTO "sync_run" (
+        user_id,
+        status,
+        stages,
+        started_at
+    )
+VALUES ($1, $2, $3, $4)
+RETURNING sync_run_id;
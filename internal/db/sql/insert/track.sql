@@ -6,19 +6,35 @@ INSERT INTO "track" (
         popularity,
         duration_ms,
         available_markets,
+        preview_url,
         audio_features,
         bpm,
-        time_signature
+        time_signature,
+        linked_from_id
     )
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) ON CONFLICT (track_id) DO
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT (track_id) DO
 UPDATE
 SET name = EXCLUDED.name,
     artist_ids = EXCLUDED.artist_ids,
     album_id = EXCLUDED.album_id,
     popularity = EXCLUDED.popularity,
     duration_ms = EXCLUDED.duration_ms,
-    available_markets = EXCLUDED.available_markets,
-    audio_features = EXCLUDED.audio_features,
-    bpm = EXCLUDED.bpm,
-    time_signature = EXCLUDED.time_signature,
+    available_markets = CASE
+        WHEN cardinality(EXCLUDED.available_markets) > 0 THEN EXCLUDED.available_markets
+        ELSE "track".available_markets
+    END,
+    preview_url = COALESCE(EXCLUDED.preview_url, "track".preview_url),
+    audio_features = CASE
+        WHEN EXCLUDED.bpm > 0 THEN EXCLUDED.audio_features
+        ELSE "track".audio_features
+    END,
+    bpm = CASE
+        WHEN EXCLUDED.bpm > 0 THEN EXCLUDED.bpm
+        ELSE "track".bpm
+    END,
+    time_signature = CASE
+        WHEN EXCLUDED.bpm > 0 THEN EXCLUDED.time_signature
+        ELSE "track".time_signature
+    END,
+    linked_from_id = COALESCE(EXCLUDED.linked_from_id, "track".linked_from_id),
     updated_at = NOW();
\ No newline at end of file
@@ -0,0 +1,5 @@
+INSERT INTO "track_market" (track_id, market, is_playable)
+VALUES ($1, $2, $3) ON CONFLICT (track_id, market) DO
+UPDATE
+SET is_playable = EXCLUDED.is_playable,
+    updated_at = NOW();
@@ -0,0 +1,13 @@
+INSERT INTO "user_credential" (
+        user_id,
+        refresh_token_ciphertext,
+        key_version,
+        scope
+    )
+VALUES ($1, $2, $3, $4) ON CONFLICT (user_id) DO
+UPDATE
+SET refresh_token_ciphertext = EXCLUDED.refresh_token_ciphertext,
+    key_version = EXCLUDED.key_version,
+    refresh_token = NULL,
+    scope = COALESCE(EXCLUDED.scope, "user_credential".scope),
+    updated_at = NOW();
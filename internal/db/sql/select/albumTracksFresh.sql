@@ -0,0 +1,4 @@
+SELECT last_synced_at > NOW() - make_interval(secs => $2) AS fresh
+FROM "album"
+WHERE album_id = $1
+    AND last_synced_at IS NOT NULL;
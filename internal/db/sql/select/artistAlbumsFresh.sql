@@ -0,0 +1,4 @@
+SELECT albums_synced_at > NOW() - make_interval(secs => $2) AS fresh
+FROM "artist"
+WHERE artist_id = $1
+    AND albums_synced_at IS NOT NULL;
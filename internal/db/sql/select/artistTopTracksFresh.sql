@@ -0,0 +1,4 @@
+SELECT top_tracks_synced_at > NOW() - make_interval(secs => $2) AS fresh
+FROM "artist"
+WHERE artist_id = $1
+    AND top_tracks_synced_at IS NOT NULL;
@@ -0,0 +1,5 @@
+SELECT synced_at > NOW() - make_interval(secs => $4) AS fresh
+FROM "crawl_market"
+WHERE crawl = $1
+    AND entity_id = $2
+    AND market = $3;
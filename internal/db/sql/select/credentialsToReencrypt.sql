@@ -0,0 +1,13 @@
+SELECT user_id,
+    refresh_token_ciphertext,
+    key_version,
+    refresh_token,
+    scope
+FROM "user_credential"
+WHERE (
+        refresh_token IS NOT NULL
+        OR key_version IS DISTINCT FROM $1
+    )
+    AND user_id > $2
+ORDER BY user_id
+LIMIT $3;
@@ -0,0 +1,5 @@
+SELECT etag,
+    last_modified,
+    body
+FROM "http_cache"
+WHERE url = $1;
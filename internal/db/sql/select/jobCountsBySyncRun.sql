@@ -0,0 +1,25 @@
+SELECT stage,
+    COUNT(*) AS total,
+    COUNT(*) FILTER (
+        WHERE status IN ('queued', 'leased')
+    ) AS open,
+    COUNT(*) FILTER (
+        WHERE status = 'done'
+    ) AS done,
+    COUNT(*) FILTER (
+        WHERE status = 'failed'
+    ) AS failed,
+    COALESCE(
+        (
+            array_agg(
+                last_error
+                ORDER BY updated_at DESC
+            ) FILTER (
+                WHERE status = 'failed'
+            )
+        ) [1:10],
+        '{}'
+    ) AS errors
+FROM "job_queue"
+WHERE sync_run_id = $1
+GROUP BY stage;
@@ -0,0 +1,17 @@
+SELECT sync_run_id,
+    user_id,
+    status,
+    progress,
+    stages,
+    jobs_submitted,
+    jobs_completed,
+    jobs_failed,
+    error_count,
+    errors,
+    started_at,
+    finished_at,
+    duration_ms
+FROM "sync_run"
+WHERE user_id = $1
+ORDER BY started_at DESC
+LIMIT 1;
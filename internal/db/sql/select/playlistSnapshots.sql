@@ -0,0 +1,5 @@
+SELECT playlist_id,
+    snapshot_id
+FROM "playlist"
+WHERE playlist_id = ANY($1)
+    AND snapshot_id IS NOT NULL;
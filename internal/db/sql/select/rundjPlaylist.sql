@@ -0,0 +1,4 @@
+SELECT playlist_id
+FROM "rundj_playlist"
+WHERE user_id = $1
+    AND bpm = $2;
@@ -0,0 +1,9 @@
+SELECT activity_id,
+    started_at,
+    distance_m,
+    duration_s,
+    average_cadence
+FROM "runner_activity"
+WHERE user_id = $1
+ORDER BY started_at DESC
+LIMIT $2;
@@ -0,0 +1,7 @@
+SELECT height_cm,
+    leg_length_cm,
+    preferred_cadence,
+    pace_zones,
+    updated_at
+FROM "runner_profile"
+WHERE user_id = $1;
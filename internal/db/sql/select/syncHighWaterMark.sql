@@ -0,0 +1,4 @@
+SELECT high_water_mark
+FROM "user_sync_state"
+WHERE user_id = $1
+    AND source = $2;
@@ -1,5 +1,6 @@
 SELECT t.track_id,
-    t.bpm
+    t.bpm,
+    NULL::INT AS rank
 FROM "user_top_artist" uta
     JOIN "artist_album" aa ON uta.artist_id = aa.artist_id
     JOIN "album_track" atr ON aa.album_id = atr.album_id
@@ -7,8 +8,23 @@ FROM "user_top_artist" uta
     JOIN "track" t ON atr.track_id = t.track_id
 WHERE uta.user_id = $1
     AND a.album_type = 'album'
-    AND t.bpm BETWEEN $2 AND $3
+    AND EXISTS (
+        SELECT 1
+        FROM unnest($2::FLOAT [], $3::FLOAT []) AS r(min_bpm, max_bpm)
+        WHERE t.bpm BETWEEN r.min_bpm AND r.max_bpm
+    )
     AND t.time_signature = 4
+    AND (
+        $4 = ''
+        OR $4 = ANY(t.available_markets)
+        OR EXISTS (
+            SELECT 1
+            FROM "track_market" tm
+            WHERE tm.track_id = t.track_id
+                AND tm.market = $4
+                AND tm.is_playable
+        )
+    )
     AND NOT EXISTS (
         SELECT 1
         FROM "user_track_interaction" uti
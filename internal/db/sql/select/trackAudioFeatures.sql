@@ -0,0 +1,7 @@
+SELECT track_id,
+    audio_features,
+    bpm,
+    time_signature
+FROM "track"
+WHERE track_id = ANY($1)
+    AND bpm > 0;
@@ -0,0 +1,16 @@
+SELECT t.track_id,
+    COALESCE(t.popularity, 0),
+    COALESCE(t.duration_ms, 0),
+    (t.audio_features->>'energy')::FLOAT,
+    (t.audio_features->>'danceability')::FLOAT,
+    COALESCE(
+        (
+            SELECT MAX(uti.feedback)
+            FROM "user_track_interaction" uti
+            WHERE uti.track_id = t.track_id
+                AND uti.user_id = $1
+        ),
+        0
+    )
+FROM "track" t
+WHERE t.track_id = ANY($2);
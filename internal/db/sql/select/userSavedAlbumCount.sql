@@ -0,0 +1,3 @@
+SELECT COUNT(*)
+FROM "user_saved_album"
+WHERE user_id = $1;
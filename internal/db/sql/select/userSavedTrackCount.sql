@@ -0,0 +1,3 @@
+SELECT COUNT(*)
+FROM "user_saved_track"
+WHERE user_id = $1;
@@ -0,0 +1,3 @@
+UPDATE "album"
+SET last_synced_at = NOW()
+WHERE album_id = $1;
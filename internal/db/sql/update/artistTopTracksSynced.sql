@@ -0,0 +1,3 @@
+UPDATE "artist"
+SET top_tracks_synced_at = NOW()
+WHERE artist_id = $1;
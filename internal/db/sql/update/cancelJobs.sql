@@ -0,0 +1,7 @@
+UPDATE "job_queue"
+SET status = 'cancelled',
+    leased_by = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE sync_run_id = $1
+    AND status = 'queued';
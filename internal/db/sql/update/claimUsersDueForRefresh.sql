@@ -0,0 +1,30 @@
+UPDATE "user_credential"
+SET refresh_claimed_until = NOW() + make_interval(secs => $3)
+WHERE user_id IN (
+        SELECT c.user_id
+        FROM "user_credential" c
+            JOIN "user" u ON u.user_id = c.user_id
+            LEFT JOIN LATERAL (
+                SELECT r.started_at
+                FROM "sync_run" r
+                WHERE r.user_id = c.user_id
+                ORDER BY r.started_at DESC
+                LIMIT 1
+            ) latest ON TRUE
+        WHERE (
+                latest.started_at IS NULL
+                OR latest.started_at < $1
+            )
+            AND (
+                c.refresh_claimed_until IS NULL
+                OR c.refresh_claimed_until < NOW()
+            )
+        ORDER BY latest.started_at ASC NULLS FIRST
+        LIMIT $2 FOR
+        UPDATE OF c SKIP LOCKED
+    )
+RETURNING user_id,
+    refresh_token_ciphertext,
+    key_version,
+    refresh_token,
+    scope;
@@ -0,0 +1,6 @@
+UPDATE "job_queue"
+SET status = 'done',
+    leased_by = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE job_id = $1;
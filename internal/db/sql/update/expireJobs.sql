@@ -0,0 +1,7 @@
+UPDATE "job_queue"
+SET status = 'failed',
+    last_error = COALESCE(last_error, 'lease expired after final attempt'),
+    updated_at = NOW()
+WHERE status = 'leased'
+    AND lease_expires_at < NOW()
+    AND attempts >= max_attempts;
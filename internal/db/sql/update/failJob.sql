@@ -0,0 +1,11 @@
+UPDATE "job_queue"
+SET status = CASE
+        WHEN attempts >= max_attempts THEN 'failed'
+        ELSE 'queued'
+    END,
+    run_after = NOW() + make_interval(secs => $3),
+    last_error = $2,
+    leased_by = NULL,
+    lease_expires_at = NULL,
+    updated_at = NOW()
+WHERE job_id = $1;
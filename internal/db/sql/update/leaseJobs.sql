@@ -0,0 +1,29 @@
+UPDATE "job_queue"
+SET status = 'leased',
+    attempts = attempts + 1,
+    leased_by = $1,
+    lease_expires_at = NOW() + make_interval(secs => $3),
+    updated_at = NOW()
+WHERE job_id IN (
+        SELECT job_id
+        FROM "job_queue"
+        WHERE (
+                status = 'queued'
+                AND run_after <= NOW()
+            )
+            OR (
+                status = 'leased'
+                AND lease_expires_at < NOW()
+                AND attempts < max_attempts
+            )
+        ORDER BY job_id
+        LIMIT $2 FOR
+        UPDATE SKIP LOCKED
+    )
+RETURNING job_id,
+    job_type,
+    payload,
+    sync_run_id,
+    stage,
+    attempts,
+    max_attempts;
@@ -0,0 +1,4 @@
+UPDATE "playlist"
+SET snapshot_id = $2,
+    last_synced_at = NOW()
+WHERE playlist_id = $1;
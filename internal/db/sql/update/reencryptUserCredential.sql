@@ -0,0 +1,8 @@
+UPDATE "user_credential"
+SET refresh_token_ciphertext = $2,
+    key_version = $3,
+    refresh_token = NULL,
+    updated_at = NOW()
+WHERE user_id = $1
+    AND key_version IS NOT DISTINCT FROM $4
+    AND refresh_token_ciphertext IS NOT DISTINCT FROM $5;
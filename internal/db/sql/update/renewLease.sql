@@ -0,0 +1,4 @@
+UPDATE "lease"
+SET expires_at = NOW() + make_interval(secs => $3)
+WHERE name = $1
+    AND owner = $2;
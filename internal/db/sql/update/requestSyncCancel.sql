@@ -0,0 +1,7 @@
+UPDATE "sync_run"
+SET cancel_requested = TRUE,
+    updated_at = NOW()
+WHERE user_id = $1
+    AND status = 'running'
+    AND updated_at > NOW() - make_interval(secs => $2)
+RETURNING sync_run_id;
@@ -0,0 +1,14 @@
+UPDATE "sync_run"
+SET status = $2,
+    progress = $3,
+    stages = $4,
+    jobs_submitted = $5,
+    jobs_completed = $6,
+    jobs_failed = $7,
+    error_count = $8,
+    errors = $9,
+    finished_at = $10,
+    duration_ms = $11,
+    updated_at = NOW()
+WHERE sync_run_id = $1
+RETURNING cancel_requested;
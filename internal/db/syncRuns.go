@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SyncStage is the persisted snapshot of one processing stage of a sync run
+type SyncStage struct {
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	JobsSubmitted int64      `json:"jobs_submitted"`
+	JobsCompleted int64      `json:"jobs_completed"`
+	JobsFailed    int64      `json:"jobs_failed"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	DurationMS    int64      `json:"duration_ms"`
+	Errors        []string   `json:"errors,omitempty"`
+}
+
+// SyncRun is the persisted snapshot of a single library sync for a user
+type SyncRun struct {
+	SyncRunId     int64        `json:"sync_run_id"`
+	UserId        string       `json:"user_id"`
+	Status        string       `json:"status"`
+	Progress      float64      `json:"progress"`
+	Stages        []*SyncStage `json:"stages"`
+	JobsSubmitted int64        `json:"jobs_submitted"`
+	JobsCompleted int64        `json:"jobs_completed"`
+	JobsFailed    int64        `json:"jobs_failed"`
+	ErrorCount    int          `json:"error_count"`
+	Errors        []string     `json:"errors"`
+	StartedAt     time.Time    `json:"started_at"`
+	FinishedAt    *time.Time   `json:"finished_at,omitempty"`
+	DurationMS    int64        `json:"duration_ms"`
+}
+
+func CreateSyncRun(ctx context.Context, userId string, status string, stages []*SyncStage, startedAt time.Time) (int64, error) {
+	logger.Debug("Attempting to create sync run", zap.String("userId", userId), zap.Int("stageCount", len(stages)))
+
+	stagesJSON, err := json.Marshal(stages)
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling sync run stages: %v", err)
+	}
+
+	sqlQuery, err := getQueryString("insert", "syncRun")
+	if err != nil {
+		return 0, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("database connection error: %v", err)
+	}
+
+	var syncRunId int64
+	err = db.QueryRow(ctx, sqlQuery, userId, status, string(stagesJSON), startedAt).Scan(&syncRunId)
+	if err != nil {
+		return 0, fmt.Errorf("error creating sync run record: %v", err)
+	}
+
+	logger.Debug("Successfully created sync run", zap.String("userId", userId), zap.Int64("syncRunId", syncRunId))
+	return syncRunId, nil
+}
+
+// UpdateSyncRun writes the snapshot of a sync run and reports whether its cancellation was requested
+func UpdateSyncRun(ctx context.Context, run *SyncRun) (bool, error) {
+	logger.Debug("Attempting to update sync run",
+		zap.Int64("syncRunId", run.SyncRunId),
+		zap.String("status", run.Status),
+		zap.Float64("progress", run.Progress))
+
+	stagesJSON, err := json.Marshal(run.Stages)
+	if err != nil {
+		return false, fmt.Errorf("error marshalling sync run stages: %v", err)
+	}
+
+	sqlQuery, err := getQueryString("update", "syncRun")
+	if err != nil {
+		return false, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return false, fmt.Errorf("database connection error: %v", err)
+	}
+
+	errorList := run.Errors
+	if errorList == nil {
+		errorList = []string{}
+	}
+
+	var cancelRequested bool
+	err = db.QueryRow(ctx, sqlQuery,
+		run.SyncRunId,
+		run.Status,
+		run.Progress,
+		string(stagesJSON),
+		run.JobsSubmitted,
+		run.JobsCompleted,
+		run.JobsFailed,
+		run.ErrorCount,
+		errorList,
+		run.FinishedAt,
+		run.DurationMS,
+	).Scan(&cancelRequested)
+	if err != nil {
+		return false, fmt.Errorf("error updating sync run record: %v", err)
+	}
+
+	return cancelRequested, nil
+}
+
+// RequestSyncCancel flags the running sync runs of a user for cancellation and returns their ids.
+// The instance running each one picks the flag up the next time it persists the run. Runs that
+// haven't been persisted within staleAfter are assumed to belong to an instance that died.
+func RequestSyncCancel(ctx context.Context, userId string, staleAfter time.Duration) ([]int64, error) {
+	logger.Debug("Requesting cancellation of sync runs", zap.String("userId", userId))
+
+	sqlQuery, err := getQueryString("update", "requestSyncCancel")
+	if err != nil {
+		return nil, fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return nil, fmt.Errorf("database connection error: %v", err)
+	}
+
+	rows, err := db.Query(ctx, sqlQuery, userId, staleAfter.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error requesting sync run cancellation: %v", err)
+	}
+	defer rows.Close()
+
+	var syncRunIds []int64
+	for rows.Next() {
+		var syncRunId int64
+		if err := rows.Scan(&syncRunId); err != nil {
+			return nil, fmt.Errorf("error scanning cancelled sync run id: %v", err)
+		}
+		syncRunIds = append(syncRunIds, syncRunId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading cancelled sync run ids: %v", err)
+	}
+	return syncRunIds, nil
+}
+
+// GetLatestSyncRun returns the most recent sync run for a user, or nil if the user has never been synced
+func GetLatestSyncRun(ctx context.Context, userId string) (*SyncRun, error) {
+	logger.Debug("Getting latest sync run", zap.String("userId", userId))
+
+	rows, err := executeSelect(ctx, "latestSyncRun", userId)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for latest sync run: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error reading latest sync run: %v", err)
+		}
+		return nil, nil
+	}
+
+	run := &SyncRun{}
+	var stagesJSON []byte
+	err = rows.Scan(
+		&run.SyncRunId,
+		&run.UserId,
+		&run.Status,
+		&run.Progress,
+		&stagesJSON,
+		&run.JobsSubmitted,
+		&run.JobsCompleted,
+		&run.JobsFailed,
+		&run.ErrorCount,
+		&run.Errors,
+		&run.StartedAt,
+		&run.FinishedAt,
+		&run.DurationMS,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning latest sync run: %v", err)
+	}
+
+	if len(stagesJSON) > 0 {
+		if err := json.Unmarshal(stagesJSON, &run.Stages); err != nil {
+			return nil, fmt.Errorf("error unmarshalling sync run stages: %v", err)
+		}
+	}
+
+	return run, nil
+}
+
+// GetSyncHighWaterMark returns the newest added_at seen for a user's source, or the zero time if none is stored
+func GetSyncHighWaterMark(ctx context.Context, userId string, source string) (time.Time, error) {
+	logger.Debug("Getting sync high water mark", zap.String("userId", userId), zap.String("source", source))
+
+	rows, err := executeSelect(ctx, "syncHighWaterMark", userId, source)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error executing select for sync high water mark: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return time.Time{}, fmt.Errorf("error reading sync high water mark: %v", err)
+		}
+		return time.Time{}, nil
+	}
+
+	var highWaterMark time.Time
+	if err := rows.Scan(&highWaterMark); err != nil {
+		return time.Time{}, fmt.Errorf("error scanning sync high water mark: %v", err)
+	}
+
+	return highWaterMark, nil
+}
+
+// SaveSyncHighWaterMark advances the stored high water mark for a user's source. It never moves backwards.
+func SaveSyncHighWaterMark(ctx context.Context, userId string, source string, highWaterMark time.Time) error {
+	logger.Debug("Attempting to save sync high water mark",
+		zap.String("userId", userId),
+		zap.String("source", source),
+		zap.Time("highWaterMark", highWaterMark))
+
+	sqlQuery, err := getQueryString("insert", "syncHighWaterMark")
+	if err != nil {
+		return fmt.Errorf("error getting query string: %v", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return fmt.Errorf("database connection error: %v", err)
+	}
+
+	// Column is TIMESTAMP without time zone, so always store UTC
+	_, err = db.Exec(ctx, sqlQuery, userId, source, highWaterMark.UTC())
+	if err != nil {
+		return fmt.Errorf("error saving sync high water mark: %v", err)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 
 	"go.uber.org/zap"
 )
@@ -22,9 +23,21 @@ type Track struct {
 	Popularity       int            `json:"popularity"`
 	DurationMS       int            `json:"duration_ms"`
 	AvailableMarkets []string       `json:"available_markets"`
+	PreviewURL       string         `json:"preview_url"`
 	AudioFeatures    *AudioFeatures `json:"audio_features"`
 	BPM              float64        `json:"bpm"`
 	TimeSignature    int            `json:"time_signature"`
+	// LinkedFromId is the id Spotify relinked this track from when it was fetched for a market
+	// where the original isn't playable
+	LinkedFromId string `json:"linked_from_id,omitempty"`
+	// ArtistNames and ISRC aren't stored. They're only set on tracks fresh from Spotify, to help
+	// tempo providers identify them.
+	ArtistNames []string `json:"-"`
+	ISRC        string   `json:"-"`
+	// Market and IsPlayable are set on tracks fetched for a market, whose AvailableMarkets Spotify
+	// leaves out. IsPlayable is whether the track can be played there.
+	Market     string `json:"-"`
+	IsPlayable *bool  `json:"-"`
 }
 
 type AudioFeatures struct {
@@ -41,9 +54,12 @@ type AudioFeatures struct {
 	Tempo             float64 `json:"tempo"`
 	Duration          int     `json:"duration_ms"`
 	TimeSignature     int     `json:"time_signature"`
+	// TempoProvider and TempoConfidence record where Tempo came from and how much it can be trusted (0-1)
+	TempoProvider   string  `json:"tempo_provider,omitempty"`
+	TempoConfidence float64 `json:"tempo_confidence,omitempty"`
 }
 
-func SaveTracks(tracks []*Track) error {
+func SaveTracks(ctx context.Context, tracks []*Track) error {
 	// TODO: remove 0 checks at db level
 	if len(tracks) == 0 {
 		logger.Debug("SaveTracks: No tracks to save.")
@@ -51,9 +67,18 @@ func SaveTracks(tracks []*Track) error {
 	}
 	logger.Debug("Attempting to save tracks", zap.Int("count", len(tracks)))
 
-	err := batchAndSave(tracks, "track", func(item any) []any {
+	err := batchAndSave(ctx, tracks, "track", func(item any) []any {
 		track := item.(*Track)
 
+		var previewURL *string
+		if track.PreviewURL != "" {
+			previewURL = &track.PreviewURL
+		}
+		var linkedFromId *string
+		if track.LinkedFromId != "" {
+			linkedFromId = &track.LinkedFromId
+		}
+
 		var audioFeaturesJSON string
 		bpm := 0.0
 		timeSignature := 0
@@ -79,20 +104,84 @@ func SaveTracks(tracks []*Track) error {
 			track.Popularity,
 			track.DurationMS,
 			track.AvailableMarkets,
+			previewURL,
 			audioFeaturesJSON,
 			bpm,
 			timeSignature,
+			linkedFromId,
 		}
 	})
 	if err != nil {
 		return fmt.Errorf("error saving tracks: %v", err)
 	}
 
+	var marketTracks []*Track
+	for _, track := range tracks {
+		if track.Market != "" && track.IsPlayable != nil {
+			marketTracks = append(marketTracks, track)
+		}
+	}
+	if len(marketTracks) > 0 {
+		err = batchAndSave(ctx, marketTracks, "trackMarket", func(item any) []any {
+			track := item.(*Track)
+			return []any{track.TrackId, track.Market, *track.IsPlayable}
+		})
+		if err != nil {
+			return fmt.Errorf("error saving track playability: %v", err)
+		}
+	}
+
 	logger.Debug("Successfully saved tracks batch", zap.Int("count", len(tracks)))
 	return nil
 }
 
-func SaveUserTopTracks(userId string, rankedTracks []*RankedTrack) error {
+// GetTrackAudioFeatures returns the stored audio features of the tracks in trackIds that have a
+// known tempo, keyed by track id
+func GetTrackAudioFeatures(ctx context.Context, trackIds []string) (map[string]*AudioFeatures, error) {
+	features := make(map[string]*AudioFeatures)
+	if len(trackIds) == 0 {
+		return features, nil
+	}
+
+	rows, err := executeSelect(ctx, "trackAudioFeatures", trackIds)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for track audio features: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trackId string
+		var audioFeaturesJSON []byte
+		var bpm float64
+		var timeSignature *int
+		if err := rows.Scan(&trackId, &audioFeaturesJSON, &bpm, &timeSignature); err != nil {
+			return nil, fmt.Errorf("error scanning track audio features: %v", err)
+		}
+
+		audioFeatures := &AudioFeatures{}
+		if len(audioFeaturesJSON) > 0 {
+			if err := json.Unmarshal(audioFeaturesJSON, audioFeatures); err != nil {
+				logger.Warn("Error unmarshalling stored audio features",
+					zap.String("trackId", trackId),
+					zap.Error(err))
+				audioFeatures = &AudioFeatures{}
+			}
+		}
+		// The bpm and time_signature columns are authoritative
+		audioFeatures.Tempo = bpm
+		if timeSignature != nil {
+			audioFeatures.TimeSignature = *timeSignature
+		}
+		features[trackId] = audioFeatures
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading track audio features: %v", err)
+	}
+
+	return features, nil
+}
+
+func SaveUserTopTracks(ctx context.Context, userId string, rankedTracks []*RankedTrack) error {
 	if len(rankedTracks) == 0 {
 		logger.Debug("SaveUserTopTracks: No tracks to save for user.", zap.String("userId", userId))
 		return nil
@@ -117,7 +206,7 @@ func SaveUserTopTracks(userId string, rankedTracks []*RankedTrack) error {
 		}
 	}
 
-	err := batchAndSave(items, "userTopTrack", func(item any) []any {
+	err := batchAndSave(ctx, items, "userTopTrack", func(item any) []any {
 		track := item.(userTopTrackWithRank)
 		return []any{
 			track.userId,
@@ -135,14 +224,26 @@ func SaveUserTopTracks(userId string, rankedTracks []*RankedTrack) error {
 	return nil
 }
 
-func SaveUserSavedTracks(userId string, tracks []*Track) error {
+// DeleteUserTopTracksExcept removes the top tracks of a user that are not in keepIds
+func DeleteUserTopTracksExcept(ctx context.Context, userId string, keepIds []string) (int64, error) {
+	deleted, err := deleteExcept(ctx, "userTopTracks", userId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale user top tracks: %v", err)
+	}
+	if deleted > 0 {
+		logger.Debug("Deleted stale user top tracks", zap.String("userId", userId), zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
+
+func SaveUserSavedTracks(ctx context.Context, userId string, tracks []*Track) error {
 	if len(tracks) == 0 {
 		logger.Debug("SaveUserSavedTracks: No tracks to save for user.", zap.String("userId", userId))
 		return nil
 	}
 	logger.Debug("Attempting to save user saved tracks", zap.String("userId", userId), zap.Int("count", len(tracks)))
 
-	err := batchAndSave(tracks, "userSavedTrack", func(item any) []any {
+	err := batchAndSave(ctx, tracks, "userSavedTrack", func(item any) []any {
 		track := item.(*Track)
 		return []any{
 			userId,
@@ -157,14 +258,78 @@ func SaveUserSavedTracks(userId string, tracks []*Track) error {
 	return nil
 }
 
-func GetTracksByBPM(userId string, min float64, max float64, sources []string) (map[string]float64, error) {
+// DeleteUserSavedTracksExcept removes the saved tracks of a user that are not in keepIds
+func DeleteUserSavedTracksExcept(ctx context.Context, userId string, keepIds []string) (int64, error) {
+	deleted, err := deleteExcept(ctx, "userSavedTracks", userId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting stale user saved tracks: %v", err)
+	}
+	if deleted > 0 {
+		logger.Debug("Deleted stale user saved tracks", zap.String("userId", userId), zap.Int64("count", deleted))
+	}
+	return deleted, nil
+}
+
+// CountUserSavedTracks returns the number of user saved tracks stored for a user
+func CountUserSavedTracks(ctx context.Context, userId string) (int, error) {
+	count, err := countRows(ctx, "userSavedTrackCount", userId)
+	if err != nil {
+		return 0, fmt.Errorf("error counting user saved tracks: %v", err)
+	}
+	return count, nil
+}
+
+// BPMRange is an inclusive range of tempos
+type BPMRange struct {
+	Min float64
+	Max float64
+}
+
+// BPMMatch is a track found by GetTracksByBPM
+type BPMMatch struct {
+	BPM float64
+	// Sources are the sources the track was found in
+	Sources []string
+	// Ranks are the best rank the track has in each ranked source it was found in, like the user's
+	// top tracks
+	Ranks map[string]int
+}
+
+// TrackRankingFeatures are the stored properties matching tracks are ranked by. Energy and
+// Danceability are 0 when unknown.
+type TrackRankingFeatures struct {
+	Popularity   int
+	DurationMS   int
+	Energy       float64
+	Danceability float64
+	// Feedback is the user's feedback on the track: 1 for liked, 0 for none
+	Feedback int
+}
+
+// GetTracksByBPM returns the user's tracks from sources whose tempo is in any of ranges. Each
+// source is a single query, however many ranges there are.
+func GetTracksByBPM(ctx context.Context, userId string, ranges []BPMRange, sources []string) (map[string]*BPMMatch, error) {
+	mins := make([]float64, len(ranges))
+	maxs := make([]float64, len(ranges))
+	for i, r := range ranges {
+		mins[i] = r.Min
+		maxs[i] = r.Max
+	}
 	logger.Debug("Getting tracks by BPM for user",
 		zap.String("userId", userId),
-		zap.Float64("minBPM", min),
-		zap.Float64("maxBPM", max),
+		zap.Float64s("minBPMs", mins),
+		zap.Float64s("maxBPMs", maxs),
 		zap.Strings("sources", sources))
 
-	tracks := make(map[string]float64)
+	// Only tracks known to be playable in the user's country are matched: those whose market list
+	// includes it, or that Spotify said were playable when they were fetched for it. An empty
+	// market list says nothing about where a track plays.
+	country, err := GetUserCountry(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting country for user %s: %v", userId, err)
+	}
+
+	tracks := make(map[string]*BPMMatch)
 	sqlFileMap := map[string]string{
 		"top_tracks":                  "topTracksByBPM",
 		"saved_tracks":                "savedTracksByBPM",
@@ -189,7 +354,7 @@ func GetTracksByBPM(userId string, min float64, max float64, sources []string) (
 			zap.String("source", source),
 			zap.String("queryName", queryName))
 
-		rows, err := executeSelect(queryName, userId, min, max)
+		rows, err := executeSelect(ctx, queryName, userId, mins, maxs, country)
 		if err != nil {
 			return nil, fmt.Errorf("error executing select for source %s: %v", source, err)
 		}
@@ -198,12 +363,28 @@ func GetTracksByBPM(userId string, min float64, max float64, sources []string) (
 		for rows.Next() {
 			var track string
 			var bpm float64
-			err := rows.Scan(&track, &bpm)
+			var rank *int
+			err := rows.Scan(&track, &bpm, &rank)
 			if err != nil {
 				rows.Close() // Ensure rows is closed on scan error
 				return nil, fmt.Errorf("error scanning track for source %s: %v", source, err)
 			}
-			tracks[track] = bpm
+			match, ok := tracks[track]
+			if !ok {
+				match = &BPMMatch{BPM: bpm}
+				tracks[track] = match
+			}
+			if !slices.Contains(match.Sources, source) {
+				match.Sources = append(match.Sources, source)
+			}
+			if rank != nil {
+				if match.Ranks == nil {
+					match.Ranks = make(map[string]int)
+				}
+				if best, ok := match.Ranks[source]; !ok || *rank < best {
+					match.Ranks[source] = *rank
+				}
+			}
 			processedRows++
 		}
 		rows.Close() // Close rows after successful iteration or if Next returns false
@@ -219,7 +400,43 @@ func GetTracksByBPM(userId string, min float64, max float64, sources []string) (
 	return tracks, nil
 }
 
-func GetTracksByTimeSignature(userId string, timeSignature int, sources []string) (map[string]int, error) {
+// GetTrackRankingFeatures returns the ranking features of the tracks with the given ids, with
+// the feedback userId gave them
+func GetTrackRankingFeatures(ctx context.Context, userId string, trackIds []string) (map[string]*TrackRankingFeatures, error) {
+	features := make(map[string]*TrackRankingFeatures)
+	if len(trackIds) == 0 {
+		return features, nil
+	}
+
+	rows, err := executeSelect(ctx, "trackRankingFeatures", userId, trackIds)
+	if err != nil {
+		return nil, fmt.Errorf("error executing select for track ranking features: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trackId string
+		var energy, danceability *float64
+		trackFeatures := &TrackRankingFeatures{}
+		if err := rows.Scan(&trackId, &trackFeatures.Popularity, &trackFeatures.DurationMS, &energy, &danceability, &trackFeatures.Feedback); err != nil {
+			return nil, fmt.Errorf("error scanning track ranking features: %v", err)
+		}
+		if energy != nil {
+			trackFeatures.Energy = *energy
+		}
+		if danceability != nil {
+			trackFeatures.Danceability = *danceability
+		}
+		features[trackId] = trackFeatures
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading track ranking features: %v", err)
+	}
+
+	return features, nil
+}
+
+func GetTracksByTimeSignature(ctx context.Context, userId string, timeSignature int, sources []string) (map[string]int, error) {
 	logger.Debug("Getting tracks by time signature for user",
 		zap.String("userId", userId),
 		zap.Int("timeSignature", timeSignature),
@@ -250,7 +467,7 @@ func GetTracksByTimeSignature(userId string, timeSignature int, sources []string
 			zap.String("source", source),
 			zap.String("queryName", queryName))
 
-		rows, err := executeSelect(queryName, userId, timeSignature)
+		rows, err := executeSelect(ctx, queryName, userId, timeSignature)
 		if err != nil {
 			return nil, fmt.Errorf("error executing select for source %s: %v", source, err)
 		}
@@ -280,7 +497,7 @@ func GetTracksByTimeSignature(userId string, timeSignature int, sources []string
 	return tracks, nil
 }
 
-func SaveFeedback(userId string, trackId string, feedback int) error {
+func SaveFeedback(ctx context.Context, userId string, trackId string, feedback int) error {
 	logger.Debug("Attempting to save feedback",
 		zap.String("userId", userId),
 		zap.String("trackId", trackId),
@@ -296,7 +513,7 @@ func SaveFeedback(userId string, trackId string, feedback int) error {
 		return fmt.Errorf("database connection error: %v", err)
 	}
 
-	_, err = db.Exec(context.Background(), sqlQuery,
+	_, err = db.Exec(ctx, sqlQuery,
 		userId,
 		trackId,
 		feedback,
@@ -2,9 +2,11 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 )
 
@@ -18,11 +20,10 @@ type User struct {
 	ImageURLs   []string `json:"image_urls"`
 }
 
-func SaveUser(user *User) (bool, error) {
-	// TODO: Save tokens
+func SaveUser(ctx context.Context, user *User) (bool, error) {
 	logger.Debug("Attempting to save user", zap.String("userId", user.UserId), zap.String("displayName", user.DisplayName))
 
-	exists, err := UserExists(user.UserId)
+	exists, err := UserExists(ctx, user.UserId)
 	if err != nil {
 		return false, fmt.Errorf("error checking if user exists: %v", err)
 	}
@@ -37,7 +38,7 @@ func SaveUser(user *User) (bool, error) {
 		return false, fmt.Errorf("database connection error: %v", err)
 	}
 
-	_, err = db.Exec(context.Background(), sqlQuery,
+	_, err = db.Exec(ctx, sqlQuery,
 		user.UserId,
 		user.Email,
 		user.DisplayName,
@@ -54,7 +55,7 @@ func SaveUser(user *User) (bool, error) {
 	return !exists, nil
 }
 
-func UserExists(userId string) (bool, error) {
+func UserExists(ctx context.Context, userId string) (bool, error) {
 	logger.Debug("Checking if user exists", zap.String("userId", userId))
 
 	db, err := getDB()
@@ -63,7 +64,7 @@ func UserExists(userId string) (bool, error) {
 	}
 
 	var count int
-	err = db.QueryRow(context.Background(), `SELECT COUNT(*) FROM "user" WHERE user_id = $1`, userId).Scan(&count)
+	err = db.QueryRow(ctx, `SELECT COUNT(*) FROM "user" WHERE user_id = $1`, userId).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("error checking user existence: %v", err)
 	}
@@ -71,7 +72,7 @@ func UserExists(userId string) (bool, error) {
 	return count > 0, nil
 }
 
-func GetUserUpdatedAt(userId string) (time.Time, error) {
+func GetUserUpdatedAt(ctx context.Context, userId string) (time.Time, error) {
 	logger.Debug("Getting user updated_at", zap.String("userId", userId))
 
 	db, err := getDB()
@@ -80,10 +81,32 @@ func GetUserUpdatedAt(userId string) (time.Time, error) {
 	}
 
 	var updatedAt time.Time
-	err = db.QueryRow(context.Background(), `SELECT updated_at FROM "user" WHERE user_id = $1`, userId).Scan(&updatedAt)
+	err = db.QueryRow(ctx, `SELECT updated_at FROM "user" WHERE user_id = $1`, userId).Scan(&updatedAt)
 	if err != nil {
 		return time.Time{}, fmt.Errorf("error getting user updated_at: %v", err)
 	}
 
 	return updatedAt, nil
 }
+
+// GetUserCountry returns the market of a user as an ISO 3166-1 alpha-2 code, or "" if Spotify didn't
+// share it or the user hasn't been saved yet
+func GetUserCountry(ctx context.Context, userId string) (string, error) {
+	logger.Debug("Getting user country", zap.String("userId", userId))
+
+	db, err := getDB()
+	if err != nil {
+		return "", fmt.Errorf("database connection error: %v", err)
+	}
+
+	var country string
+	err = db.QueryRow(ctx, `SELECT COALESCE(country, '') FROM "user" WHERE user_id = $1`, userId).Scan(&country)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting user country: %v", err)
+	}
+
+	return country, nil
+}
@@ -11,11 +11,20 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Pool is the part of *pgxpool.Pool the package uses. SetPool swaps it out, e.g. for a fake in tests.
+type Pool interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 var (
-	dbPool    *pgxpool.Pool
+	dbPool    Pool
 	dbOnce    sync.Once
 	initError error
 )
@@ -65,7 +74,14 @@ func initDB() error {
 	return nil
 }
 
-func getDB() (*pgxpool.Pool, error) {
+// SetPool makes the package use pool instead of connecting to the database from the DB_* environment
+func SetPool(pool Pool) {
+	dbOnce.Do(func() {})
+	dbPool = pool
+	initError = nil
+}
+
+func getDB() (Pool, error) {
 	dbOnce.Do(func() {
 		initError = initDB()
 	})
@@ -90,7 +106,7 @@ func processBatchResults(br pgx.BatchResults, count int) error {
 	return br.Close()
 }
 
-func batchAndSave(items any, queryFilename string, paramConverter func(item any) []any) error {
+func batchAndSave(ctx context.Context, items any, queryFilename string, paramConverter func(item any) []any) error {
 	sqlQuery, err := getQueryString("insert", queryFilename)
 	if err != nil {
 		return fmt.Errorf("failed to get SQL query string: %w", err)
@@ -101,7 +117,6 @@ func batchAndSave(items any, queryFilename string, paramConverter func(item any)
 		return fmt.Errorf("database connection error: %w", err)
 	}
 
-	ctx := context.Background()
 	tx, err := db.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("error starting transaction: %w", err)
@@ -159,7 +174,7 @@ func batchAndSave(items any, queryFilename string, paramConverter func(item any)
 	return nil
 }
 
-func executeSelect(queryFilename string, args ...any) (pgx.Rows, error) {
+func executeSelect(ctx context.Context, queryFilename string, args ...any) (pgx.Rows, error) {
 	sqlQuery, err := getQueryString("select", queryFilename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get SQL query string: %w", err)
@@ -170,7 +185,6 @@ func executeSelect(queryFilename string, args ...any) (pgx.Rows, error) {
 		return nil, fmt.Errorf("database connection error: %w", err)
 	}
 
-	ctx := context.Background()
 	rows, err := db.Query(ctx, sqlQuery, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error executing query: %w", err)
@@ -186,3 +200,46 @@ func getQueryString(queryType string, queryFilename string) (string, error) {
 	}
 	return string(sqlBytes), nil
 }
+
+// deleteExcept runs a delete query that removes an owner's relation rows whose id is not in keepIds,
+// returning the number of rows removed
+func deleteExcept(ctx context.Context, queryFilename string, ownerId string, keepIds []string) (int64, error) {
+	sqlQuery, err := getQueryString("delete", queryFilename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get SQL query string: %w", err)
+	}
+
+	db, err := getDB()
+	if err != nil {
+		return 0, fmt.Errorf("database connection error: %w", err)
+	}
+
+	if keepIds == nil {
+		keepIds = []string{}
+	}
+	tag, err := db.Exec(ctx, sqlQuery, ownerId, keepIds)
+	if err != nil {
+		return 0, fmt.Errorf("error executing delete: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// countRows runs a select query returning a single count
+func countRows(ctx context.Context, queryFilename string, args ...any) (int, error) {
+	rows, err := executeSelect(ctx, queryFilename, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, fmt.Errorf("error scanning count: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error reading count: %w", err)
+	}
+	return count, nil
+}
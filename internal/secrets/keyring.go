@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNoKeys is returned by LoadKeyring when TOKEN_ENCRYPTION_KEYS is not set
+var ErrNoKeys = errors.New("TOKEN_ENCRYPTION_KEYS must be set")
+
+// Keyring encrypts secrets with AES-GCM under a set of versioned keys. New secrets are always
+// encrypted with the highest version; older versions are kept so existing secrets can still be
+// decrypted until they are re-encrypted.
+type Keyring struct {
+	keys    map[int]cipher.AEAD
+	current int
+}
+
+var (
+	keyring     *Keyring
+	keyringErr  error
+	keyringOnce sync.Once
+)
+
+// LoadKeyring returns the keyring configured by TOKEN_ENCRYPTION_KEYS
+func LoadKeyring() (*Keyring, error) {
+	keyringOnce.Do(func() {
+		spec := os.Getenv("TOKEN_ENCRYPTION_KEYS")
+		if spec == "" {
+			keyringErr = ErrNoKeys
+			return
+		}
+		keyring, keyringErr = NewKeyring(spec)
+	})
+	return keyring, keyringErr
+}
+
+// NewKeyring parses a comma-separated list of version:base64key pairs, e.g. "1:<key>,2:<key>".
+// Keys must decode to 16, 24 or 32 bytes.
+func NewKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[int]cipher.AEAD)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		versionStr, encodedKey, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("key entry must be version:base64key")
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid key version %q", versionStr)
+		}
+		if _, exists := k.keys[version]; exists {
+			return nil, fmt.Errorf("duplicate key version %d", version)
+		}
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key version %d: %w", version, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", version, err)
+		}
+		k.keys[version] = aead
+		if version > k.current {
+			k.current = version
+		}
+	}
+	if len(k.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return k, nil
+}
+
+// CurrentVersion is the key version new secrets are encrypted with
+func (k *Keyring) CurrentVersion() int {
+	return k.current
+}
+
+// Encrypt seals plaintext with the current key. additionalData is authenticated but not
+// encrypted, and must be passed again to Decrypt; use it to bind the secret to its owner.
+func (k *Keyring) Encrypt(plaintext []byte, additionalData []byte) ([]byte, int, error) {
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, 0, fmt.Errorf("generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), k.current, nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt with the given key version
+func (k *Keyring) Decrypt(ciphertext []byte, version int, additionalData []byte) ([]byte, error) {
+	aead, ok := k.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown key version %d", version)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting with key version %d: %w", version, err)
+	}
+	return plaintext, nil
+}
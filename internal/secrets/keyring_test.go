@@ -0,0 +1,142 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(size int, fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, size))
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		current int
+		err     string
+	}{
+		{name: "single key", spec: "1:" + testKey(32, 1), current: 1},
+		{name: "highest version is current", spec: "2:" + testKey(16, 2) + ", 1:" + testKey(24, 1) + ",", current: 2},
+		{name: "empty", spec: " , ", err: ErrNoKeys.Error()},
+		{name: "missing version", spec: testKey(32, 1), err: "version:base64key"},
+		{name: "invalid version", spec: "one:" + testKey(32, 1), err: "invalid key version"},
+		{name: "zero version", spec: "0:" + testKey(32, 1), err: "invalid key version"},
+		{name: "duplicate version", spec: "1:" + testKey(32, 1) + ",1:" + testKey(32, 2), err: "duplicate key version 1"},
+		{name: "invalid base64", spec: "1:not base64!", err: "decoding key version 1"},
+		{name: "wrong key size", spec: "1:" + testKey(20, 1), err: "key version 1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyring, err := NewKeyring(test.spec)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("NewKeyring(%q) returned error %v, want one containing %q", test.spec, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewKeyring(%q) returned error %v", test.spec, err)
+			}
+			if keyring.CurrentVersion() != test.current {
+				t.Errorf("current version = %d, want %d", keyring.CurrentVersion(), test.current)
+			}
+		})
+	}
+}
+
+func TestNewKeyringNoKeys(t *testing.T) {
+	if _, err := NewKeyring(""); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("NewKeyring(\"\") returned %v, want %v", err, ErrNoKeys)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring, err := NewKeyring("1:" + testKey(32, 1))
+	if err != nil {
+		t.Fatalf("creating keyring: %v", err)
+	}
+
+	ciphertext, version, err := keyring.Encrypt([]byte("refresh-token"), []byte("alice"))
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+	if version != 1 {
+		t.Errorf("encrypted with version %d, want 1", version)
+	}
+	if bytes.Contains(ciphertext, []byte("refresh-token")) {
+		t.Error("ciphertext contains the plaintext")
+	}
+
+	plaintext, err := keyring.Decrypt(ciphertext, version, []byte("alice"))
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+	if string(plaintext) != "refresh-token" {
+		t.Errorf("decrypted %q, want %q", plaintext, "refresh-token")
+	}
+
+	// Each encryption uses a fresh nonce
+	again, _, err := keyring.Encrypt([]byte("refresh-token"), []byte("alice"))
+	if err != nil {
+		t.Fatalf("encrypting again: %v", err)
+	}
+	if bytes.Equal(again, ciphertext) {
+		t.Error("encrypting twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	keyring, err := NewKeyring("1:" + testKey(32, 1))
+	if err != nil {
+		t.Fatalf("creating keyring: %v", err)
+	}
+	ciphertext, version, err := keyring.Encrypt([]byte("refresh-token"), []byte("alice"))
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+
+	if _, err := keyring.Decrypt(ciphertext, version, []byte("bob")); err == nil {
+		t.Error("decrypting with another user's additional data succeeded")
+	}
+	if _, err := keyring.Decrypt(ciphertext, 2, []byte("alice")); err == nil {
+		t.Error("decrypting with an unknown key version succeeded")
+	}
+	if _, err := keyring.Decrypt(ciphertext[:4], version, []byte("alice")); err == nil {
+		t.Error("decrypting a truncated ciphertext succeeded")
+	}
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	oldKeyring, err := NewKeyring("1:" + testKey(32, 1))
+	if err != nil {
+		t.Fatalf("creating keyring: %v", err)
+	}
+	ciphertext, version, err := oldKeyring.Encrypt([]byte("refresh-token"), []byte("alice"))
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+
+	rotated, err := NewKeyring("1:" + testKey(32, 1) + ",2:" + testKey(32, 2))
+	if err != nil {
+		t.Fatalf("creating rotated keyring: %v", err)
+	}
+	plaintext, err := rotated.Decrypt(ciphertext, version, []byte("alice"))
+	if err != nil {
+		t.Fatalf("decrypting with the old key version: %v", err)
+	}
+	if string(plaintext) != "refresh-token" {
+		t.Errorf("decrypted %q, want %q", plaintext, "refresh-token")
+	}
+
+	_, newVersion, err := rotated.Encrypt(plaintext, []byte("alice"))
+	if err != nil {
+		t.Fatalf("re-encrypting: %v", err)
+	}
+	if newVersion != 2 {
+		t.Errorf("re-encrypted with version %d, want 2", newVersion)
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -12,9 +13,10 @@ import (
 
 type SaveAlbumTracksJob struct {
 	AlbumId string
+	Market  string
 }
 
-func createAlbumBatcher(parentType string, parentId string, tracker *ProcessedTracker, saveRelation func(string, []*db.Album) error) *spotify.BatchProcessor[*spotify.Album] {
+func createAlbumBatcher(ctx context.Context, parentType string, parentId string, tracker *ProcessedTracker, saveRelation func(context.Context, string, []*db.Album) error) *spotify.BatchProcessor[*spotify.Album] {
 	return spotify.NewBatchProcessor(100, func(albums []*spotify.Album) error {
 		dbAlbums := convertSpotifyAlbumsToDBAlbums(albums)
 		var albumsToSave []*db.Album
@@ -25,14 +27,14 @@ func createAlbumBatcher(parentType string, parentId string, tracker *ProcessedTr
 		}
 
 		if len(albumsToSave) > 0 {
-			if err := db.SaveAlbums(albumsToSave); err != nil {
+			if err := db.SaveAlbums(ctx, albumsToSave); err != nil {
 				return fmt.Errorf("saving albums batch: %w", err)
 			}
 			logger.Debug("Saved batch of albums to DB",
 				zap.String(parentType, parentId))
 		}
 
-		if err := saveRelation(parentId, dbAlbums); err != nil {
+		if err := saveRelation(ctx, parentId, dbAlbums); err != nil {
 			return fmt.Errorf("saving album relation: %w", err)
 		}
 
@@ -42,15 +44,21 @@ func createAlbumBatcher(parentType string, parentId string, tracker *ProcessedTr
 	})
 }
 
-func (j *SaveAlbumTracksJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
+func (j *SaveAlbumTracksJob) Execute(ctx context.Context, pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
 	albumId := j.AlbumId
 
 	logger.Debug("Executing SaveAlbumTracksJob",
 		zap.String("albumId", albumId))
 
-	trackBatcher := createTrackBatcher("album", albumId, tracker, db.SaveAlbumTracks)
+	if isFresh(ctx, db.CrawlAlbumTracks, albumId, j.Market) {
+		logger.Debug("Skipping SaveAlbumTracksJob, tracks were synced recently",
+			zap.String("albumId", albumId))
+		return nil
+	}
+
+	trackBatcher := createTrackBatcher(ctx, "album", albumId, tracker, db.SaveAlbumTracks)
 
-	err := spotify.GetAlbumsTracks(albumId, func(tracks []*spotify.Track) error {
+	err := spotifyClient().GetAlbumsTracks(ctx, albumId, j.Market, func(tracks []*spotify.Track) error {
 		for _, track := range tracks {
 			if err := trackBatcher.Add(track); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
@@ -65,25 +73,32 @@ func (j *SaveAlbumTracksJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup, tr
 	if err := trackBatcher.Flush(); err != nil {
 		return fmt.Errorf("flushing remaining tracks for album %s: %w", albumId, err)
 	}
+	markSynced(ctx, db.CrawlAlbumTracks, albumId, j.Market)
 
 	logger.Debug("Executed SaveAlbumTracksJob",
 		zap.String("albumId", albumId))
 	return nil
 }
 
-func processSavedAlbums(userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
+func processSavedAlbums(ctx context.Context, userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
 	logger.Debug("Processing user saved albums",
 		zap.String("userId", userId))
 
-	albumBatcher := createAlbumBatcher("user", userId, tracker, db.SaveUserSavedAlbums)
+	albumBatcher := createAlbumBatcher(ctx, "user", userId, tracker, db.SaveUserSavedAlbums)
 
-	err := spotify.GetUsersSavedAlbums(token, func(albums []*spotify.Album) error {
+	since := getHighWaterMark(ctx, userId, syncSourceSavedAlbums)
+	var seenIds []string
+	newest, total, err := spotifyClient().GetUsersSavedAlbums(ctx, token, since, func(albums []*spotify.Album) error {
 		for _, album := range albums {
+			if album.Id != "" {
+				seenIds = append(seenIds, album.Id)
+			}
 			if err := albumBatcher.Add(album); err != nil {
 				return fmt.Errorf("adding album to batch: %w", err)
 			}
 			pool.SubmitWithStage(&SaveAlbumTracksJob{
 				AlbumId: album.Id,
+				Market:  stage.run.market,
 			}, jobWg, stage)
 		}
 
@@ -99,7 +114,25 @@ func processSavedAlbums(userId string, token string, pool *WorkerPool, tracker *
 		return fmt.Errorf("flushing remaining albums: %w", err)
 	}
 
+	// Only advance once everything up to newest, including the jobs it queued, has been saved
+	stage.onJobsDone(func(ctx context.Context) error {
+		return advanceHighWaterMark(ctx, userId, syncSourceSavedAlbums, since, newest)
+	})
+
+	if since.IsZero() {
+		err = reconcile(ctx, "user saved albums", userId, seenIds, db.DeleteUserSavedAlbumsExcept)
+	} else {
+		err = reconcileIncremental(ctx, "user saved albums", userId, total,
+			db.CountUserSavedAlbums, func(ctx context.Context) ([]string, error) {
+				return spotifyClient().GetUsersSavedAlbumIds(ctx, token)
+			}, db.DeleteUserSavedAlbumsExcept)
+	}
+	if err != nil {
+		return err
+	}
+
 	logger.Debug("Processed user saved albums",
-		zap.String("userId", userId))
+		zap.String("userId", userId),
+		zap.Time("since", since))
 	return nil
 }
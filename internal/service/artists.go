@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -20,13 +21,16 @@ const (
 type SaveArtistTopTracksJob struct {
 	ArtistId string
 	Type     ArtistType
+	// Market is the country the tracks are fetched for, or "" for none
+	Market string
 }
 
 type SaveArtistAlbumsJob struct {
 	ArtistId string
+	Market   string
 }
 
-func createArtistBatcher(userId string, tracker *ProcessedTracker, saveRelation func(string, []*db.Artist) error) *spotify.BatchProcessor[*spotify.Artist] {
+func createArtistBatcher(ctx context.Context, userId string, tracker *ProcessedTracker, saveRelation func(context.Context, string, []*db.Artist) error) *spotify.BatchProcessor[*spotify.Artist] {
 	return spotify.NewBatchProcessor(100, func(artists []*spotify.Artist) error {
 		dbArtists := convertSpotifyArtistsToDBArtists(artists)
 		var artistsToSave []*db.Artist
@@ -37,14 +41,14 @@ func createArtistBatcher(userId string, tracker *ProcessedTracker, saveRelation
 		}
 
 		if len(artistsToSave) > 0 {
-			if err := db.SaveArtists(artistsToSave); err != nil {
+			if err := db.SaveArtists(ctx, artistsToSave); err != nil {
 				return fmt.Errorf("saving artists batch: %w", err)
 			}
 			logger.Debug("Saved batch of artists to DB",
 				zap.String("userId", userId))
 		}
 
-		if err := saveRelation(userId, dbArtists); err != nil {
+		if err := saveRelation(ctx, userId, dbArtists); err != nil {
 			return fmt.Errorf("saving user-artist relations: %w", err)
 		}
 
@@ -54,8 +58,8 @@ func createArtistBatcher(userId string, tracker *ProcessedTracker, saveRelation
 	})
 }
 
-func createRankedArtistBatcher(userId string, tracker *ProcessedTracker,
-	saveRelation func(string, []*db.RankedArtist) error, rankCounter *int) *spotify.BatchProcessor[*spotify.Artist] {
+func createRankedArtistBatcher(ctx context.Context, userId string, tracker *ProcessedTracker,
+	saveRelation func(context.Context, string, []*db.RankedArtist) error, rankCounter *int) *spotify.BatchProcessor[*spotify.Artist] {
 
 	return spotify.NewBatchProcessor(100, func(artists []*spotify.Artist) error {
 		dbArtists := convertSpotifyArtistsToDBArtists(artists)
@@ -74,14 +78,14 @@ func createRankedArtistBatcher(userId string, tracker *ProcessedTracker,
 		}
 
 		if len(artistsToSave) > 0 {
-			if err := db.SaveArtists(artistsToSave); err != nil {
+			if err := db.SaveArtists(ctx, artistsToSave); err != nil {
 				return fmt.Errorf("saving artists batch: %w", err)
 			}
 			logger.Debug("Saved batch of artists to DB",
 				zap.String("userId", userId))
 		}
 
-		if err := saveRelation(userId, rankedArtists); err != nil {
+		if err := saveRelation(ctx, userId, rankedArtists); err != nil {
 			return fmt.Errorf("saving user-artist relations: %w", err)
 		}
 
@@ -93,23 +97,29 @@ func createRankedArtistBatcher(userId string, tracker *ProcessedTracker,
 	})
 }
 
-func (j *SaveArtistTopTracksJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
+func (j *SaveArtistTopTracksJob) Execute(ctx context.Context, pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
 	artistId := j.ArtistId
 
 	logger.Debug("Executing SaveArtistTopTracksJob",
 		zap.String("artistId", artistId))
 
+	if isFresh(ctx, db.CrawlArtistTopTracks, artistId, j.Market) {
+		logger.Debug("Skipping SaveArtistTopTracksJob, top tracks were synced recently",
+			zap.String("artistId", artistId))
+		return nil
+	}
+
 	// Initialize rank counter for this artist's top tracks
 	rankCounter := 0
 
 	// Create a wrapper function that converts RankedTrack to the expected format
-	saveRankedTracks := func(artistId string, rankedTracks []*db.RankedTrack) error {
-		return db.SaveArtistTopTracks(artistId, rankedTracks)
+	saveRankedTracks := func(ctx context.Context, artistId string, rankedTracks []*db.RankedTrack) error {
+		return db.SaveArtistTopTracks(ctx, artistId, rankedTracks)
 	}
 
-	trackBatcher := createRankedTrackBatcher("artist", artistId, tracker, saveRankedTracks, &rankCounter)
+	trackBatcher := createRankedTrackBatcher(ctx, "artist", artistId, tracker, saveRankedTracks, &rankCounter)
 
-	err := spotify.GetArtistsTopTracks(artistId, func(tracks []*spotify.Track) error {
+	err := spotifyClient().GetArtistsTopTracks(ctx, artistId, j.Market, func(tracks []*spotify.Track) error {
 		for _, track := range tracks {
 			if err := trackBatcher.Add(track); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
@@ -124,6 +134,7 @@ func (j *SaveArtistTopTracksJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup
 	if err := trackBatcher.Flush(); err != nil {
 		return fmt.Errorf("flushing track batcher: %w", err)
 	}
+	markSynced(ctx, db.CrawlArtistTopTracks, artistId, j.Market)
 
 	logger.Debug("Executed SaveArtistTopTracksJob",
 		zap.String("artistId", artistId),
@@ -131,21 +142,28 @@ func (j *SaveArtistTopTracksJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup
 	return nil
 }
 
-func (j *SaveArtistAlbumsJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
+func (j *SaveArtistAlbumsJob) Execute(ctx context.Context, pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
 	artistId := j.ArtistId
 
 	logger.Debug("Executing SaveArtistAlbumsJob",
 		zap.String("artistId", artistId))
 
-	albumBatcher := createAlbumBatcher("artist", artistId, tracker, db.SaveArtistAlbums)
+	if isFresh(ctx, db.CrawlArtistAlbums, artistId, j.Market) {
+		logger.Debug("Skipping SaveArtistAlbumsJob, albums were synced recently",
+			zap.String("artistId", artistId))
+		return nil
+	}
+
+	albumBatcher := createAlbumBatcher(ctx, "artist", artistId, tracker, db.SaveArtistAlbums)
 
-	err := spotify.GetArtistsAlbumsAndSingles(artistId, func(albums []*spotify.Album) error {
+	err := spotifyClient().GetArtistsAlbumsAndSingles(ctx, artistId, j.Market, func(albums []*spotify.Album) error {
 		for _, album := range albums {
 			if err := albumBatcher.Add(album); err != nil {
 				return fmt.Errorf("adding album to batch: %w", err)
 			}
 			pool.SubmitWithStage(&SaveAlbumTracksJob{
 				AlbumId: album.Id,
+				Market:  j.Market,
 			}, jobWg, stage)
 		}
 
@@ -160,13 +178,14 @@ func (j *SaveArtistAlbumsJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup, t
 	if err := albumBatcher.Flush(); err != nil {
 		return fmt.Errorf("flushing remaining albums for artist %s: %w", artistId, err)
 	}
+	markSynced(ctx, db.CrawlArtistAlbums, artistId, j.Market)
 
 	logger.Debug("Executed SaveArtistAlbumsJob",
 		zap.String("artistId", artistId))
 	return nil
 }
 
-func processTopArtists(userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
+func processTopArtists(ctx context.Context, userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
 	logger.Debug("Getting user's top artists",
 		zap.String("userId", userId))
 
@@ -174,23 +193,29 @@ func processTopArtists(userId string, token string, pool *WorkerPool, tracker *P
 	rankCounter := 0
 
 	// Create a wrapper function that converts RankedArtist to the expected format
-	saveRankedArtists := func(userId string, rankedArtists []*db.RankedArtist) error {
-		return db.SaveUserTopArtists(userId, rankedArtists)
+	saveRankedArtists := func(ctx context.Context, userId string, rankedArtists []*db.RankedArtist) error {
+		return db.SaveUserTopArtists(ctx, userId, rankedArtists)
 	}
 
-	artistBatcher := createRankedArtistBatcher(userId, tracker, saveRankedArtists, &rankCounter)
+	artistBatcher := createRankedArtistBatcher(ctx, userId, tracker, saveRankedArtists, &rankCounter)
 
-	err := spotify.GetUsersTopArtists(token, func(artists []*spotify.Artist) error {
+	var seenIds []string
+	err := spotifyClient().GetUsersTopArtists(ctx, token, func(artists []*spotify.Artist) error {
 		for _, artist := range artists {
+			if artist.Id != "" {
+				seenIds = append(seenIds, artist.Id)
+			}
 			if err := artistBatcher.Add(artist); err != nil {
 				return fmt.Errorf("adding artist to batch: %w", err)
 			}
 			pool.SubmitWithStage(&SaveArtistTopTracksJob{
 				ArtistId: artist.Id,
 				Type:     TopArtists,
+				Market:   stage.run.market,
 			}, jobWg, stage)
 			pool.SubmitWithStage(&SaveArtistAlbumsJob{
 				ArtistId: artist.Id,
+				Market:   stage.run.market,
 			}, jobWg, stage)
 		}
 
@@ -206,30 +231,40 @@ func processTopArtists(userId string, token string, pool *WorkerPool, tracker *P
 		return fmt.Errorf("flushing remaining artists: %w", err)
 	}
 
+	if err := reconcile(ctx, "user top artists", userId, seenIds, db.DeleteUserTopArtistsExcept); err != nil {
+		return err
+	}
+
 	logger.Debug("Processed user's top artists",
 		zap.String("userId", userId),
 		zap.Int("totalRanked", rankCounter))
 	return nil
 }
 
-func processFollowedArtists(userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
+func processFollowedArtists(ctx context.Context, userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
 	logger.Debug("Getting user's followed artists",
 		zap.String("userId", userId))
 
 	// Note: followed artists typically don't have ranking, so using the original batcher
-	artistBatcher := createArtistBatcher(userId, tracker, db.SaveUserFollowedArtists)
+	artistBatcher := createArtistBatcher(ctx, userId, tracker, db.SaveUserFollowedArtists)
 
-	err := spotify.GetUsersFollowedArtists(token, func(artists []*spotify.Artist) error {
+	var seenIds []string
+	err := spotifyClient().GetUsersFollowedArtists(ctx, token, func(artists []*spotify.Artist) error {
 		for _, artist := range artists {
+			if artist.Id != "" {
+				seenIds = append(seenIds, artist.Id)
+			}
 			if err := artistBatcher.Add(artist); err != nil {
 				return fmt.Errorf("adding artist to batch: %w", err)
 			}
 			pool.SubmitWithStage(&SaveArtistTopTracksJob{
 				ArtistId: artist.Id,
 				Type:     FollowedArtists,
+				Market:   stage.run.market,
 			}, jobWg, stage)
 			pool.SubmitWithStage(&SaveArtistAlbumsJob{
 				ArtistId: artist.Id,
+				Market:   stage.run.market,
 			}, jobWg, stage)
 		}
 
@@ -245,6 +280,10 @@ func processFollowedArtists(userId string, token string, pool *WorkerPool, track
 		return fmt.Errorf("flushing remaining artists: %w", err)
 	}
 
+	if err := reconcile(ctx, "user followed artists", userId, seenIds, db.DeleteUserFollowedArtistsExcept); err != nil {
+		return err
+	}
+
 	logger.Debug("Processed user's followed artists",
 		zap.String("userId", userId))
 	return nil
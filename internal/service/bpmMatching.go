@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+// Tempo multiples a track may be matched at, besides its own tempo. Runners step twice per beat
+// of a half-time track and walkers once per two beats of a double-time one; the triplet ratios
+// fit three steps to two beats and the other way around.
+var (
+	halfDoubleRatios = []float64{0.5, 2}
+	tripletRatios    = []float64{2.0 / 3.0, 1.5}
+)
+
+// TrackMatch is a track matched to a target tempo. Ratio is the track's tempo divided by the
+// tempo it was matched for, e.g. 0.5 for an 85 BPM track matched at 170.
+type TrackMatch struct {
+	*db.BPMMatch
+	Ratio float64
+}
+
+// parseTempoMultiples parses the multiples query parameter into the ratios to match besides 1:
+// "none" or "" for none, "half_double" for ½× and 2×, and "all" to also match ⅔× and 1.5×
+func parseTempoMultiples(multiples string) ([]float64, error) {
+	switch strings.ToLower(strings.TrimSpace(multiples)) {
+	case "", "none":
+		return nil, nil
+	case "half_double":
+		return halfDoubleRatios, nil
+	case "all":
+		return append(append([]float64{}, halfDoubleRatios...), tripletRatios...), nil
+	default:
+		return nil, fmt.Errorf("unknown multiples %q, expected none, half_double or all", multiples)
+	}
+}
+
+// matchTracksByBPM finds the user's tracks between min and max BPM, and those between min and
+// max scaled by each of ratios, in one pass over the sources. A track matching more than one
+// ratio keeps the first: its own tempo, then ratios in order.
+func matchTracksByBPM(ctx context.Context, userId string, min float64, max float64, ratios []float64, sources []string) (map[string]*TrackMatch, error) {
+	ratios = append([]float64{1}, ratios...)
+	ranges := make([]db.BPMRange, len(ratios))
+	for i, ratio := range ratios {
+		ranges[i] = db.BPMRange{Min: min * ratio, Max: max * ratio}
+	}
+
+	tracks, err := db.GetTracksByBPM(ctx, userId, ranges, sources)
+	if err != nil {
+		return nil, fmt.Errorf("getting tracks by BPM: %w", err)
+	}
+
+	matches := make(map[string]*TrackMatch, len(tracks))
+	for trackId, match := range tracks {
+		ratio, ok := matchingRatio(match.BPM, ranges, ratios)
+		if !ok {
+			continue
+		}
+		matches[trackId] = &TrackMatch{BPMMatch: match, Ratio: ratio}
+	}
+	logger.Debug("Matched tracks at tempo multiples",
+		zap.String("userId", userId),
+		zap.Float64s("ratios", ratios),
+		zap.Int("count", len(matches)))
+	return matches, nil
+}
+
+// matchingRatio returns the first of ratios whose range contains bpm
+func matchingRatio(bpm float64, ranges []db.BPMRange, ratios []float64) (float64, bool) {
+	for i, r := range ranges {
+		if bpm >= r.Min && bpm <= r.Max {
+			return ratios[i], true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+func TestParseTempoMultiples(t *testing.T) {
+	tests := []struct {
+		multiples string
+		wanted    []float64
+		wantErr   bool
+	}{
+		{multiples: "", wanted: nil},
+		{multiples: "none", wanted: nil},
+		{multiples: " None ", wanted: nil},
+		{multiples: "half_double", wanted: []float64{0.5, 2}},
+		{multiples: "HALF_DOUBLE", wanted: []float64{0.5, 2}},
+		{multiples: "all", wanted: []float64{0.5, 2, 2.0 / 3.0, 1.5}},
+		{multiples: "double", wantErr: true},
+	}
+	for _, test := range tests {
+		ratios, err := parseTempoMultiples(test.multiples)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseTempoMultiples(%q) = %v, want an error", test.multiples, ratios)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTempoMultiples(%q) returned %v", test.multiples, err)
+			continue
+		}
+		if !slices.Equal(ratios, test.wanted) {
+			t.Errorf("parseTempoMultiples(%q) = %v, want %v", test.multiples, ratios, test.wanted)
+		}
+	}
+
+	// Callers may append to the ratios, which mustn't change the shared ones
+	ratios, _ := parseTempoMultiples("all")
+	ratios[0] = 3
+	if halfDoubleRatios[0] != 0.5 {
+		t.Errorf("changing the ratios of all changed the half/double ratios to %v", halfDoubleRatios)
+	}
+}
+
+// bpmMatchPool is a fakePool that answers the top tracks BPM query with fixed tracks
+type bpmMatchPool struct {
+	*fakePool
+	bpms map[string]float64
+}
+
+func (p *bpmMatchPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if !strings.Contains(sql, `FROM "user_top_track"`) {
+		return p.fakePool.Query(ctx, sql, args...)
+	}
+	p.record(sql, args)
+	rows := &bpmRows{}
+	for trackId, bpm := range p.bpms {
+		rows.tracks = append(rows.tracks, trackId)
+		rows.bpms = append(rows.bpms, bpm)
+	}
+	return rows, nil
+}
+
+// bpmRows are rows of track id, BPM and rank
+type bpmRows struct {
+	pgx.Rows
+	tracks []string
+	bpms   []float64
+	next   int
+}
+
+func (r *bpmRows) Next() bool {
+	r.next++
+	return r.next <= len(r.tracks)
+}
+
+func (r *bpmRows) Scan(dest ...any) error {
+	*dest[0].(*string) = r.tracks[r.next-1]
+	*dest[1].(*float64) = r.bpms[r.next-1]
+	*dest[2].(**int) = nil
+	return nil
+}
+
+func (r *bpmRows) Err() error { return nil }
+func (r *bpmRows) Close()     {}
+
+func TestMatchTracksByBPMKeepsFirstMatchingRatio(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+
+	// Between 100 and 180 BPM the ranges overlap: 1× is 100-180, ½× 50-90, 2× 200-360,
+	// ⅔× 66.7-120 and 1.5× 150-270
+	pool := &bpmMatchPool{
+		fakePool: &fakePool{},
+		bpms: map[string]float64{
+			"own-and-two-thirds":  110,
+			"own-and-one-half":    160,
+			"half-and-two-thirds": 80,
+			"double-and-one-half": 250,
+			"two-thirds":          95,
+			"none":                40,
+		},
+	}
+	db.SetPool(pool)
+
+	ratios, _ := parseTempoMultiples("all")
+	matches, err := matchTracksByBPM(context.Background(), "alice", 100, 180, ratios, []string{"top_tracks"})
+	if err != nil {
+		t.Fatalf("matching tracks: %v", err)
+	}
+
+	wanted := map[string]float64{
+		"own-and-two-thirds":  1,
+		"own-and-one-half":    1,
+		"half-and-two-thirds": 0.5,
+		"double-and-one-half": 2,
+		"two-thirds":          2.0 / 3.0,
+	}
+	if len(matches) != len(wanted) {
+		t.Errorf("matched %d tracks, want %d", len(matches), len(wanted))
+	}
+	for trackId, ratio := range wanted {
+		match, ok := matches[trackId]
+		if !ok {
+			t.Errorf("track %s wasn't matched", trackId)
+			continue
+		}
+		if match.Ratio != ratio {
+			t.Errorf("track %s matched at %gx, want %gx", trackId, match.Ratio, ratio)
+		}
+	}
+
+	// Every range is fetched by the one query of the source
+	queries := pool.matching(`FROM "user_top_track"`)
+	if len(queries) != 1 {
+		t.Fatalf("ran %d top tracks queries, want 1", len(queries))
+	}
+	mins, maxs := queries[0].args[1].([]float64), queries[0].args[2].([]float64)
+	if len(mins) != 5 || len(maxs) != 5 || mins[0] != 100 || maxs[0] != 180 || mins[1] != 50 || maxs[2] != 360 {
+		t.Errorf("queried ranges from %v to %v, want the 1×, ½×, 2×, ⅔× and 1.5× ranges", mins, maxs)
+	}
+}
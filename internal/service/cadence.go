@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+// --- Cadence ---
+//
+// Runners go faster by taking both longer and quicker steps. Cadence is modelled as
+// referenceCadence * (speed / referenceSpeed)^cadenceSpeedExponent, and step length as speed
+// divided by cadence, so calibrating a runner only means finding their cadence at the reference
+// speed. In order of preference that comes from:
+//
+//   - recorded runs with an average cadence
+//   - the preferred cadence of the profile, taken to be at the runner's usual speed
+//   - the leg length or height of the profile, through the step length they give at the reference speed
+//   - the cadence of an average recreational runner
+//
+// The model is meant for running speeds; walking cadences come out a little high.
+
+const (
+	// referenceSpeed is 3 m/s, a 5:33 per km pace
+	referenceSpeed       = 3.0
+	cadenceSpeedExponent = 0.3
+	defaultCadence       = 165.0
+
+	// Step length at the reference speed relative to height and leg length
+	stepLengthPerHeight    = 0.62
+	stepLengthPerLegLength = 1.18
+
+	minRunningSpeed = 1.0
+	maxRunningSpeed = 10.0
+	minCadence      = 100.0
+	maxCadence      = 230.0
+
+	// calibrationActivities is how many recent runs calibrate the model
+	calibrationActivities = 20
+
+	metersPerMile = 1609.344
+)
+
+// Calibration sources of a cadence recommendation
+const (
+	calibrationActivityHistory   = "activities"
+	calibrationPreferredCadence  = "preferred_cadence"
+	calibrationLegLength         = "leg_length"
+	calibrationHeight            = "height"
+	calibrationPopulationDefault = "default"
+)
+
+// CadenceRecommendation is the cadence to run at for a pace, rounded to a BPM the BPM endpoints take
+type CadenceRecommendation struct {
+	SpeedMPS         float64 `json:"speed_mps"`
+	PaceSecondsPerKm float64 `json:"pace_seconds_per_km"`
+	Cadence          float64 `json:"cadence"`
+	BPM              int     `json:"bpm"`
+	StepLengthMeters float64 `json:"step_length_m"`
+	// Calibration names what the runner's cadence model was calibrated from
+	Calibration string `json:"calibration"`
+	// Zone is the profile's pace zone the pace falls in, if any
+	Zone string `json:"zone,omitempty"`
+	// MatchingTracksPath and PlaylistPath are the BPM endpoints for the recommended BPM
+	MatchingTracksPath string `json:"matching_tracks_path"`
+	PlaylistPath       string `json:"playlist_path"`
+}
+
+// cadenceModel is a runner's cadence model, calibrated by referenceCadence
+type cadenceModel struct {
+	referenceCadence float64
+	calibration      string
+}
+
+func (m cadenceModel) cadenceAt(speed float64) float64 {
+	cadence := m.referenceCadence * math.Pow(speed/referenceSpeed, cadenceSpeedExponent)
+	return math.Max(minCadence, math.Min(maxCadence, cadence))
+}
+
+// calibrateCadenceModel builds the cadence model of a runner. profile may be nil.
+func calibrateCadenceModel(profile *db.RunnerProfile, activities []*db.RunnerActivity) cadenceModel {
+	// The reference cadence each run with a cadence implies, combined by the median so one
+	// mislabelled walk doesn't throw the model off
+	var implied []float64
+	for _, activity := range activities {
+		speed := activitySpeed(activity)
+		if activity.AverageCadence <= 0 || speed < minRunningSpeed || speed > maxRunningSpeed {
+			continue
+		}
+		implied = append(implied, activity.AverageCadence/math.Pow(speed/referenceSpeed, cadenceSpeedExponent))
+	}
+	if len(implied) > 0 {
+		return cadenceModel{referenceCadence: median(implied), calibration: calibrationActivityHistory}
+	}
+
+	if profile != nil {
+		if profile.PreferredCadence > 0 {
+			speed := usualSpeed(profile, activities)
+			return cadenceModel{
+				referenceCadence: profile.PreferredCadence / math.Pow(speed/referenceSpeed, cadenceSpeedExponent),
+				calibration:      calibrationPreferredCadence,
+			}
+		}
+		if profile.LegLengthCM > 0 {
+			stepLength := stepLengthPerLegLength * profile.LegLengthCM / 100
+			return cadenceModel{referenceCadence: referenceSpeed / stepLength * 60, calibration: calibrationLegLength}
+		}
+		if profile.HeightCM > 0 {
+			stepLength := stepLengthPerHeight * profile.HeightCM / 100
+			return cadenceModel{referenceCadence: referenceSpeed / stepLength * 60, calibration: calibrationHeight}
+		}
+	}
+
+	return cadenceModel{referenceCadence: defaultCadence, calibration: calibrationPopulationDefault}
+}
+
+// usualSpeed is the speed the runner usually runs at: the median of their recorded runs, else
+// the middle of their slowest pace zone, else the reference speed
+func usualSpeed(profile *db.RunnerProfile, activities []*db.RunnerActivity) float64 {
+	var speeds []float64
+	for _, activity := range activities {
+		if speed := activitySpeed(activity); speed >= minRunningSpeed && speed <= maxRunningSpeed {
+			speeds = append(speeds, speed)
+		}
+	}
+	if len(speeds) > 0 {
+		return median(speeds)
+	}
+
+	slowest := 0.0
+	for _, zone := range profile.PaceZones {
+		if zone.MinSecondsPerKm > 0 && zone.MaxSecondsPerKm > 0 {
+			slowest = math.Max(slowest, (zone.MinSecondsPerKm+zone.MaxSecondsPerKm)/2)
+		}
+	}
+	if slowest > 0 {
+		return 1000 / slowest
+	}
+	return referenceSpeed
+}
+
+func activitySpeed(activity *db.RunnerActivity) float64 {
+	if activity.DurationSeconds <= 0 {
+		return 0
+	}
+	return activity.DistanceMeters / float64(activity.DurationSeconds)
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Sorted(slices.Values(values))
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// targetSpeed works out the speed in m/s a cadence request asks for: a pace given as "m:ss" or
+// seconds per unit, a speed in km/h or mph, or the middle of one of the profile's pace zones.
+// unit is "km" or "mi" and defaults to km.
+func targetSpeed(pace string, speed string, zone string, unit string, profile *db.RunnerProfile) (float64, error) {
+	var unitMeters float64
+	switch strings.ToLower(unit) {
+	case "", "km":
+		unitMeters = 1000
+	case "mi":
+		unitMeters = metersPerMile
+	default:
+		return 0, fmt.Errorf("unknown unit %q, expected km or mi", unit)
+	}
+
+	given := 0
+	for _, value := range []string{pace, speed, zone} {
+		if value != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		return 0, errors.New("exactly one of pace, speed and zone is required")
+	}
+
+	var metersPerSecond float64
+	switch {
+	case pace != "":
+		seconds, err := parsePace(pace)
+		if err != nil {
+			return 0, err
+		}
+		metersPerSecond = unitMeters / seconds
+	case speed != "":
+		perHour, err := strconv.ParseFloat(speed, 64)
+		if err != nil || !isFinite(perHour) || perHour <= 0 {
+			return 0, fmt.Errorf("invalid speed %q", speed)
+		}
+		metersPerSecond = perHour * unitMeters / 3600
+	default:
+		paceZone := findPaceZone(profile, zone)
+		if paceZone == nil {
+			return 0, fmt.Errorf("no pace zone named %q in the runner profile", zone)
+		}
+		metersPerSecond = 1000 / ((paceZone.MinSecondsPerKm + paceZone.MaxSecondsPerKm) / 2)
+	}
+
+	if !isFinite(metersPerSecond) || metersPerSecond < minRunningSpeed || metersPerSecond > maxRunningSpeed {
+		return 0, fmt.Errorf("speed must be between %g and %g m/s", minRunningSpeed, maxRunningSpeed)
+	}
+	return metersPerSecond, nil
+}
+
+// parsePace parses a pace given as "m:ss" or as seconds
+func parsePace(pace string) (float64, error) {
+	minutes, seconds, found := strings.Cut(pace, ":")
+	if !found {
+		value, err := strconv.ParseFloat(pace, 64)
+		if err != nil || !isFinite(value) || value <= 0 {
+			return 0, fmt.Errorf("invalid pace %q, expected m:ss or seconds", pace)
+		}
+		return value, nil
+	}
+	m, errMinutes := strconv.Atoi(minutes)
+	s, errSeconds := strconv.ParseFloat(seconds, 64)
+	if errMinutes != nil || errSeconds != nil || !isFinite(s) || m < 0 || s < 0 || s >= 60 || m*60+int(s) <= 0 {
+		return 0, fmt.Errorf("invalid pace %q, expected m:ss or seconds", pace)
+	}
+	return float64(m*60) + s, nil
+}
+
+// isFinite reports whether value is a number other than an infinity, which a parsed NaN would
+// otherwise slip through every range check with
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// findPaceZone returns the profile's pace zone called name, ignoring case
+func findPaceZone(profile *db.RunnerProfile, name string) *db.PaceZone {
+	if profile == nil {
+		return nil
+	}
+	for i, zone := range profile.PaceZones {
+		if strings.EqualFold(zone.Name, name) {
+			return &profile.PaceZones[i]
+		}
+	}
+	return nil
+}
+
+// paceZoneOf returns the name of the profile's pace zone a pace falls in, or ""
+func paceZoneOf(profile *db.RunnerProfile, secondsPerKm float64) string {
+	if profile == nil {
+		return ""
+	}
+	for _, zone := range profile.PaceZones {
+		if secondsPerKm >= zone.MinSecondsPerKm && secondsPerKm <= zone.MaxSecondsPerKm {
+			return zone.Name
+		}
+	}
+	return ""
+}
+
+// validateRunnerProfile checks the values of a profile a user sent
+func validateRunnerProfile(profile *db.RunnerProfile) error {
+	if profile.HeightCM < 0 || profile.HeightCM > 260 {
+		return errors.New("height_cm must be between 0 and 260")
+	}
+	if profile.LegLengthCM < 0 || profile.LegLengthCM > 150 {
+		return errors.New("leg_length_cm must be between 0 and 150")
+	}
+	if profile.PreferredCadence != 0 && (profile.PreferredCadence < minCadence || profile.PreferredCadence > maxCadence) {
+		return fmt.Errorf("preferred_cadence must be between %g and %g", minCadence, maxCadence)
+	}
+	for _, zone := range profile.PaceZones {
+		if zone.Name == "" {
+			return errors.New("pace zones need a name")
+		}
+		if zone.MinSecondsPerKm <= 0 || zone.MaxSecondsPerKm < zone.MinSecondsPerKm {
+			return fmt.Errorf("pace zone %q needs 0 < min_seconds_per_km <= max_seconds_per_km", zone.Name)
+		}
+	}
+	return nil
+}
+
+// validateRunnerActivity checks a run a user recorded
+func validateRunnerActivity(activity *db.RunnerActivity) error {
+	if activity.StartedAt.IsZero() {
+		return errors.New("started_at is required")
+	}
+	if activity.DistanceMeters <= 0 || activity.DurationSeconds <= 0 {
+		return errors.New("distance_m and duration_s must be positive")
+	}
+	if activity.AverageCadence < 0 || activity.AverageCadence > maxCadence {
+		return fmt.Errorf("average_cadence must be between 0 and %g", maxCadence)
+	}
+	return nil
+}
+
+// recommendCadence recommends the cadence for a user to run at speed, calibrated from their
+// profile and recorded runs
+func recommendCadence(ctx context.Context, userId string, speed float64, profile *db.RunnerProfile) (*CadenceRecommendation, error) {
+	activities, err := db.GetRecentRunnerActivities(ctx, userId, calibrationActivities)
+	if err != nil {
+		return nil, fmt.Errorf("getting recent activities: %w", err)
+	}
+
+	model := calibrateCadenceModel(profile, activities)
+	cadence := model.cadenceAt(speed)
+	bpm := int(math.Round(cadence))
+	secondsPerKm := 1000 / speed
+
+	logger.Debug("Recommended cadence",
+		zap.String("userId", userId),
+		zap.Float64("speed", speed),
+		zap.Float64("cadence", cadence),
+		zap.String("calibration", model.calibration),
+		zap.Int("activityCount", len(activities)))
+
+	return &CadenceRecommendation{
+		SpeedMPS:           math.Round(speed*100) / 100,
+		PaceSecondsPerKm:   math.Round(secondsPerKm),
+		Cadence:            math.Round(cadence*10) / 10,
+		BPM:                bpm,
+		StepLengthMeters:   math.Round(speed/(cadence/60)*100) / 100,
+		Calibration:        model.calibration,
+		Zone:               paceZoneOf(profile, secondsPerKm),
+		MatchingTracksPath: fmt.Sprintf("/api/v1/songs/bpm/%d", bpm),
+		PlaylistPath:       fmt.Sprintf("/api/v1/playlist/bpm/%d", bpm),
+	}, nil
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/spotify/spotifytest"
+)
+
+func TestParsePace(t *testing.T) {
+	tests := []struct {
+		pace    string
+		wanted  float64
+		wantErr bool
+	}{
+		{pace: "5:30", wanted: 330},
+		{pace: "330", wanted: 330},
+		{pace: "4:05.5", wanted: 245.5},
+		{pace: "0:45", wanted: 45},
+		{pace: "", wantErr: true},
+		{pace: "fast", wantErr: true},
+		{pace: "5:60", wantErr: true},
+		{pace: "-5:00", wantErr: true},
+		{pace: "5:-1", wantErr: true},
+		{pace: "0:00", wantErr: true},
+		{pace: "0", wantErr: true},
+		{pace: "NaN", wantErr: true},
+		{pace: "5:NaN", wantErr: true},
+		{pace: "Inf", wantErr: true},
+		{pace: "5:Inf", wantErr: true},
+	}
+	for _, test := range tests {
+		seconds, err := parsePace(test.pace)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parsePace(%q) = %g, want an error", test.pace, seconds)
+			}
+			continue
+		}
+		if err != nil || seconds != test.wanted {
+			t.Errorf("parsePace(%q) = %g, %v, want %g", test.pace, seconds, err, test.wanted)
+		}
+	}
+}
+
+func TestTargetSpeed(t *testing.T) {
+	profile := &db.RunnerProfile{PaceZones: []db.PaceZone{{Name: "Easy", MinSecondsPerKm: 330, MaxSecondsPerKm: 390}}}
+	tests := []struct {
+		name    string
+		pace    string
+		speed   string
+		zone    string
+		unit    string
+		profile *db.RunnerProfile
+		wanted  float64
+		wantErr bool
+	}{
+		{name: "pace per km", pace: "5:00", wanted: 1000.0 / 300},
+		{name: "pace in seconds", pace: "300", unit: "KM", wanted: 1000.0 / 300},
+		{name: "pace per mile", pace: "8:00", unit: "mi", wanted: metersPerMile / 480},
+		{name: "km/h", speed: "12", wanted: 12000.0 / 3600},
+		{name: "mph", speed: "7.5", unit: "mi", wanted: 7.5 * metersPerMile / 3600},
+		{name: "pace zone", zone: "easy", profile: profile, wanted: 1000.0 / 360},
+		{name: "nothing", wantErr: true},
+		{name: "pace and speed", pace: "5:00", speed: "12", wantErr: true},
+		{name: "unknown unit", pace: "5:00", unit: "yd", wantErr: true},
+		{name: "NaN speed", speed: "NaN", wantErr: true},
+		{name: "infinite speed", speed: "Inf", wantErr: true},
+		{name: "NaN pace", pace: "NaN", wantErr: true},
+		{name: "too slow", pace: "20:00", wantErr: true},
+		{name: "too fast", speed: "40", wantErr: true},
+		{name: "unknown zone", zone: "tempo", profile: profile, wantErr: true},
+		{name: "zone without a profile", zone: "easy", wantErr: true},
+	}
+	for _, test := range tests {
+		speed, err := targetSpeed(test.pace, test.speed, test.zone, test.unit, test.profile)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: targetSpeed = %g, want an error", test.name, speed)
+			}
+			continue
+		}
+		if err != nil || math.Abs(speed-test.wanted) > 1e-9 {
+			t.Errorf("%s: targetSpeed = %g, %v, want %g", test.name, speed, err, test.wanted)
+		}
+	}
+}
+
+func TestCalibrateCadenceModel(t *testing.T) {
+	// run returns a run at referenceSpeed
+	run := func(cadence float64) *db.RunnerActivity {
+		return &db.RunnerActivity{DistanceMeters: 3000, DurationSeconds: 1000, AverageCadence: cadence}
+	}
+	tests := []struct {
+		name        string
+		profile     *db.RunnerProfile
+		activities  []*db.RunnerActivity
+		wanted      float64
+		calibration string
+	}{
+		{
+			name:    "median of recorded runs",
+			profile: &db.RunnerProfile{PreferredCadence: 150},
+			activities: []*db.RunnerActivity{
+				run(170), run(180), run(175),
+				// Without a cadence, and at a walk
+				run(0),
+				{DistanceMeters: 1000, DurationSeconds: 2000, AverageCadence: 110},
+			},
+			wanted:      175,
+			calibration: calibrationActivityHistory,
+		},
+		{
+			name:        "preferred cadence at the usual speed of recorded runs",
+			profile:     &db.RunnerProfile{PreferredCadence: 180, HeightCM: 180},
+			activities:  []*db.RunnerActivity{{DistanceMeters: 6000, DurationSeconds: 1000}},
+			wanted:      180 / math.Pow(2, cadenceSpeedExponent),
+			calibration: calibrationPreferredCadence,
+		},
+		{
+			name: "preferred cadence at the slowest pace zone",
+			profile: &db.RunnerProfile{PreferredCadence: 180, PaceZones: []db.PaceZone{
+				{Name: "easy", MinSecondsPerKm: 300, MaxSecondsPerKm: 400},
+				{Name: "fast", MinSecondsPerKm: 200, MaxSecondsPerKm: 250},
+			}},
+			wanted:      180 / math.Pow(1000.0/350/referenceSpeed, cadenceSpeedExponent),
+			calibration: calibrationPreferredCadence,
+		},
+		{
+			name:        "preferred cadence",
+			profile:     &db.RunnerProfile{PreferredCadence: 180},
+			wanted:      180,
+			calibration: calibrationPreferredCadence,
+		},
+		{
+			name:        "leg length",
+			profile:     &db.RunnerProfile{LegLengthCM: 85, HeightCM: 180},
+			wanted:      referenceSpeed / (stepLengthPerLegLength * 0.85) * 60,
+			calibration: calibrationLegLength,
+		},
+		{
+			name:        "height",
+			profile:     &db.RunnerProfile{HeightCM: 180},
+			wanted:      referenceSpeed / (stepLengthPerHeight * 1.8) * 60,
+			calibration: calibrationHeight,
+		},
+		{name: "no profile", wanted: defaultCadence, calibration: calibrationPopulationDefault},
+	}
+	for _, test := range tests {
+		model := calibrateCadenceModel(test.profile, test.activities)
+		if math.Abs(model.referenceCadence-test.wanted) > 1e-9 || model.calibration != test.calibration {
+			t.Errorf("%s: calibrated %g from %s, want %g from %s",
+				test.name, model.referenceCadence, model.calibration, test.wanted, test.calibration)
+		}
+	}
+
+	model := cadenceModel{referenceCadence: defaultCadence}
+	if got := model.cadenceAt(referenceSpeed); got != defaultCadence {
+		t.Errorf("cadence at the reference speed = %g, want %g", got, defaultCadence)
+	}
+	if got := model.cadenceAt(maxRunningSpeed); got != maxCadence {
+		t.Errorf("cadence at a sprint = %g, want it capped at %g", got, maxCadence)
+	}
+}
+
+func TestRunnerHandlersRejectInvalidRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+	spotify.InitializeLogger(zap.NewNop())
+	db.SetPool(&fakePool{})
+
+	server := spotifytest.NewServer()
+	defer server.Close()
+	previousClient := spotify.DefaultClient()
+	spotify.SetDefaultClient(server.Client())
+	defer spotify.SetDefaultClient(previousClient)
+	server.AddUser("alice-token", &spotifytest.Library{User: spotify.User{Id: "alice"}})
+
+	router := gin.New()
+	router.GET("/api/v1/user/cadence", CadenceHandler)
+	router.PUT("/api/v1/user/profile", SaveRunnerProfileHandler)
+	router.POST("/api/v1/user/activities", AddRunnerActivityHandler)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{method: "GET", path: "/api/v1/user/cadence?pace=5:00", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&pace=5:00&speed=12", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&pace=NaN", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&pace=5:NaN", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&speed=NaN", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&speed=Inf", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&zone=easy", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&pace=5:00&unit=yd", status: http.StatusBadRequest},
+		{method: "GET", path: "/api/v1/user/cadence?access_token=alice-token&pace=5:00", status: http.StatusOK},
+		{method: "PUT", path: "/api/v1/user/profile?access_token=alice-token", body: "{", status: http.StatusBadRequest},
+		{method: "PUT", path: "/api/v1/user/profile?access_token=alice-token", body: `{"height_cm": 300}`, status: http.StatusBadRequest},
+		{method: "POST", path: "/api/v1/user/activities?access_token=alice-token", body: `{"distance_m": 5000}`, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s %s: status %d, want %d: %s", test.method, test.path, recorder.Code, test.status, recorder.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var recommendation CadenceRecommendation
+		if err := json.Unmarshal(recorder.Body.Bytes(), &recommendation); err != nil {
+			t.Fatalf("decoding recommendation: %v", err)
+		}
+		if recommendation.Calibration != calibrationPopulationDefault || recommendation.BPM != 170 {
+			t.Errorf("recommended %d BPM from %s, want 170 from %s",
+				recommendation.BPM, recommendation.Calibration, calibrationPopulationDefault)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cmap "github.com/orcaman/concurrent-map/v2"
@@ -15,12 +17,27 @@ import (
 type StageContext struct {
 	wg   *sync.WaitGroup
 	name string
+	run  *SyncRun
+
+	// Job counters reported through the sync status API
+	submitted atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+
+	mu         sync.Mutex
+	status     string
+	startedAt  time.Time
+	finishedAt time.Time
+	errors     []string
+
+	// afterJobs run once every job submitted by the stage has finished without an error
+	afterJobs []func(context.Context) error
 }
 
 // Job represents a task for a worker to execute.
 // We use an interface to allow different kinds of tasks.
 type Job interface {
-	Execute(pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error
+	Execute(ctx context.Context, pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error
 }
 
 // WorkerPool manages a pool of workers and distributes jobs.
@@ -29,6 +46,12 @@ type WorkerPool struct {
 	jobsChan    chan *JobWrapper
 	resultsChan chan error // Channel to collect errors from jobs
 	wg          sync.WaitGroup
+
+	// durable sends DurableJobs to the Postgres job queue instead of jobsChan
+	durable bool
+
+	// ctx is passed to every job; once it is done, queued jobs are drained without running
+	ctx context.Context
 	
 	// Simple queue monitoring
 	queueHighWaterMark int64
@@ -42,11 +65,13 @@ func NewWorkerPool(numWorkers int, jobQueueSize int) *WorkerPool {
 		numWorkers:  numWorkers,
 		jobsChan:    make(chan *JobWrapper, jobQueueSize),   // Buffered channel
 		resultsChan: make(chan error, jobQueueSize), // Buffered channel for errors
+		durable:     DurableQueueEnabled(),
 	}
 }
 
-// Start initializes the workers.
-func (wp *WorkerPool) Start(jobWg *sync.WaitGroup, tracker *ProcessedTracker) {
+// Start initializes the workers. Jobs are executed with ctx.
+func (wp *WorkerPool) Start(ctx context.Context, jobWg *sync.WaitGroup, tracker *ProcessedTracker) {
+	wp.ctx = ctx
 	for i := range wp.numWorkers {
 		wp.wg.Add(1)
 		// Pass necessary context (pool, jobWg, tracker) to the worker
@@ -66,9 +91,27 @@ func (wp *WorkerPool) worker(id int, jobWg *sync.WaitGroup, tracker *ProcessedTr
 	logger.Debug("Worker started", zap.Int("workerId", id))
 	for wrapper := range wp.jobsChan {
 		jobType := fmt.Sprintf("%T", wrapper.job)
+
+		// Once the pool is cancelled, drain the remaining jobs without running them
+		if wp.ctx.Err() != nil {
+			jobWg.Done()
+			if wrapper.stage != nil {
+				wrapper.stage.wg.Done()
+			}
+			continue
+		}
+
 		logger.Debug("Worker processing job", zap.Int("workerId", id), zap.String("jobType", jobType))
 		// Pass context down to the job's Execute method
-		err := wrapper.job.Execute(wp, jobWg, tracker, wrapper.stage)
+		err := wrapper.job.Execute(wp.ctx, wp, jobWg, tracker, wrapper.stage)
+		// Errors caused by the pool being cancelled mid-job are not job failures
+		if err != nil && wp.ctx.Err() != nil {
+			jobWg.Done()
+			if wrapper.stage != nil {
+				wrapper.stage.wg.Done()
+			}
+			continue
+		}
 		if err != nil {
 			select {
 			case wp.resultsChan <- err:
@@ -82,8 +125,9 @@ func (wp *WorkerPool) worker(id int, jobWg *sync.WaitGroup, tracker *ProcessedTr
 		}
 		jobWg.Done() // Decrement job wait group *after* job execution completes
 		
-		// Also decrement stage wait group if present
+		// Also record the result and decrement stage wait group if present
 		if wrapper.stage != nil {
+			wrapper.stage.recordJobResult(err)
 			wrapper.stage.wg.Done()
 		}
 	}
@@ -99,6 +143,11 @@ func (wp *WorkerPool) Submit(job Job, jobWg *sync.WaitGroup) {
 // SubmitWithStage adds a job to the queue with stage tracking.
 // It increments both the job WaitGroup and the stage WaitGroup if provided.
 func (wp *WorkerPool) SubmitWithStage(job Job, jobWg *sync.WaitGroup, stage *StageContext) {
+	// Durable jobs are tracked through the job_queue table rather than the wait groups
+	if wp.durable && wp.enqueueDurable(job, stage) {
+		return
+	}
+
 	// Calculate current queue size before attempting to queue
 	currentSize := len(wp.jobsChan)
 	
@@ -140,6 +189,7 @@ func (wp *WorkerPool) SubmitWithStage(job Job, jobWg *sync.WaitGroup, stage *Sta
 	
 	if stage != nil {
 		stage.wg.Add(1) // Also increment stage WG
+		stage.submitted.Add(1)
 	}
 	
 	// This will block if queue is full
@@ -180,12 +230,17 @@ func (wp *WorkerPool) GetMaxQueueSize() int64 {
 // TrySubmitWithStage attempts to submit without blocking
 // Returns true if job was queued, false if queue is full
 func (wp *WorkerPool) TrySubmitWithStage(job Job, jobWg *sync.WaitGroup, stage *StageContext) bool {
+	if wp.durable && wp.enqueueDurable(job, stage) {
+		return true
+	}
+
 	select {
 	case wp.jobsChan <- &JobWrapper{job: job, stage: stage}:
 		// Successfully queued - now increment waitgroups
 		jobWg.Add(1)
 		if stage != nil {
 			stage.wg.Add(1)
+			stage.submitted.Add(1)
 		}
 		
 		// Update monitoring
@@ -324,6 +379,15 @@ func NewProcessedTracker() *ProcessedTracker {
 	}
 }
 
+// Reset forgets every processed ID
+func (pt *ProcessedTracker) Reset() {
+	pt.processedTracks.Clear()
+	pt.processedPlaylists.Clear()
+	pt.processedArtists.Clear()
+	pt.processedAlbums.Clear()
+	pt.processedSingles.Clear()
+}
+
 // CheckAndMark checks if an ID is processed, marks it if not. Returns true if already processed.
 func (pt *ProcessedTracker) CheckAndMark(itemType string, id string) bool {
 	var targetMap cmap.ConcurrentMap[string, struct{}]
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/secrets"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+const (
+	// credentialReencryptBatchSize is how many credentials ReencryptCredentials loads at a time
+	credentialReencryptBatchSize = 100
+	// credentialReencryptLease makes only one instance re-encrypt credentials at a time
+	credentialReencryptLease = "reencrypt_credentials"
+)
+
+// saveRefreshToken encrypts a refresh token with the current key and stores it for the user.
+// The user id is bound to the ciphertext so a token can't be replayed under another user.
+func saveRefreshToken(ctx context.Context, userId string, refreshToken string, scope string) error {
+	keyring, err := secrets.LoadKeyring()
+	if err != nil {
+		return fmt.Errorf("loading token encryption keys: %w", err)
+	}
+
+	ciphertext, keyVersion, err := keyring.Encrypt([]byte(refreshToken), []byte(userId))
+	if err != nil {
+		return fmt.Errorf("encrypting refresh token: %w", err)
+	}
+
+	err = db.SaveUserCredential(ctx, &db.UserCredential{
+		UserId:                 userId,
+		RefreshTokenCiphertext: ciphertext,
+		KeyVersion:             keyVersion,
+		Scope:                  scope,
+	})
+	if err != nil {
+		return fmt.Errorf("saving refresh token: %w", err)
+	}
+	return nil
+}
+
+// decryptRefreshToken returns the plaintext refresh token of a stored credential
+func decryptRefreshToken(credential *db.UserCredential) (string, error) {
+	if credential.RefreshTokenCiphertext == nil {
+		if credential.LegacyRefreshToken == "" {
+			return "", fmt.Errorf("credential for user %s has no refresh token", credential.UserId)
+		}
+		return credential.LegacyRefreshToken, nil
+	}
+
+	keyring, err := secrets.LoadKeyring()
+	if err != nil {
+		return "", fmt.Errorf("loading token encryption keys: %w", err)
+	}
+
+	plaintext, err := keyring.Decrypt(credential.RefreshTokenCiphertext, credential.KeyVersion, []byte(credential.UserId))
+	if err != nil {
+		return "", fmt.Errorf("decrypting refresh token for user %s: %w", credential.UserId, err)
+	}
+	return string(plaintext), nil
+}
+
+// SaveUserCredential stores the refresh token of an issued token for scheduled refreshes,
+// replacing any previous one. It is registered as the spotify token observer, so a token
+// rotated by RefreshHandler is picked up automatically. Without TOKEN_ENCRYPTION_KEYS nothing is
+// stored, which ReencryptCredentials warns about once at startup.
+func SaveUserCredential(ctx context.Context, token *spotify.TokenResponse) {
+	if token.RefreshToken == "" {
+		return
+	}
+	if _, err := secrets.LoadKeyring(); errors.Is(err, secrets.ErrNoKeys) {
+		return
+	}
+
+	// Token responses don't say whose token it is
+	user, err := spotifyClient().GetUser(ctx, token.Token)
+	if err != nil {
+		logger.Error("Error getting user for issued token", zap.Error(err))
+		return
+	}
+
+	if err := saveRefreshToken(ctx, user.Id, token.RefreshToken, token.Scope); err != nil {
+		logger.Error("Error saving user credential", zap.String("userId", user.Id), zap.Error(err))
+	}
+}
+
+// ReencryptCredentials re-encrypts every stored refresh token that is still in plaintext or
+// encrypted under an older key version, so retired keys can be removed from the key set.
+// Credentials that fail to re-encrypt are logged and skipped. Only one instance runs it at a time;
+// the others return right away.
+func ReencryptCredentials(ctx context.Context) error {
+	keyring, err := secrets.LoadKeyring()
+	if errors.Is(err, secrets.ErrNoKeys) {
+		logger.Warn("TOKEN_ENCRYPTION_KEYS not set, refresh tokens will not be stored")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading token encryption keys: %w", err)
+	}
+
+	lease, err := acquireLease(ctx, credentialReencryptLease)
+	if err != nil {
+		return err
+	}
+	if lease == nil {
+		logger.Info("Credentials are being re-encrypted by another instance, skipping")
+		return nil
+	}
+	defer lease.release()
+	ctx = lease.ctx
+
+	var reencrypted, skipped, failed int
+	afterUserId := ""
+	for {
+		credentials, err := db.GetCredentialsToReencrypt(ctx, keyring.CurrentVersion(), afterUserId, credentialReencryptBatchSize)
+		if err != nil {
+			return fmt.Errorf("getting credentials to re-encrypt: %w", err)
+		}
+		if len(credentials) == 0 {
+			break
+		}
+
+		for _, credential := range credentials {
+			afterUserId = credential.UserId
+
+			updated, err := reencryptCredential(ctx, keyring, credential)
+			if err != nil {
+				failed++
+				logger.Error("Error re-encrypting user credential",
+					zap.String("userId", credential.UserId),
+					zap.Int("keyVersion", credential.KeyVersion),
+					zap.Error(err))
+				continue
+			}
+			if !updated {
+				// The user stored a new token since it was read, which is encrypted already
+				skipped++
+				continue
+			}
+			reencrypted++
+		}
+	}
+
+	logger.Info("Re-encrypted user credentials",
+		zap.Int("currentKeyVersion", keyring.CurrentVersion()),
+		zap.Int("reencrypted", reencrypted),
+		zap.Int("skipped", skipped),
+		zap.Int("failed", failed))
+	return ctx.Err()
+}
+
+// reencryptCredential encrypts the token of a credential under the current key. It only replaces
+// the stored token if it is still the one that was read, so a token saved concurrently isn't lost.
+func reencryptCredential(ctx context.Context, keyring *secrets.Keyring, credential *db.UserCredential) (bool, error) {
+	refreshToken, err := decryptRefreshToken(credential)
+	if err != nil {
+		return false, err
+	}
+
+	ciphertext, keyVersion, err := keyring.Encrypt([]byte(refreshToken), []byte(credential.UserId))
+	if err != nil {
+		return false, fmt.Errorf("encrypting refresh token: %w", err)
+	}
+
+	updated, err := db.ReencryptUserCredential(ctx, credential, ciphertext, keyVersion)
+	if err != nil {
+		return false, fmt.Errorf("saving re-encrypted refresh token: %w", err)
+	}
+	return updated, nil
+}
@@ -0,0 +1,87 @@
+package service
+
+// durationTolerance is how far from its target length a playlist may end up: 5% of the target,
+// but at least a minute
+func durationTolerance(targetMS int) int {
+	return max(60*1000, targetMS/20)
+}
+
+// fitDuration picks unused tracks from candidates, best ranked first, whose lengths add up as
+// close to targetMS as it can, and marks them used. At most maxTracks are picked unless it is 0.
+// Tracks of unknown length are skipped. It returns the tracks and their combined length.
+func fitDuration(candidates []*RankedTrack, targetMS int, toleranceMS int, maxTracks int, used map[string]bool) ([]*RankedTrack, int) {
+	var picked []*RankedTrack
+	remaining := targetMS
+	full := func() bool {
+		return maxTracks > 0 && len(picked) >= maxTracks
+	}
+	pick := func(track *RankedTrack) {
+		picked = append(picked, track)
+		used[track.Id] = true
+		remaining -= track.DurationMS
+	}
+
+	// Take the best tracks that fit in what is left
+	for _, track := range candidates {
+		if remaining <= 0 || full() {
+			break
+		}
+		if used[track.Id] || track.DurationMS <= 0 || track.DurationMS > remaining {
+			continue
+		}
+		pick(track)
+	}
+
+	// Then close the gap with the track whose length fits it best, if running over by it gets
+	// closer to the target than stopping short
+	if remaining > toleranceMS && !full() {
+		var best *RankedTrack
+		for _, track := range candidates {
+			if used[track.Id] || track.DurationMS <= 0 {
+				continue
+			}
+			gap := absInt(remaining - track.DurationMS)
+			if gap < remaining && (best == nil || gap < absInt(remaining-best.DurationMS)) {
+				best = track
+			}
+		}
+		if best != nil {
+			pick(best)
+		}
+	}
+
+	// Still off by more than the tolerance, e.g. because maxTracks ran out: swap the one picked
+	// track for the unused one that gets closest to the target
+	if absInt(remaining) > toleranceMS && len(picked) > 0 {
+		swapOut, swapIn := -1, (*RankedTrack)(nil)
+		bestGap := absInt(remaining)
+		for i, out := range picked {
+			for _, in := range candidates {
+				if used[in.Id] || in.DurationMS <= 0 {
+					continue
+				}
+				if gap := absInt(remaining + out.DurationMS - in.DurationMS); gap < bestGap {
+					swapOut, swapIn, bestGap = i, in, gap
+				}
+			}
+		}
+		if swapIn != nil {
+			out := picked[swapOut]
+			delete(used, out.Id)
+			used[swapIn.Id] = true
+			remaining += out.DurationMS - swapIn.DurationMS
+			picked[swapOut] = swapIn
+			// The swapped in track may rank anywhere, so put the picks back in ranked order
+			sortRankedTracks(picked)
+		}
+	}
+
+	return picked, targetMS - remaining
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
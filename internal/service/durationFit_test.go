@@ -0,0 +1,126 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/spotify/spotifytest"
+)
+
+func TestFitDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		// seconds are the lengths of the candidates, best ranked first; 0 is unknown
+		seconds   []int
+		used      []string
+		target    int
+		tolerance int
+		maxTracks int
+		wanted    []string
+		wantedMS  int
+	}{
+		{name: "fills the target", seconds: []int{120, 120, 120}, target: 240, tolerance: 60, wanted: []string{"a", "b"}, wantedMS: 240},
+		{name: "skips tracks that don't fit", seconds: []int{300, 120, 120}, target: 240, tolerance: 60, wanted: []string{"b", "c"}, wantedMS: 240},
+		{name: "skips tracks of unknown length", seconds: []int{0, 120, 120}, target: 240, tolerance: 60, wanted: []string{"b", "c"}, wantedMS: 240},
+		{name: "skips used tracks", seconds: []int{120, 120, 120}, used: []string{"a"}, target: 240, tolerance: 60, wanted: []string{"b", "c"}, wantedMS: 240},
+		{name: "fills what is left with shorter tracks", seconds: []int{120, 120, 50}, target: 300, tolerance: 60, wanted: []string{"a", "b", "c"}, wantedMS: 290},
+		{name: "stops short within the tolerance", seconds: []int{240, 100}, target: 300, tolerance: 60, wanted: []string{"a"}, wantedMS: 240},
+		{name: "runs over to close the gap", seconds: []int{180, 180}, target: 300, tolerance: 60, wanted: []string{"a", "b"}, wantedMS: 360},
+		{name: "stops short when running over is worse", seconds: []int{200, 400}, target: 300, tolerance: 60, wanted: []string{"a"}, wantedMS: 200},
+		{name: "stops at max tracks", seconds: []int{60, 60, 60, 60}, target: 240, tolerance: 60, maxTracks: 3, wanted: []string{"a", "b", "c"}, wantedMS: 180},
+		{
+			name:      "swaps a track when max tracks runs out",
+			seconds:   []int{120, 120, 240},
+			target:    360,
+			tolerance: 60,
+			maxTracks: 2,
+			// c replaces a, and the picks stay in ranked order
+			wanted:   []string{"b", "c"},
+			wantedMS: 360,
+		},
+		{name: "nothing known", seconds: []int{0, 0}, target: 300, tolerance: 60, wanted: nil, wantedMS: 0},
+	}
+	for _, test := range tests {
+		candidates := testCandidates("", test.seconds...)
+		used := make(map[string]bool)
+		for _, id := range test.used {
+			used[id] = true
+		}
+
+		picked, durationMS := fitDuration(candidates, test.target*1000, test.tolerance*1000, test.maxTracks, used)
+		if got := rankedIds(picked); !slices.Equal(got, test.wanted) {
+			t.Errorf("%s: picked %v, want %v", test.name, got, test.wanted)
+		}
+		if durationMS != test.wantedMS*1000 {
+			t.Errorf("%s: picked %d ms, want %d", test.name, durationMS, test.wantedMS*1000)
+		}
+		for _, track := range candidates {
+			wantUsed := slices.Contains(test.wanted, track.Id) || slices.Contains(test.used, track.Id)
+			if used[track.Id] != wantUsed {
+				t.Errorf("%s: track %s used = %t, want %t", test.name, track.Id, used[track.Id], wantUsed)
+			}
+		}
+	}
+}
+
+func TestDurationTolerance(t *testing.T) {
+	if got := durationTolerance(10 * 60 * 1000); got != 60*1000 {
+		t.Errorf("tolerance of 10 minutes = %d ms, want a minute", got)
+	}
+	if got := durationTolerance(60 * 60 * 1000); got != 3*60*1000 {
+		t.Errorf("tolerance of an hour = %d ms, want 3 minutes", got)
+	}
+}
+
+func TestCreatePlaylistHandlerValidatesLength(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+	spotify.InitializeLogger(zap.NewNop())
+	db.SetPool(&fakePool{})
+
+	server := spotifytest.NewServer()
+	defer server.Close()
+	previousClient := spotify.DefaultClient()
+	spotify.SetDefaultClient(server.Client())
+	defer spotify.SetDefaultClient(previousClient)
+	server.AddUser("alice-token", &spotifytest.Library{User: spotify.User{Id: "alice"}})
+
+	router := gin.New()
+	router.POST("/api/v1/playlist/bpm/:bpm", CreatePlaylistHandler)
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{query: "target_duration=abc", status: http.StatusBadRequest},
+		{query: "target_duration=0", status: http.StatusBadRequest},
+		{query: "target_duration=-60", status: http.StatusBadRequest},
+		{query: "target_duration=21601", status: http.StatusBadRequest},
+		{query: "max_tracks=abc", status: http.StatusBadRequest},
+		{query: "max_tracks=0", status: http.StatusBadRequest},
+		{query: "max_tracks=-1", status: http.StatusBadRequest},
+		{query: "target_duration=1800&max_tracks=10", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		before := server.Requests("POST", "/v1/users/alice/playlists")
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/api/v1/playlist/bpm/170?access_token=alice-token&"+test.query, nil)
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.query, recorder.Code, test.status, recorder.Body.String())
+		}
+		created := server.Requests("POST", "/v1/users/alice/playlists") - before
+		if test.status == http.StatusBadRequest && created != 0 {
+			t.Errorf("%s: created a playlist for an invalid request", test.query)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+// defaultSyncFreshnessTTL is how long a crawled artist or album is reused by every user's sync
+// before it is crawled again. Override with SYNC_FRESHNESS_TTL (e.g. "24h").
+const defaultSyncFreshnessTTL = 72 * time.Hour
+
+func syncFreshnessTTL() time.Duration {
+	return getEnvDuration("SYNC_FRESHNESS_TTL", defaultSyncFreshnessTTL)
+}
+
+// isFresh reports whether a crawl can be skipped because some sync finished it for market within
+// the TTL. Lookup errors are logged and treated as stale so the crawl still happens.
+func isFresh(ctx context.Context, crawl string, id string, market string) bool {
+	fresh, err := db.IsFresh(ctx, crawl, id, market, syncFreshnessTTL())
+	if err != nil {
+		logger.Warn("Error checking crawl freshness, crawling anyway",
+			zap.String("crawl", crawl),
+			zap.String("id", id),
+			zap.String("market", market),
+			zap.Error(err))
+		return false
+	}
+	return fresh
+}
+
+// markSynced records a finished crawl. A failure only means the entity is crawled again next time,
+// so it is logged rather than failing the job.
+func markSynced(ctx context.Context, crawl string, id string, market string) {
+	if err := db.MarkSynced(ctx, crawl, id, market); err != nil {
+		logger.Warn("Error marking crawl synced",
+			zap.String("crawl", crawl),
+			zap.String("id", id),
+			zap.String("market", market),
+			zap.Error(err))
+	}
+}
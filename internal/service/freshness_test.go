@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+func TestCrawlFreshnessIsKeyedByMarket(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+	pool := &fakePool{}
+	db.SetPool(pool)
+	ctx := context.Background()
+
+	markSynced(ctx, db.CrawlAlbumTracks, "album-1", "US")
+	marked := pool.matching(`INSERT INTO "crawl_market"`)
+	if len(marked) != 1 {
+		t.Fatalf("crawl for US was recorded %d times in crawl_market, want 1", len(marked))
+	}
+	if args := marked[0].args; args[0] != db.CrawlAlbumTracks || args[1] != "album-1" || args[2] != "US" {
+		t.Errorf("crawl for US was recorded as %v", args)
+	}
+	if updates := pool.matching(`UPDATE "album"`); len(updates) != 0 {
+		t.Errorf("crawl for US was recorded on the album row, which other markets read: %v", updates)
+	}
+
+	isFresh(ctx, db.CrawlAlbumTracks, "album-1", "DE")
+	checked := pool.matching(`FROM "crawl_market"`)
+	if len(checked) != 1 {
+		t.Fatalf("freshness for DE was checked %d times in crawl_market, want 1", len(checked))
+	}
+	if args := checked[0].args; args[0] != db.CrawlAlbumTracks || args[1] != "album-1" || args[2] != "DE" {
+		t.Errorf("freshness for DE was checked with %v", args)
+	}
+
+	markSynced(ctx, db.CrawlAlbumTracks, "album-1", "")
+	if updates := pool.matching(`UPDATE "album"`); len(updates) != 1 {
+		t.Errorf("crawl without a market was recorded on the album row %d times, want 1", len(updates))
+	}
+}
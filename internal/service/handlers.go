@@ -10,8 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"github.com/rcong315/RunDJServer/internal/cover"
 	"github.com/rcong315/RunDJServer/internal/db"
-	"github.com/rcong315/RunDJServer/internal/spotify"
 )
 
 func HomeHandler(c *gin.Context) {
@@ -28,7 +28,7 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	user, err := spotify.GetUser(token)
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
 	if err != nil {
 		logger.Error("RegisterHandler: Error getting user", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -38,7 +38,7 @@ func RegisterHandler(c *gin.Context) {
 	}
 	logger.Debug("RegisterHandler: User retrieved", zap.String("userId", user.Id), zap.String("displayName", user.DisplayName))
 
-	isNewUser, err := saveUser(user)
+	isNewUser, err := saveUser(c.Request.Context(), user)
 	if err != nil {
 		logger.Error("RegisterHandler: Error saving user", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -56,7 +56,7 @@ func RegisterHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, true)
 	} else {
 		// For existing users, check if data is stale (more than 3 days old)
-		userUpdatedAt, err := db.GetUserUpdatedAt(user.Id)
+		userUpdatedAt, err := db.GetUserUpdatedAt(c.Request.Context(), user.Id)
 		if err != nil {
 			logger.Error("RegisterHandler: Error getting user updated_at", zap.String("userId", user.Id), zap.Error(err))
 		} else {
@@ -81,6 +81,99 @@ func RegisterHandler(c *gin.Context) {
 	}
 }
 
+func SyncStatusHandler(c *gin.Context) {
+	logger.Info("SyncStatusHandler called")
+	token := c.Query("access_token")
+	if token == "" {
+		logger.Error("SyncStatusHandler: Missing access_token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing access_token"})
+		return
+	}
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
+	if err != nil {
+		logger.Error("SyncStatusHandler: Error getting user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error getting user: " + err.Error(),
+		})
+		return
+	}
+	userId := user.Id
+	if userId == "" {
+		logger.Error("SyncStatusHandler: Missing userId after GetUser call")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing userId"})
+		return
+	}
+	logger.Debug("SyncStatusHandler: User identified", zap.String("userId", userId))
+
+	run, err := db.GetLatestSyncRun(c.Request.Context(), userId)
+	if err != nil {
+		logger.Error("SyncStatusHandler: Error getting latest sync run", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error getting sync status: " + err.Error(),
+		})
+		return
+	}
+	if run == nil {
+		logger.Debug("SyncStatusHandler: No sync run found", zap.String("userId", userId))
+		c.JSON(http.StatusNotFound, gin.H{"error": "No sync run found"})
+		return
+	}
+
+	logger.Info("SyncStatusHandler: Sync run retrieved",
+		zap.String("userId", userId),
+		zap.Int64("syncRunId", run.SyncRunId),
+		zap.String("status", run.Status),
+		zap.Float64("progress", run.Progress))
+	c.JSON(http.StatusOK, run)
+}
+
+func CancelSyncHandler(c *gin.Context) {
+	logger.Info("CancelSyncHandler called")
+	token := c.Query("access_token")
+	if token == "" {
+		logger.Error("CancelSyncHandler: Missing access_token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing access_token"})
+		return
+	}
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
+	if err != nil {
+		logger.Error("CancelSyncHandler: Error getting user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error getting user: " + err.Error(),
+		})
+		return
+	}
+	userId := user.Id
+	if userId == "" {
+		logger.Error("CancelSyncHandler: Missing userId after GetUser call")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing userId"})
+		return
+	}
+	logger.Debug("CancelSyncHandler: User identified", zap.String("userId", userId))
+
+	cancelled, err := CancelSync(c.Request.Context(), userId)
+	if err != nil && len(cancelled) == 0 {
+		logger.Error("CancelSyncHandler: Error cancelling sync", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error cancelling sync: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logger.Warn("CancelSyncHandler: Cancelled local syncs only", zap.String("userId", userId), zap.Error(err))
+	}
+	if len(cancelled) == 0 {
+		logger.Debug("CancelSyncHandler: No sync in progress", zap.String("userId", userId))
+		c.JSON(http.StatusNotFound, gin.H{"error": "No sync in progress"})
+		return
+	}
+
+	logger.Info("CancelSyncHandler: Sync cancelled",
+		zap.String("userId", userId),
+		zap.Int64s("syncRunIds", cancelled))
+	c.JSON(http.StatusAccepted, gin.H{"cancelled_sync_run_ids": cancelled})
+}
+
 func PresetPlaylistHandler(c *gin.Context) {
 	logger.Info("PresetPlaylistHandler called")
 	bpmStr := c.Query("bpm")
@@ -207,7 +300,7 @@ func MatchingTracksHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing access_token"})
 		return
 	}
-	user, err := spotify.GetUser(token)
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
 	if err != nil {
 		logger.Error("MatchingTracksHandler: Error getting user", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -244,7 +337,24 @@ func MatchingTracksHandler(c *gin.Context) {
 	sources := strings.Split(sourcesStr, ",")
 	logger.Debug("MatchingTracksHandler: Sources for tracks", zap.String("userId", userId), zap.Strings("sources", sources))
 
-	tracks, err := db.GetTracksByBPM(userId, min, max, sources)
+	ratios, err := parseTempoMultiples(c.Query("multiples"))
+	if err != nil {
+		logger.Error("MatchingTracksHandler: Invalid multiples", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multiples: " + err.Error()})
+		return
+	}
+
+	limit := defaultMatchLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxMatchLimit {
+			logger.Error("MatchingTracksHandler: Invalid limit", zap.String("userId", userId), zap.String("limitStr", limitStr))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit: must be between 1 and " + strconv.Itoa(maxMatchLimit)})
+			return
+		}
+	}
+
+	matches, err := matchTracksByBPM(c.Request.Context(), userId, min, max, ratios, sources)
 	if err != nil {
 		logger.Error("MatchingTracksHandler: Error getting tracks by BPM", zap.String("userId", userId), zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -252,24 +362,24 @@ func MatchingTracksHandler(c *gin.Context) {
 		})
 		return
 	}
-	logger.Info("MatchingTracksHandler: Tracks retrieved by BPM", zap.String("userId", userId), zap.Int("count", len(tracks)))
+	logger.Info("MatchingTracksHandler: Tracks retrieved by BPM", zap.String("userId", userId), zap.Int("count", len(matches)))
 
-	if len(tracks) > 1000 {
-		logger.Warn("MatchingTracksHandler: More than 1000 tracks found, limiting to 1000", zap.String("userId", userId), zap.Int("count", len(tracks)))
-		limitedTracks := make(map[string]float64)
-		count := 0
-		for key, value := range tracks {
-			if count >= 1000 {
-				break
-			}
-			limitedTracks[key] = value
-			count++
-		}
-		tracks = limitedTracks
+	tracks, err := rankTracks(c.Request.Context(), userId, min, max, matches)
+	if err != nil {
+		logger.Error("MatchingTracksHandler: Error ranking tracks", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error ranking tracks: " + err.Error(),
+		})
+		return
+	}
+	total := len(tracks)
+	if total > limit {
+		tracks = tracks[:limit]
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"count":  len(tracks),
+		"total":  total,
 		"user":   userId,
 		"min":    min,
 		"max":    max,
@@ -285,7 +395,7 @@ func CreatePlaylistHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing access_token"})
 		return
 	}
-	user, err := spotify.GetUser(token)
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
 	if err != nil {
 		logger.Error("CreatePlaylistHandler: Error getting user", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -321,7 +431,35 @@ func CreatePlaylistHandler(c *gin.Context) {
 	sources := strings.Split(sourcesStr, ",")
 	logger.Debug("CreatePlaylistHandler: Sources for tracks", zap.String("userId", userId), zap.Strings("sources", sources))
 
-	tracks, err := db.GetTracksByBPM(userId, min, max, sources)
+	ratios, err := parseTempoMultiples(c.Query("multiples"))
+	if err != nil {
+		logger.Error("CreatePlaylistHandler: Invalid multiples", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multiples: " + err.Error()})
+		return
+	}
+
+	// target_duration is the length of the run in seconds. Without it every match is added.
+	targetDurationMS := 0
+	if targetStr := c.Query("target_duration"); targetStr != "" {
+		seconds, err := strconv.Atoi(targetStr)
+		if err != nil || seconds < 1 || seconds > maxWorkoutDurationSeconds {
+			logger.Error("CreatePlaylistHandler: Invalid target_duration", zap.String("userId", userId), zap.String("targetStr", targetStr))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target_duration: must be between 1 and " + strconv.Itoa(maxWorkoutDurationSeconds) + " seconds"})
+			return
+		}
+		targetDurationMS = seconds * 1000
+	}
+	maxTracks := 0
+	if maxTracksStr := c.Query("max_tracks"); maxTracksStr != "" {
+		maxTracks, err = strconv.Atoi(maxTracksStr)
+		if err != nil || maxTracks < 1 {
+			logger.Error("CreatePlaylistHandler: Invalid max_tracks", zap.String("userId", userId), zap.String("maxTracksStr", maxTracksStr))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max_tracks: must be a positive number"})
+			return
+		}
+	}
+
+	matches, err := matchTracksByBPM(c.Request.Context(), userId, min, max, ratios, sources)
 	if err != nil {
 		logger.Error("CreatePlaylistHandler: Error getting tracks by BPM", zap.String("userId", userId), zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -329,29 +467,151 @@ func CreatePlaylistHandler(c *gin.Context) {
 		})
 		return
 	}
+	// The playlist lists the best matches first
+	tracks, err := rankTracks(c.Request.Context(), userId, min, max, matches)
+	if err != nil {
+		logger.Error("CreatePlaylistHandler: Error ranking tracks", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error ranking tracks: " + err.Error(),
+		})
+		return
+	}
+	switch {
+	case targetDurationMS > 0:
+		tolerance := durationTolerance(targetDurationMS)
+		var durationMS int
+		tracks, durationMS = fitDuration(tracks, targetDurationMS, tolerance, maxTracks, make(map[string]bool))
+		if absInt(durationMS-targetDurationMS) > tolerance {
+			logger.Warn("CreatePlaylistHandler: Not enough matching tracks to fit the target duration",
+				zap.String("userId", userId),
+				zap.Int("targetDurationMS", targetDurationMS),
+				zap.Int("durationMS", durationMS))
+		}
+	case maxTracks > 0 && len(tracks) > maxTracks:
+		tracks = tracks[:maxTracks]
+	}
 	var ids []string
-	for key := range tracks {
-		ids = append(ids, key)
+	for _, track := range tracks {
+		ids = append(ids, track.Id)
 	}
 	logger.Debug("CreatePlaylistHandler: Tracks for playlist retrieved", zap.String("userId", userId), zap.Int("count", len(ids)))
 
-	logger.Debug("Creating playlist",
+	logger.Debug("Creating or updating playlist",
 		zap.String("userId", userId),
 		zap.Float64("minBPM", min),
 		zap.Float64("maxBPM", max),
 		zap.Int("songCount", len(tracks)))
-	playlist, err := spotify.CreatePlaylist(token, userId, bpm, min, max, ids)
-	if err != nil || playlist.Id == "" { // Check playlist.Id as well, as CreatePlaylist might return partial success
-		logger.Error("CreatePlaylistHandler: Error creating playlist", zap.String("userId", userId), zap.Error(err))
+	playlist, err := saveRunDJPlaylist(c.Request.Context(), token, userId, bpm, min, max, ids)
+	if err != nil {
+		logger.Error("CreatePlaylistHandler: Error saving playlist", zap.String("userId", userId), zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Error creating playlist: " + err.Error(),
+			"error": "Error saving playlist: " + err.Error(),
 		})
 		return
 	}
-	logger.Info("CreatePlaylistHandler: Playlist created successfully", zap.String("userId", userId), zap.String("playlistId", playlist.Id))
+	logger.Info("CreatePlaylistHandler: Playlist saved successfully", zap.String("userId", userId), zap.String("playlistId", playlist.Id))
 	c.JSON(http.StatusOK, playlist)
 }
 
+// WorkoutPlaylistHandler plans the tracks of a workout made of segments at different cadences,
+// and saves them as a playlist if asked to
+func WorkoutPlaylistHandler(c *gin.Context) {
+	logger.Info("WorkoutPlaylistHandler called")
+	token := c.Query("access_token")
+	if token == "" {
+		logger.Error("WorkoutPlaylistHandler: Missing access_token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing access_token"})
+		return
+	}
+
+	var request WorkoutRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		logger.Error("WorkoutPlaylistHandler: Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if err := request.validate(); err != nil {
+		logger.Error("WorkoutPlaylistHandler: Invalid workout", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workout: " + err.Error()})
+		return
+	}
+	if _, err := parseTempoMultiples(request.Multiples); err != nil {
+		logger.Error("WorkoutPlaylistHandler: Invalid multiples", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multiples: " + err.Error()})
+		return
+	}
+
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
+	if err != nil {
+		logger.Error("WorkoutPlaylistHandler: Error getting user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error getting user: " + err.Error(),
+		})
+		return
+	}
+	userId := user.Id
+	if userId == "" {
+		logger.Error("WorkoutPlaylistHandler: Missing userId after GetUser call")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing userId"})
+		return
+	}
+	logger.Debug("WorkoutPlaylistHandler: User identified", zap.String("userId", userId), zap.Int("segmentCount", len(request.Segments)))
+
+	plan, err := buildWorkoutPlan(c.Request.Context(), userId, &request)
+	if err != nil {
+		logger.Error("WorkoutPlaylistHandler: Error planning workout", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error planning workout: " + err.Error(),
+		})
+		return
+	}
+	logger.Info("WorkoutPlaylistHandler: Workout planned",
+		zap.String("userId", userId),
+		zap.Int("targetDurationMS", plan.TargetDurationMS),
+		zap.Int("durationMS", plan.DurationMS))
+
+	if request.CreatePlaylist {
+		plan.Playlist, err = createWorkoutPlaylist(c.Request.Context(), token, userId, &request, plan)
+		if err != nil {
+			logger.Error("WorkoutPlaylistHandler: Error creating playlist", zap.String("userId", userId), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Error creating playlist: " + err.Error(),
+			})
+			return
+		}
+		logger.Info("WorkoutPlaylistHandler: Playlist created", zap.String("userId", userId), zap.String("playlistId", plan.Playlist.Id))
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// CoverPreviewHandler renders the cover a RunDJ playlist at bpm gets, as a JPEG
+func CoverPreviewHandler(c *gin.Context) {
+	bpm, err := strconv.ParseFloat(c.Param("bpm"), 64)
+	if err != nil || bpm <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bpm"})
+		return
+	}
+	min, err := strconv.ParseFloat(c.DefaultQuery("min", strconv.FormatFloat(bpm-1.5, 'f', -1, 64)), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min: " + err.Error()})
+		return
+	}
+	max, err := strconv.ParseFloat(c.DefaultQuery("max", strconv.FormatFloat(bpm+1.5, 'f', -1, 64)), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max: " + err.Error()})
+		return
+	}
+
+	jpeg, err := cover.EncodeJPEG(cover.Options{BPM: bpm, MinBPM: min, MaxBPM: max})
+	if err != nil {
+		logger.Error("CoverPreviewHandler: Error rendering cover", zap.Float64("bpm", bpm), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rendering cover: " + err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/jpeg", jpeg)
+}
+
 func FeedbackHandler(c *gin.Context) {
 	logger.Info("FeedbackHandler called")
 	token := c.Query("access_token")
@@ -360,7 +620,7 @@ func FeedbackHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing access_token"})
 		return
 	}
-	user, err := spotify.GetUser(token)
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
 	if err != nil {
 		logger.Error("FeedbackHandler: Error getting user", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -402,7 +662,7 @@ func FeedbackHandler(c *gin.Context) {
 	}
 	logger.Debug("FeedbackHandler: Feedback processed", zap.String("userId", userId), zap.String("songId", songId), zap.String("feedback", feedback), zap.Int("feedbackInt", feedbackInt))
 
-	err = db.SaveFeedback(userId, songId, feedbackInt) // Assuming SaveFeedback has its own logging if necessary
+	err = db.SaveFeedback(c.Request.Context(), userId, songId, feedbackInt) // Assuming SaveFeedback has its own logging if necessary
 	if err != nil {
 		logger.Error("FeedbackHandler: Error saving feedback", zap.String("userId", userId), zap.String("songId", songId), zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -413,3 +673,156 @@ func FeedbackHandler(c *gin.Context) {
 	logger.Info("FeedbackHandler: Feedback saved successfully", zap.String("userId", userId), zap.String("songId", songId))
 	c.JSON(http.StatusOK, true)
 }
+
+// identifyUser returns the id of the Spotify user whose access_token the request carries. If
+// there is none it responds with the error and returns false.
+func identifyUser(c *gin.Context, handler string) (string, bool) {
+	token := c.Query("access_token")
+	if token == "" {
+		logger.Error(handler + ": Missing access_token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing access_token"})
+		return "", false
+	}
+	user, err := spotifyClient().GetUser(c.Request.Context(), token)
+	if err != nil {
+		logger.Error(handler+": Error getting user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error getting user: " + err.Error(),
+		})
+		return "", false
+	}
+	if user.Id == "" {
+		logger.Error(handler + ": Missing userId after GetUser call")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing userId"})
+		return "", false
+	}
+	logger.Debug(handler+": User identified", zap.String("userId", user.Id))
+	return user.Id, true
+}
+
+// GetRunnerProfileHandler returns the user's runner profile, empty if they haven't set one
+func GetRunnerProfileHandler(c *gin.Context) {
+	logger.Info("GetRunnerProfileHandler called")
+	userId, ok := identifyUser(c, "GetRunnerProfileHandler")
+	if !ok {
+		return
+	}
+
+	profile, err := db.GetRunnerProfile(c.Request.Context(), userId)
+	if err != nil {
+		logger.Error("GetRunnerProfileHandler: Error getting runner profile", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error getting runner profile: " + err.Error(),
+		})
+		return
+	}
+	if profile == nil {
+		profile = &db.RunnerProfile{UserId: userId, PaceZones: []db.PaceZone{}}
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// SaveRunnerProfileHandler replaces the user's runner profile with the one in the request body
+func SaveRunnerProfileHandler(c *gin.Context) {
+	logger.Info("SaveRunnerProfileHandler called")
+	userId, ok := identifyUser(c, "SaveRunnerProfileHandler")
+	if !ok {
+		return
+	}
+
+	var profile db.RunnerProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		logger.Error("SaveRunnerProfileHandler: Invalid request body", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if err := validateRunnerProfile(&profile); err != nil {
+		logger.Error("SaveRunnerProfileHandler: Invalid runner profile", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid runner profile: " + err.Error()})
+		return
+	}
+	profile.UserId = userId
+
+	if err := db.SaveRunnerProfile(c.Request.Context(), &profile); err != nil {
+		logger.Error("SaveRunnerProfileHandler: Error saving runner profile", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error saving runner profile: " + err.Error(),
+		})
+		return
+	}
+	logger.Info("SaveRunnerProfileHandler: Runner profile saved", zap.String("userId", userId))
+	c.JSON(http.StatusOK, true)
+}
+
+// AddRunnerActivityHandler records a run of the user, which calibrates their cadence recommendations
+func AddRunnerActivityHandler(c *gin.Context) {
+	logger.Info("AddRunnerActivityHandler called")
+	userId, ok := identifyUser(c, "AddRunnerActivityHandler")
+	if !ok {
+		return
+	}
+
+	var activity db.RunnerActivity
+	if err := c.ShouldBindJSON(&activity); err != nil {
+		logger.Error("AddRunnerActivityHandler: Invalid request body", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if err := validateRunnerActivity(&activity); err != nil {
+		logger.Error("AddRunnerActivityHandler: Invalid activity", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid activity: " + err.Error()})
+		return
+	}
+
+	activityId, err := db.SaveRunnerActivity(c.Request.Context(), userId, &activity)
+	if err != nil {
+		logger.Error("AddRunnerActivityHandler: Error saving activity", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error saving activity: " + err.Error(),
+		})
+		return
+	}
+	activity.ActivityId = activityId
+	logger.Info("AddRunnerActivityHandler: Activity saved", zap.String("userId", userId), zap.Int64("activityId", activityId))
+	c.JSON(http.StatusOK, activity)
+}
+
+// CadenceHandler recommends the cadence, and the BPM to pass to the BPM endpoints, for running at
+// a pace, speed or pace zone of the user's profile
+func CadenceHandler(c *gin.Context) {
+	logger.Info("CadenceHandler called")
+	userId, ok := identifyUser(c, "CadenceHandler")
+	if !ok {
+		return
+	}
+
+	profile, err := db.GetRunnerProfile(c.Request.Context(), userId)
+	if err != nil {
+		logger.Error("CadenceHandler: Error getting runner profile", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error getting runner profile: " + err.Error(),
+		})
+		return
+	}
+
+	speed, err := targetSpeed(c.Query("pace"), c.Query("speed"), c.Query("zone"), c.Query("unit"), profile)
+	if err != nil {
+		logger.Error("CadenceHandler: Invalid target", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target: " + err.Error()})
+		return
+	}
+
+	recommendation, err := recommendCadence(c.Request.Context(), userId, speed, profile)
+	if err != nil {
+		logger.Error("CadenceHandler: Error recommending cadence", zap.String("userId", userId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error recommending cadence: " + err.Error(),
+		})
+		return
+	}
+	logger.Info("CadenceHandler: Cadence recommended",
+		zap.String("userId", userId),
+		zap.Float64("cadence", recommendation.Cadence),
+		zap.String("calibration", recommendation.Calibration))
+	c.JSON(http.StatusOK, recommendation)
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+// --- Durable Job Queue ---
+//
+// When JOB_QUEUE=postgres, jobs implementing DurableJob are written to the job_queue table
+// instead of the in-memory channel, so they survive restarts and can be drained by any
+// instance (or by the standalone worker binary). Jobs that carry a user access token stay
+// in memory, since tokens should not be persisted and expire within the hour anyway.
+
+const (
+	defaultJobQueueWorkers      = 16
+	defaultJobVisibilityTimeout = 5 * time.Minute
+	defaultJobMaxAttempts       = 5
+	jobQueuePollInterval        = 2 * time.Second
+	durableStageWaitInterval    = 2 * time.Second
+)
+
+// DurableJob is a Job that can be serialized to the durable job queue
+type DurableJob interface {
+	Job
+	JobType() string
+}
+
+// durableJobTypes maps a persisted job_type back to a fresh job to decode the payload into
+var durableJobTypes = map[string]func() DurableJob{
+	"saveArtistTopTracks": func() DurableJob { return &SaveArtistTopTracksJob{} },
+	"saveArtistAlbums":    func() DurableJob { return &SaveArtistAlbumsJob{} },
+	"saveAlbumTracks":     func() DurableJob { return &SaveAlbumTracksJob{} },
+}
+
+func (j *SaveArtistTopTracksJob) JobType() string { return "saveArtistTopTracks" }
+func (j *SaveArtistAlbumsJob) JobType() string    { return "saveArtistAlbums" }
+func (j *SaveAlbumTracksJob) JobType() string     { return "saveAlbumTracks" }
+
+// DurableQueueEnabled reports whether jobs should go through the Postgres job queue
+func DurableQueueEnabled() bool {
+	return os.Getenv("JOB_QUEUE") == "postgres"
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func encodeDurableJob(job DurableJob, stage *StageContext) (*db.QueuedJob, error) {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling %s job: %w", job.JobType(), err)
+	}
+
+	// Jobs with the same type and payload are only queued once per sync run, like the
+	// ProcessedTracker dedupes the in-memory pool
+	sum := sha256.Sum256(payload)
+	queued := &db.QueuedJob{
+		JobType:     job.JobType(),
+		Payload:     payload,
+		DedupeKey:   job.JobType() + ":" + hex.EncodeToString(sum[:]),
+		MaxAttempts: getEnvInt("JOB_QUEUE_MAX_ATTEMPTS", defaultJobMaxAttempts),
+	}
+	if stage != nil {
+		queued.Stage = stage.name
+		if stage.run != nil {
+			syncRunId := stage.run.id
+			queued.SyncRunId = &syncRunId
+		}
+	}
+	return queued, nil
+}
+
+func decodeDurableJob(queued *db.QueuedJob) (DurableJob, error) {
+	newJob, ok := durableJobTypes[queued.JobType]
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", queued.JobType)
+	}
+	job := newJob()
+	if err := json.Unmarshal(queued.Payload, job); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s job %d: %w", queued.JobType, queued.JobId, err)
+	}
+	return job, nil
+}
+
+// enqueueDurable writes the job to the durable queue. It returns false if the job
+// is not durable or could not be written, in which case the caller should run it in memory.
+func (wp *WorkerPool) enqueueDurable(job Job, stage *StageContext) bool {
+	durableJob, ok := job.(DurableJob)
+	if !ok {
+		return false
+	}
+
+	queued, err := encodeDurableJob(durableJob, stage)
+	if err == nil {
+		err = db.EnqueueJobs(wp.ctx, []*db.QueuedJob{queued})
+	}
+	if err != nil {
+		logger.Error("Error enqueueing durable job, falling back to in-memory queue",
+			zap.String("jobType", durableJob.JobType()),
+			zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// waitForDurableJobs blocks until the durable queue has no open jobs left for a stage of the run,
+// or until ctx is done
+func waitForDurableJobs(ctx context.Context, stage *StageContext) {
+	for {
+		counts, err := db.GetJobCounts(ctx, stage.run.id)
+		if err != nil {
+			logger.Error("Error getting durable job counts",
+				zap.Int64("syncRunId", stage.run.id),
+				zap.String("stage", stage.name),
+				zap.Error(err))
+		} else if c, ok := counts[stage.name]; !ok || c.Open == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(durableStageWaitInterval):
+		}
+	}
+}
+
+// JobQueueWorker leases jobs from the durable queue and executes them
+type JobQueueWorker struct {
+	id                string
+	concurrency       int
+	visibilityTimeout time.Duration
+}
+
+func NewJobQueueWorker() *JobQueueWorker {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &JobQueueWorker{
+		id:                fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		concurrency:       getEnvInt("JOB_QUEUE_WORKERS", defaultJobQueueWorkers),
+		visibilityTimeout: getEnvDuration("JOB_QUEUE_VISIBILITY_TIMEOUT", defaultJobVisibilityTimeout),
+	}
+}
+
+// Run drains the durable queue until ctx is done. Jobs already leased are finished before returning.
+func (w *JobQueueWorker) Run(ctx context.Context) {
+	logger.Info("Job queue worker started",
+		zap.String("workerId", w.id),
+		zap.Int("concurrency", w.concurrency),
+		zap.Duration("visibilityTimeout", w.visibilityTimeout))
+
+	// One pool and tracker serve every batch. Child jobs they submit are written back to the
+	// durable queue; the pool only runs anything that could not be enqueued. It runs detached
+	// from ctx for the same reason leased jobs do.
+	pool := NewWorkerPool(w.concurrency, w.concurrency*10)
+	pool.durable = true
+	tracker := NewProcessedTracker()
+	var jobWg sync.WaitGroup
+	pool.Start(context.Background(), &jobWg, tracker)
+
+	errorsDone := make(chan struct{})
+	go func() {
+		defer close(errorsDone)
+		for err := range pool.resultsChan {
+			logger.Error("Job queue worker: in-memory fallback job failed", zap.Error(err))
+		}
+	}()
+	defer func() {
+		jobWg.Wait()
+		pool.Stop()
+		<-errorsDone
+	}()
+
+	for {
+		if ctx.Err() != nil {
+			logger.Info("Job queue worker stopped", zap.String("workerId", w.id))
+			return
+		}
+
+		leased, err := db.LeaseJobs(ctx, w.id, w.concurrency, w.visibilityTimeout)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("Error leasing jobs", zap.String("workerId", w.id), zap.Error(err))
+		}
+		if len(leased) == 0 {
+			// The tracker only remembers what was saved while the queue is busy, so it
+			// doesn't grow for the life of the process
+			tracker.Reset()
+			select {
+			case <-ctx.Done():
+			case <-time.After(jobQueuePollInterval):
+			}
+			continue
+		}
+
+		w.executeBatch(leased, pool, &jobWg, tracker)
+	}
+}
+
+// executeBatch runs a batch of leased jobs and waits for them and any in-memory jobs they submit.
+// Leased jobs run detached from the worker's context so shutdown never abandons them halfway;
+// anything left unfinished is picked up again once its lease expires.
+func (w *JobQueueWorker) executeBatch(leased []*db.QueuedJob, pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker) {
+	ctx := context.Background()
+
+	var batchWg sync.WaitGroup
+	for _, queued := range leased {
+		batchWg.Add(1)
+		go func() {
+			defer batchWg.Done()
+			w.execute(ctx, queued, pool, jobWg, tracker)
+		}()
+	}
+	batchWg.Wait()
+
+	jobWg.Wait()
+}
+
+func (w *JobQueueWorker) execute(ctx context.Context, queued *db.QueuedJob, pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker) {
+	job, err := decodeDurableJob(queued)
+	if err == nil {
+		// Jobs run detached from the instance that owns the sync run, so the stage only
+		// carries the identifiers needed to tag any child jobs
+		var stage *StageContext
+		if queued.SyncRunId != nil {
+			stage = &StageContext{
+				wg:   &sync.WaitGroup{},
+				name: queued.Stage,
+				run:  &SyncRun{id: *queued.SyncRunId},
+			}
+		}
+		err = job.Execute(ctx, pool, jobWg, tracker, stage)
+	}
+
+	if err != nil {
+		retryAfter := time.Duration(queued.Attempts*queued.Attempts) * 10 * time.Second
+		logger.Error("Durable job failed",
+			zap.String("workerId", w.id),
+			zap.Int64("jobId", queued.JobId),
+			zap.String("jobType", queued.JobType),
+			zap.Int("attempt", queued.Attempts),
+			zap.Int("maxAttempts", queued.MaxAttempts),
+			zap.Duration("retryAfter", retryAfter),
+			zap.Error(err))
+		if err := db.FailJob(ctx, queued.JobId, err, retryAfter); err != nil {
+			logger.Error("Error recording durable job failure", zap.Int64("jobId", queued.JobId), zap.Error(err))
+		}
+		return
+	}
+
+	if err := db.CompleteJob(ctx, queued.JobId); err != nil {
+		logger.Error("Error completing durable job", zap.Int64("jobId", queued.JobId), zap.Error(err))
+	}
+}
+
+// StartJobQueueWorker runs a durable queue worker in the background when the durable queue is enabled
+func StartJobQueueWorker(ctx context.Context) {
+	if !DurableQueueEnabled() {
+		return
+	}
+	go NewJobQueueWorker().Run(ctx)
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+const (
+	// leaseTTL is how long a lease outlives a holder that stopped renewing it, e.g. because it crashed
+	leaseTTL = 30 * time.Second
+	// leaseRenewInterval leaves room for a couple of failed renewals before the lease expires
+	leaseRenewInterval = leaseTTL / 3
+)
+
+// heldLease is a lease kept renewed in the background until release is called
+type heldLease struct {
+	lease *db.Lease
+	// ctx is cancelled once the lease is released or lost, so work done under it stops
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// acquireLease takes the named lease without waiting and renews it until release. It returns nil
+// if the lease is held elsewhere.
+func acquireLease(ctx context.Context, name string) (*heldLease, error) {
+	lease, err := db.TryAcquireLease(ctx, name, leaseTTL)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring lease %s: %w", name, err)
+	}
+	if lease == nil {
+		return nil, nil
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	held := &heldLease{lease: lease, ctx: leaseCtx, cancel: cancel, done: make(chan struct{})}
+	go held.renew(name)
+	return held, nil
+}
+
+func (h *heldLease) renew(name string) {
+	defer close(h.done)
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+
+	lastRenewed := time.Now()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed, err := h.lease.Renew(h.ctx)
+		if err != nil {
+			logger.Warn("Error renewing lease", zap.String("name", name), zap.Error(err))
+			// Without a renewal the lease may have been taken over, so stop once it has run out
+			if time.Since(lastRenewed) < leaseTTL {
+				continue
+			}
+		}
+		if !renewed {
+			logger.Error("Lost lease, stopping the work done under it", zap.String("name", name))
+			h.cancel()
+			return
+		}
+		lastRenewed = time.Now()
+	}
+}
+
+// release stops renewing the lease and gives it up
+func (h *heldLease) release() {
+	h.cancel()
+	<-h.done
+	h.lease.Release()
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -13,9 +14,10 @@ import (
 type SavePlaylistTracksJob struct {
 	Token      string
 	PlaylistID string
+	SnapshotID string
 }
 
-func createPlaylistBatcher(userId string, tracker *ProcessedTracker) *spotify.BatchProcessor[*spotify.Playlist] {
+func createPlaylistBatcher(ctx context.Context, userId string, tracker *ProcessedTracker) *spotify.BatchProcessor[*spotify.Playlist] {
 	return spotify.NewBatchProcessor(100, func(playlists []*spotify.Playlist) error {
 		dbPlaylists := convertSpotifyPlaylistsToDBPlaylists(playlists)
 		var playlistsToSave []*db.Playlist
@@ -26,14 +28,14 @@ func createPlaylistBatcher(userId string, tracker *ProcessedTracker) *spotify.Ba
 		}
 
 		if len(playlistsToSave) > 0 {
-			if err := db.SavePlaylists(playlistsToSave); err != nil {
+			if err := db.SavePlaylists(ctx, playlistsToSave); err != nil {
 				return fmt.Errorf("saving playlists batch: %w", err)
 			}
 			logger.Debug("Saved batch of playlists to DB",
 				zap.String("userId", userId))
 		}
 
-		if err := db.SaveUserPlaylists(userId, dbPlaylists); err != nil {
+		if err := db.SaveUserPlaylists(ctx, userId, dbPlaylists); err != nil {
 			return fmt.Errorf("saving user-playlist relations: %w", err)
 		}
 
@@ -44,16 +46,18 @@ func createPlaylistBatcher(userId string, tracker *ProcessedTracker) *spotify.Ba
 
 }
 
-func (j *SavePlaylistTracksJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
+func (j *SavePlaylistTracksJob) Execute(ctx context.Context, pool *WorkerPool, jobWg *sync.WaitGroup, tracker *ProcessedTracker, stage *StageContext) error {
 	token := j.Token
 	playlistId := j.PlaylistID
 
 	logger.Debug("Executing SavePlaylistTracksJob",
 		zap.String("playlistId", playlistId))
 
-	trackBatcher := createTrackBatcher("playlist", playlistId, tracker, db.SavePlaylistTracks)
+	trackBatcher := createTrackBatcher(ctx, "playlist", playlistId, tracker, db.SavePlaylistTracks)
 
-	err := spotify.GetPlaylistsTracks(token, playlistId, func(tracks []*spotify.Track) error {
+	var seenIds []string
+	err := spotifyClient().GetPlaylistsTracks(ctx, token, playlistId, func(tracks []*spotify.Track) error {
+		seenIds = append(seenIds, trackIds(tracks)...)
 		for _, track := range tracks {
 			if err := trackBatcher.Add(track); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
@@ -68,27 +72,54 @@ func (j *SavePlaylistTracksJob) Execute(pool *WorkerPool, jobWg *sync.WaitGroup,
 	if err := trackBatcher.Flush(); err != nil {
 		return fmt.Errorf("flushing remaining tracks for playlist %s: %w", playlistId, err)
 	}
+	if err := reconcile(ctx, "playlist tracks", playlistId, seenIds, db.DeletePlaylistTracksExcept); err != nil {
+		return err
+	}
+	// Only recorded once the crawl is complete, so a failed crawl is retried on the next sync
+	if err := db.MarkPlaylistSynced(ctx, playlistId, j.SnapshotID); err != nil {
+		logger.Warn("Error marking playlist synced",
+			zap.String("playlistId", playlistId),
+			zap.Error(err))
+	}
 
 	logger.Debug("Executed SavePlaylistTracksJob",
 		zap.String("playlistId", playlistId))
 	return nil
 }
 
-func processPlaylists(userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
+func processPlaylists(ctx context.Context, userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
 	logger.Debug("Processing user playlists",
 		zap.String("userId", userId))
 
-	playlistBatcher := createPlaylistBatcher(userId, tracker)
+	playlistBatcher := createPlaylistBatcher(ctx, userId, tracker)
+
+	var seenIds []string
+	// Track crawls are submitted once the playlists are saved, so the snapshot can be recorded on their rows
+	var changed []*SavePlaylistTracksJob
+	unchanged := 0
+	err := spotifyClient().GetUsersPlaylists(ctx, token, func(playlists []*spotify.Playlist) error {
+		var pageIds []string
+		for _, playlist := range playlists {
+			if playlist.Id != "" {
+				pageIds = append(pageIds, playlist.Id)
+			}
+		}
+		seenIds = append(seenIds, pageIds...)
+		snapshots := getPlaylistSnapshots(ctx, pageIds)
 
-	err := spotify.GetUsersPlaylists(token, func(playlists []*spotify.Playlist) error {
 		for _, playlist := range playlists {
 			if err := playlistBatcher.Add(playlist); err != nil {
 				return fmt.Errorf("adding playlist to batch: %w", err)
 			}
-			pool.SubmitWithStage(&SavePlaylistTracksJob{
+			if playlist.SnapshotId != "" && snapshots[playlist.Id] == playlist.SnapshotId {
+				unchanged++
+				continue
+			}
+			changed = append(changed, &SavePlaylistTracksJob{
 				Token:      token,
 				PlaylistID: playlist.Id,
-			}, jobWg, stage)
+				SnapshotID: playlist.SnapshotId,
+			})
 		}
 
 		logger.Debug("Processed batch of playlists",
@@ -103,7 +134,29 @@ func processPlaylists(userId string, token string, pool *WorkerPool, tracker *Pr
 		return fmt.Errorf("flushing remaining playlists: %w", err)
 	}
 
+	for _, job := range changed {
+		pool.SubmitWithStage(job, jobWg, stage)
+	}
+
+	if err := reconcile(ctx, "user playlists", userId, seenIds, db.DeleteUserPlaylistsExcept); err != nil {
+		return err
+	}
+
 	logger.Debug("Processed user playlists",
-		zap.String("userId", userId))
+		zap.String("userId", userId),
+		zap.Int("changed", len(changed)),
+		zap.Int("unchanged", unchanged))
 	return nil
 }
+
+// getPlaylistSnapshots returns the last crawled snapshot of each playlist. Lookup errors are logged
+// and treated as no snapshots, so every playlist is crawled.
+func getPlaylistSnapshots(ctx context.Context, playlistIds []string) map[string]string {
+	snapshots, err := db.GetPlaylistSnapshots(ctx, playlistIds)
+	if err != nil {
+		logger.Warn("Error getting playlist snapshots, crawling all playlists",
+			zap.Error(err))
+		return nil
+	}
+	return snapshots
+}
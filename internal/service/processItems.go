@@ -1,123 +1,243 @@
 package service
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/rcong315/RunDJServer/internal/db"
 )
 
 // TODO: Clean up nested size = 0 checks
 
-// TODO: When to run this function? On register and when else? Cron?
+type stageFunc func(context.Context, string, string, *WorkerPool, *ProcessedTracker, *sync.WaitGroup, *StageContext) error
+
+// syncStages lists the processing stages of a sync run, in the order they are reported
+var syncStages = []struct {
+	name    string
+	process stageFunc
+}{
+	{"topTracks", processTopTracks},
+	{"savedTracks", processSavedTracks},
+	{"playlists", processPlaylists},
+	{"topArtists", processTopArtists},
+	{"followedArtists", processFollowedArtists},
+	{"savedAlbums", processSavedAlbums},
+}
+
 // TODO: Add release radar playlist
+// processAll syncs the user's library in the background. It runs when a user registers or logs in
+// with stale data, and from the refresh scheduler. The sync outlives the request that started it
+// and stops early if cancelled through CancelSync or StopSyncs.
 func processAll(token string, userId string) {
 	logger.Info("Queuing background data processing", zap.String("userId", userId))
 
+	activeSyncsWg.Add(1)
 	go func() {
-		startTime := time.Now()
-
-		logger.Info("Starting data processing",
-			zap.String("userId", userId),
-			zap.Time("startTime", startTime))
-
-		numWorkers := 32
-		jobQueueSize := 100 * 1000
-
-		pool := NewWorkerPool(numWorkers, jobQueueSize)
-		tracker := NewProcessedTracker()
-		var jobWg sync.WaitGroup
+		defer activeSyncsWg.Done()
+		syncLibrary(token, userId)
+	}()
+}
 
-		var allErrors []error
-		var errorMu sync.Mutex
+// syncFlight coalesces concurrent syncs of the same user within this instance
+var syncFlight singleflight.Group
+
+// syncLibrary runs a full sync of the user's library and returns once it has finished.
+// If the user is already being synced by this instance, it attaches to that sync instead.
+// It returns the id of the sync run, or of the run another instance has in progress, and 0 if
+// no sync could be started. Callers must hold activeSyncsWg.
+func syncLibrary(token string, userId string) int64 {
+	syncRunId, _, shared := syncFlight.Do(userId, func() (any, error) {
+		return runSync(token, userId), nil
+	})
+	if shared {
+		logger.Info("Sync was shared by concurrent requests", zap.String("userId", userId))
+	}
+	return syncRunId.(int64)
+}
 
-		// Error collection goroutine
-		errorCollectionWg := sync.WaitGroup{}
-		errorCollectionWg.Add(1)
-		go func() {
-			defer errorCollectionWg.Done()
-			for err := range pool.resultsChan {
-				if err != nil {
-					errorMu.Lock()
-					allErrors = append(allErrors, err)
-					errorMu.Unlock()
-				}
-			}
-		}()
+// runSync syncs the user's library unless another instance is already syncing it, and returns the
+// id of the sync run doing it
+func runSync(token string, userId string) int64 {
+	startTime := time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Only one sync per user may run across all instances. The lease is renewed while the sync
+	// runs and cancels it if lost, so a stalled instance never ends up syncing next to another.
+	// It stands in for pg_try_advisory_lock: the pool goes through PgBouncer in transaction mode
+	// (see db.initDB), where a session lock is taken on whichever server connection runs the
+	// statement and may be released on another, and a transaction lock would keep a transaction
+	// and its connection open for the whole sync.
+	lease, err := acquireLease(ctx, syncLeaseName(userId))
+	if err != nil {
+		logger.Error("Error locking user sync, aborting data processing",
+			zap.String("userId", userId),
+			zap.Error(err))
+		return 0
+	}
+	if lease == nil {
+		return runningSyncRunId(ctx, userId)
+	}
+	defer lease.release()
+	ctx = lease.ctx
+
+	logger.Info("Starting data processing",
+		zap.String("userId", userId),
+		zap.Time("startTime", startTime))
+
+	stageNames := make([]string, len(syncStages))
+	for i, stage := range syncStages {
+		stageNames[i] = stage.name
+	}
+	run, err := newSyncRun(ctx, userId, stageNames, DurableQueueEnabled())
+	if err != nil {
+		logger.Error("Error creating sync run, aborting data processing",
+			zap.String("userId", userId),
+			zap.Error(err))
+		return 0
+	}
+	run.cancel = cancel
+	registerSync(userId, run.id, cancel)
+	defer unregisterSync(userId, run.id)
+
+	run.market, err = db.GetUserCountry(ctx, userId)
+	if err != nil {
+		logger.Warn("Error getting user country, fetching catalog without a market",
+			zap.String("userId", userId),
+			zap.Error(err))
+	}
 
-		pool.Start(&jobWg, tracker)
+	numWorkers := 32
+	jobQueueSize := 100 * 1000
 
-		processAndCollectErrors := func(name string, processFunc func(string, string, *WorkerPool, *ProcessedTracker, *sync.WaitGroup, *StageContext) error) {
-			funcStart := time.Now()
+	pool := NewWorkerPool(numWorkers, jobQueueSize)
+	tracker := NewProcessedTracker()
+	var jobWg sync.WaitGroup
 
-			// Create a stage-specific wait group
-			stageWg := &sync.WaitGroup{}
-			stageCtx := &StageContext{
-				wg:   stageWg,
-				name: name,
-			}
+	var allErrors []error
+	var errorMu sync.Mutex
 
-			if err := processFunc(userId, token, pool, tracker, &jobWg, stageCtx); err != nil {
+	// Error collection goroutine
+	errorCollectionWg := sync.WaitGroup{}
+	errorCollectionWg.Add(1)
+	go func() {
+		defer errorCollectionWg.Done()
+		for err := range pool.resultsChan {
+			if err != nil {
 				errorMu.Lock()
 				allErrors = append(allErrors, err)
 				errorMu.Unlock()
 			}
+		}
+	}()
+
+	pool.Start(ctx, &jobWg, tracker)
 
-			// Wait for all jobs in this stage to complete
-			stageWg.Wait()
+	stopPersisting := make(chan struct{})
+	go run.persistPeriodically(stopPersisting)
 
-			logger.Info("Processing stage completed",
-				zap.String("userId", userId),
-				zap.String("stage", name),
-				zap.Duration("stageDuration", time.Since(funcStart)))
+	processAndCollectErrors := func(name string, processFunc stageFunc) {
+		stageCtx := run.stage(name)
+		stageCtx.start()
+
+		err := processFunc(ctx, userId, token, pool, tracker, &jobWg, stageCtx)
+
+		// Wait for all jobs in this stage to complete
+		stageCtx.wg.Wait()
+		if pool.durable {
+			waitForDurableJobs(ctx, stageCtx)
+		}
+		if err == nil && ctx.Err() == nil {
+			err = stageCtx.runAfterJobs(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			errorMu.Lock()
+			allErrors = append(allErrors, err)
+			errorMu.Unlock()
 		}
+		stageCtx.finish(ctx, err)
+		run.persist()
 
-		var stagesWg sync.WaitGroup
-		stagesWg.Add(6)
-		go func() {
-			defer stagesWg.Done()
-			processAndCollectErrors("topTracks", processTopTracks)
-		}()
-		go func() {
-			defer stagesWg.Done()
-			processAndCollectErrors("savedTracks", processSavedTracks)
-		}()
-		go func() {
-			defer stagesWg.Done()
-			processAndCollectErrors("playlists", processPlaylists)
-		}()
-		go func() {
-			defer stagesWg.Done()
-			processAndCollectErrors("topArtists", processTopArtists)
-		}()
-		go func() {
-			defer stagesWg.Done()
-			processAndCollectErrors("followedArtists", processFollowedArtists)
-		}()
+		logger.Info("Processing stage completed",
+			zap.String("userId", userId),
+			zap.String("stage", name),
+			zap.Duration("stageDuration", time.Since(stageCtx.startedAt)))
+	}
+
+	var stagesWg sync.WaitGroup
+	for _, stage := range syncStages {
+		stagesWg.Add(1)
 		go func() {
 			defer stagesWg.Done()
-			processAndCollectErrors("savedAlbums", processSavedAlbums)
+			processAndCollectErrors(stage.name, stage.process)
 		}()
-		stagesWg.Wait()
-
-		jobWg.Wait()
-		pool.Stop()
-		errorCollectionWg.Wait()
-
-		duration := time.Since(startTime)
-
-		if len(allErrors) > 0 {
-			logger.Error("Background data processing finished with errors",
-				zap.String("userId", userId),
-				zap.Int("errorCount", len(allErrors)),
-				zap.Duration("duration", duration),
-				zap.String("durationFormatted", duration.String()),
-				zap.Errors("errors", allErrors))
-		} else {
-			logger.Info("Background data processing finished successfully",
-				zap.String("userId", userId),
-				zap.Duration("duration", duration),
-				zap.String("durationFormatted", duration.String()))
-		}
-	}()
+	}
+	stagesWg.Wait()
+
+	jobWg.Wait()
+	pool.Stop()
+	errorCollectionWg.Wait()
+
+	close(stopPersisting)
+	run.finish(ctx)
+	if ctx.Err() != nil && pool.durable {
+		run.cancelDurableJobs()
+	}
+	run.persist()
+
+	duration := time.Since(startTime)
+
+	if ctx.Err() != nil {
+		logger.Info("Background data processing cancelled",
+			zap.String("userId", userId),
+			zap.Int64("syncRunId", run.id),
+			zap.Duration("duration", duration))
+	} else if len(allErrors) > 0 {
+		logger.Error("Background data processing finished with errors",
+			zap.String("userId", userId),
+			zap.Int64("syncRunId", run.id),
+			zap.Int("errorCount", len(allErrors)),
+			zap.Duration("duration", duration),
+			zap.String("durationFormatted", duration.String()),
+			zap.Errors("errors", allErrors))
+	} else {
+		logger.Info("Background data processing finished successfully",
+			zap.String("userId", userId),
+			zap.Int64("syncRunId", run.id),
+			zap.Duration("duration", duration),
+			zap.String("durationFormatted", duration.String()))
+	}
+	return run.id
+}
+
+// syncLeaseName is the lease held while a user's library is being synced
+func syncLeaseName(userId string) string {
+	return "sync:" + userId
+}
+
+// runningSyncRunId returns the id of the sync run another instance holds the user's sync lease
+// for, so callers can follow it instead of starting their own, or 0 if it can't be found
+func runningSyncRunId(ctx context.Context, userId string) int64 {
+	run, err := db.GetLatestSyncRun(ctx, userId)
+	if err != nil {
+		logger.Warn("User is already being synced by another instance, error getting its sync run",
+			zap.String("userId", userId),
+			zap.Error(err))
+		return 0
+	}
+	if run == nil || run.Status != syncStatusRunning {
+		// The other instance hasn't created its run yet
+		logger.Info("User is already being synced by another instance, skipping",
+			zap.String("userId", userId))
+		return 0
+	}
+	logger.Info("User is already being synced by another instance, following its sync run",
+		zap.String("userId", userId),
+		zap.Int64("syncRunId", run.SyncRunId))
+	return run.SyncRunId
 }
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/spotify/spotifytest"
+	"github.com/rcong315/RunDJServer/internal/tempo"
+)
+
+// fakeStatement is a statement run against a fakePool
+type fakeStatement struct {
+	sql  string
+	args []any
+}
+
+// fakePool records every statement it is sent. Queries return no rows, and single row queries
+// scan nothing but ids, which are handed out in order.
+type fakePool struct {
+	mu         sync.Mutex
+	statements []fakeStatement
+	lastId     atomic.Int64
+}
+
+func (p *fakePool) record(sql string, args []any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statements = append(p.statements, fakeStatement{sql: sql, args: args})
+}
+
+// matching returns the statements whose SQL contains fragment
+func (p *fakePool) matching(fragment string) []fakeStatement {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var matched []fakeStatement
+	for _, statement := range p.statements {
+		if strings.Contains(statement.sql, fragment) {
+			matched = append(matched, statement)
+		}
+	}
+	return matched
+}
+
+func (p *fakePool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	p.record(sql, args)
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (p *fakePool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	p.record(sql, args)
+	return &fakeRows{}, nil
+}
+
+func (p *fakePool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	p.record(sql, args)
+	return &fakeRow{pool: p}
+}
+
+func (p *fakePool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &fakeTx{pool: p}, nil
+}
+
+type fakeRows struct {
+	pgx.Rows
+}
+
+func (r *fakeRows) Next() bool { return false }
+func (r *fakeRows) Err() error { return nil }
+func (r *fakeRows) Close()     {}
+
+type fakeRow struct {
+	pool *fakePool
+}
+
+func (r *fakeRow) Scan(dest ...any) error {
+	for _, d := range dest {
+		if id, ok := d.(*int64); ok {
+			*id = r.pool.lastId.Add(1)
+		}
+	}
+	return nil
+}
+
+type fakeTx struct {
+	pgx.Tx
+	pool *fakePool
+}
+
+func (tx *fakeTx) SendBatch(ctx context.Context, batch *pgx.Batch) pgx.BatchResults {
+	for _, query := range batch.QueuedQueries {
+		tx.pool.record(query.SQL, query.Arguments)
+	}
+	return &fakeBatchResults{}
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return tx.pool.Exec(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error   { return nil }
+func (tx *fakeTx) Rollback(ctx context.Context) error { return nil }
+
+type fakeBatchResults struct {
+	pgx.BatchResults
+}
+
+func (r *fakeBatchResults) Exec() (pgconn.CommandTag, error) {
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+func (r *fakeBatchResults) Close() error { return nil }
+
+func testTrack(id string, albumId string) spotify.Track {
+	return spotify.Track{
+		Id:         id,
+		Name:       "Track " + id,
+		Album:      &spotify.Album{Id: albumId, Name: "Album " + albumId},
+		Artists:    []*spotify.Artist{{Id: "artist-1", Name: "Artist 1"}},
+		DurationMS: 200000,
+	}
+}
+
+func TestProcessAllSyncsLibrary(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+	spotify.InitializeLogger(zap.NewNop())
+	tempo.InitializeLogger(zap.NewNop())
+	t.Setenv("TEMPO_PROVIDERS", "spotify")
+	t.Setenv("JOB_QUEUE", "")
+
+	pool := &fakePool{}
+	db.SetPool(pool)
+
+	server := spotifytest.NewServer()
+	defer server.Close()
+	previousClient := spotify.DefaultClient()
+	spotify.SetDefaultClient(server.Client())
+	defer spotify.SetDefaultClient(previousClient)
+
+	addedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	playlist := spotify.Playlist{Id: "playlist-1", Name: "Long run", SnapshotId: "snapshot-1"}
+	playlist.Owner.Id = "alice"
+	server.AddUser("alice-token", &spotifytest.Library{
+		User: spotify.User{Id: "alice", Country: "US"},
+		SavedTracks: []spotify.UsersSavedTrackItem{
+			{AddedAt: addedAt, Track: testTrack("saved-1", "album-1")},
+			{AddedAt: addedAt.Add(-time.Hour), Track: testTrack("saved-2", "album-1")},
+		},
+		SavedAlbums: []spotify.SavedAlbum{
+			{AddedAt: addedAt, Album: spotify.Album{Id: "album-2", Name: "Album 2", AlbumType: "album"}},
+		},
+		TopTracks: []spotify.Track{testTrack("top-1", "album-3")},
+		Playlists: []spotify.Playlist{playlist},
+	})
+	server.SetAlbumTracks("album-2", []spotify.Track{testTrack("album-track-1", "album-2"), testTrack("album-track-2", "album-2")})
+	server.SetPlaylistTracks("playlist-1", []spotify.UsersSavedTrackItem{
+		{AddedAt: addedAt, Track: testTrack("playlist-track-1", "album-4")},
+	})
+	server.SetAudioFeatures(spotify.AudioFeatures{Id: "saved-1", Tempo: 170, TimeSignature: 4})
+
+	processAll("alice-token", "alice")
+	activeSyncsWg.Wait()
+
+	var savedTrackIds []string
+	for _, statement := range pool.matching(`INSERT INTO "track"`) {
+		savedTrackIds = append(savedTrackIds, statement.args[0].(string))
+	}
+	for _, trackId := range []string{"saved-1", "saved-2", "top-1", "album-track-1", "album-track-2", "playlist-track-1"} {
+		if !slices.Contains(savedTrackIds, trackId) {
+			t.Errorf("track %s was not saved, saved tracks: %v", trackId, savedTrackIds)
+		}
+	}
+
+	if got := server.Requests("GET", "/v1/albums/album-2/tracks"); got != 1 {
+		t.Errorf("album tracks were requested %d times, want 1", got)
+	}
+	if got := server.Requests("GET", "/v1/playlists/playlist-1/tracks"); got != 1 {
+		t.Errorf("playlist tracks were requested %d times, want 1", got)
+	}
+
+	// The fake database knows no country for alice, so the album was crawled without a market and
+	// marked synced on its row
+	var albumCrawled bool
+	for _, statement := range pool.matching(`SET last_synced_at = NOW()`) {
+		if strings.Contains(statement.sql, `UPDATE "album"`) && statement.args[0] == "album-2" {
+			albumCrawled = true
+		}
+	}
+	if !albumCrawled {
+		t.Error("album-2 crawl was not marked synced")
+	}
+	if marked := pool.matching(`INSERT INTO "crawl_market"`); len(marked) != 0 {
+		t.Errorf("crawls without a market were marked synced for a market: %v", marked)
+	}
+
+	// The saved tracks are newest first, so the high water mark is the first one's added_at
+	marks := pool.matching(`INSERT INTO "user_sync_state"`)
+	if len(marks) == 0 {
+		t.Error("no sync high water mark was saved")
+	}
+	for _, mark := range marks {
+		if mark.args[1] == syncSourceSavedTracks && !mark.args[2].(time.Time).Equal(addedAt) {
+			t.Errorf("saved tracks high water mark = %v, want %v", mark.args[2], addedAt)
+		}
+	}
+
+	updates := pool.matching(`UPDATE "sync_run"`)
+	if len(updates) == 0 {
+		t.Fatal("sync run was never persisted")
+	}
+	if status := updates[len(updates)-1].args[1]; status != syncStatusCompleted {
+		t.Errorf("final sync run status = %v, want %s", status, syncStatusCompleted)
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+// --- Ranking ---
+//
+// Matched tracks are scored between 0 and 1 as a weighted sum of how close their tempo is to the
+// target, how strongly the user is tied to where they were found, their energy, danceability and
+// popularity, and whether the user liked them. Features a track has no value for count as
+// average, so tracks whose tempo came from a provider other than Spotify aren't pushed down.
+
+const (
+	// defaultMatchLimit and maxMatchLimit bound how many ranked tracks the matching endpoint returns
+	defaultMatchLimit = 1000
+	maxMatchLimit     = 1000
+
+	// unknownFeatureScore is the score of a feature a track has no value for
+	unknownFeatureScore = 0.5
+)
+
+type rankingWeights struct {
+	tempo        float64
+	source       float64
+	energy       float64
+	danceability float64
+	popularity   float64
+	feedback     float64
+}
+
+var defaultRankingWeights = rankingWeights{
+	tempo:        0.35,
+	source:       0.2,
+	energy:       0.15,
+	danceability: 0.1,
+	popularity:   0.1,
+	feedback:     0.1,
+}
+
+// sourceStrengths rate how much a track found in a source says about the user's taste. Tracks the
+// user picked themselves beat tracks crawled from the catalog of artists they like.
+var sourceStrengths = map[string]float64{
+	"saved_tracks":                1,
+	"top_tracks":                  1,
+	"playlists":                   0.8,
+	"saved_albums":                0.7,
+	"top_artists_top_tracks":      0.6,
+	"followed_artists_top_tracks": 0.5,
+	"top_artists_singles":         0.4,
+	"top_artists_albums":          0.4,
+	"followed_artists_singles":    0.3,
+	"followed_artists_albums":     0.3,
+}
+
+// rankedSources are the sources whose tracks have a rank
+var rankedSources = map[string]bool{
+	"top_tracks":                  true,
+	"top_artists_top_tracks":      true,
+	"followed_artists_top_tracks": true,
+}
+
+// rankedSourceDecay is how much of its source strength a track at the bottom of a ranked source
+// (rank rankedSourceDepth or lower) loses compared to the top one
+const (
+	rankedSourceDecay = 0.5
+	rankedSourceDepth = 50
+)
+
+// RankedTrack is a matched track with its ranking score
+type RankedTrack struct {
+	Id    string  `json:"id"`
+	BPM   float64 `json:"bpm"`
+	Ratio float64 `json:"ratio"`
+	Score float64 `json:"score"`
+	// DurationMS is 0 if the track's length isn't known
+	DurationMS int `json:"duration_ms"`
+	// Sources are the sources the track was found in
+	Sources []string `json:"sources"`
+}
+
+// rankTracks scores matches for a target range of minBPM to maxBPM and returns them best first
+func rankTracks(ctx context.Context, userId string, minBPM float64, maxBPM float64, matches map[string]*TrackMatch) ([]*RankedTrack, error) {
+	trackIds := make([]string, 0, len(matches))
+	for trackId := range matches {
+		trackIds = append(trackIds, trackId)
+	}
+
+	features, err := db.GetTrackRankingFeatures(ctx, userId, trackIds)
+	if err != nil {
+		return nil, fmt.Errorf("getting ranking features: %w", err)
+	}
+
+	ranked := make([]*RankedTrack, 0, len(matches))
+	for trackId, match := range matches {
+		track := &RankedTrack{
+			Id:      trackId,
+			BPM:     match.BPM,
+			Ratio:   match.Ratio,
+			Score:   scoreTrack(defaultRankingWeights, minBPM, maxBPM, match, features[trackId]),
+			Sources: match.Sources,
+		}
+		if trackFeatures, ok := features[trackId]; ok {
+			track.DurationMS = trackFeatures.DurationMS
+		}
+		ranked = append(ranked, track)
+	}
+
+	sortRankedTracks(ranked)
+
+	logger.Debug("Ranked matched tracks",
+		zap.String("userId", userId),
+		zap.Int("count", len(ranked)))
+	return ranked, nil
+}
+
+// sortRankedTracks sorts tracks best first. Ties are broken by id so the same library always ranks
+// the same way.
+func sortRankedTracks(tracks []*RankedTrack) {
+	slices.SortFunc(tracks, func(a, b *RankedTrack) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+}
+
+func scoreTrack(weights rankingWeights, minBPM float64, maxBPM float64, match *TrackMatch, features *db.TrackRankingFeatures) float64 {
+	popularity, energy, danceability, feedback := unknownFeatureScore, unknownFeatureScore, unknownFeatureScore, 0.0
+	if features != nil {
+		if features.Popularity > 0 {
+			popularity = float64(features.Popularity) / 100
+		}
+		if features.Energy > 0 {
+			energy = features.Energy
+		}
+		if features.Danceability > 0 {
+			danceability = features.Danceability
+		}
+		if features.Feedback > 0 {
+			feedback = 1
+		}
+	}
+
+	score := weights.tempo*tempoScore(minBPM, maxBPM, match.BPM, match.Ratio) +
+		weights.source*sourceScore(match.Sources, match.Ranks) +
+		weights.energy*energy +
+		weights.danceability*danceability +
+		weights.popularity*popularity +
+		weights.feedback*feedback
+	// Rounded so scores read well in responses and equal scores compare equal
+	return math.Round(score*1000) / 1000
+}
+
+// tempoScore is 1 for a track right on the target tempo, falling to 0 at the edges of the range.
+// Tracks matched at a multiple of the target score less, as they're harder to run to.
+func tempoScore(minBPM float64, maxBPM float64, bpm float64, ratio float64) float64 {
+	target := (minBPM + maxBPM) / 2
+	halfWidth := (maxBPM - minBPM) / 2
+	closeness := 1.0
+	if halfWidth > 0 {
+		closeness = 1 - math.Min(1, math.Abs(bpm/ratio-target)/halfWidth)
+	}
+
+	switch ratio {
+	case 1:
+		return closeness
+	case 0.5, 2:
+		return closeness * 0.85
+	default:
+		return closeness * 0.7
+	}
+}
+
+// sourceScore is the strength of the strongest source a track was found in. Tracks in a ranked
+// source, like the user's top tracks, lose strength the further down they rank in it.
+func sourceScore(sources []string, ranks map[string]int) float64 {
+	strongest := 0.0
+	for _, source := range sources {
+		strength := sourceStrengths[source]
+		if rank := ranks[source]; rankedSources[source] && rank > 0 {
+			depth := float64(min(rank, rankedSourceDepth)-1) / float64(rankedSourceDepth-1)
+			strength *= 1 - rankedSourceDecay*depth
+		}
+		strongest = math.Max(strongest, strength)
+	}
+	return strongest
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+func TestTempoScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		bpm    float64
+		ratio  float64
+		wanted float64
+	}{
+		{name: "on target", bpm: 170, ratio: 1, wanted: 1},
+		{name: "halfway to the edge", bpm: 175, ratio: 1, wanted: 0.5},
+		{name: "at the edge", bpm: 180, ratio: 1, wanted: 0},
+		{name: "half time on target", bpm: 85, ratio: 0.5, wanted: 0.85},
+		{name: "double time on target", bpm: 340, ratio: 2, wanted: 0.85},
+		{name: "half time halfway to the edge", bpm: 87.5, ratio: 0.5, wanted: 0.425},
+		{name: "triplet on target", bpm: 255, ratio: 1.5, wanted: 0.7},
+		{name: "two thirds on target", bpm: 170 * 2.0 / 3.0, ratio: 2.0 / 3.0, wanted: 0.7},
+	}
+	for _, test := range tests {
+		if got := tempoScore(160, 180, test.bpm, test.ratio); math.Abs(got-test.wanted) > 1e-9 {
+			t.Errorf("%s: tempoScore = %g, want %g", test.name, got, test.wanted)
+		}
+	}
+
+	if got := tempoScore(170, 170, 171, 1); got != 1 {
+		t.Errorf("tempoScore for an empty range = %g, want 1", got)
+	}
+}
+
+func TestSourceScoreDecaysByRank(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		ranks   map[string]int
+		wanted  float64
+	}{
+		{name: "unranked source", sources: []string{"playlists"}, wanted: 0.8},
+		{name: "top of a ranked source", sources: []string{"top_tracks"}, ranks: map[string]int{"top_tracks": 1}, wanted: 1},
+		{name: "bottom of a ranked source", sources: []string{"top_tracks"}, ranks: map[string]int{"top_tracks": 50}, wanted: 0.5},
+		{name: "below the bottom of a ranked source", sources: []string{"top_tracks"}, ranks: map[string]int{"top_tracks": 80}, wanted: 0.5},
+		{name: "ranked source without a rank", sources: []string{"top_artists_top_tracks"}, wanted: 0.6},
+		{
+			name:    "each source decays by its own rank",
+			sources: []string{"top_tracks", "top_artists_top_tracks"},
+			ranks:   map[string]int{"top_tracks": 50, "top_artists_top_tracks": 1},
+			wanted:  0.6,
+		},
+		{
+			name:    "strongest source wins",
+			sources: []string{"followed_artists_albums", "saved_tracks"},
+			wanted:  1,
+		},
+		{name: "unknown source", sources: []string{"radio"}, wanted: 0},
+	}
+	for _, test := range tests {
+		if got := sourceScore(test.sources, test.ranks); math.Abs(got-test.wanted) > 1e-9 {
+			t.Errorf("%s: sourceScore = %g, want %g", test.name, got, test.wanted)
+		}
+	}
+}
+
+func TestScoreTrackTreatsUnknownFeaturesAsAverage(t *testing.T) {
+	match := &TrackMatch{BPMMatch: &db.BPMMatch{BPM: 170, Sources: []string{"saved_tracks"}}, Ratio: 1}
+
+	unknown := scoreTrack(defaultRankingWeights, 160, 180, match, nil)
+	average := scoreTrack(defaultRankingWeights, 160, 180, match, &db.TrackRankingFeatures{
+		Popularity:   50,
+		Energy:       0.5,
+		Danceability: 0.5,
+	})
+	if unknown != average {
+		t.Errorf("track without features scored %g, want %g like an average one", unknown, average)
+	}
+
+	liked := scoreTrack(defaultRankingWeights, 160, 180, match, &db.TrackRankingFeatures{Feedback: 1})
+	if want := unknown + defaultRankingWeights.feedback; math.Abs(liked-want) > 1e-9 {
+		t.Errorf("liked track scored %g, want %g", liked, want)
+	}
+}
+
+func TestSortRankedTracksBreaksTiesById(t *testing.T) {
+	tracks := []*RankedTrack{
+		{Id: "c", Score: 0.5},
+		{Id: "a", Score: 0.5},
+		{Id: "d", Score: 0.9},
+		{Id: "b", Score: 0.5},
+	}
+	sortRankedTracks(tracks)
+
+	wanted := []string{"d", "a", "b", "c"}
+	for i, track := range tracks {
+		if track.Id != wanted[i] {
+			t.Fatalf("sorted tracks %v, want %v", rankedIds(tracks), wanted)
+		}
+	}
+}
+
+func rankedIds(tracks []*RankedTrack) []string {
+	ids := make([]string, len(tracks))
+	for i, track := range tracks {
+		ids[i] = track.Id
+	}
+	return ids
+}
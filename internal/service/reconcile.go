@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+// reconcile deletes the relation rows of ownerId that Spotify no longer returned. It must only be
+// called once a stage has fetched and saved its complete listing, so a cancelled run never deletes anything.
+func reconcile(ctx context.Context, relation string, ownerId string, keepIds []string,
+	deleteExcept func(context.Context, string, []string) (int64, error)) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deleted, err := deleteExcept(ctx, ownerId, keepIds)
+	if err != nil {
+		return fmt.Errorf("reconciling %s: %w", relation, err)
+	}
+
+	if deleted > 0 {
+		logger.Info("Removed stale library items",
+			zap.String("relation", relation),
+			zap.String("ownerId", ownerId),
+			zap.Int64("count", deleted))
+	}
+	return nil
+}
+
+func trackIds(tracks []*spotify.Track) []string {
+	ids := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		if track != nil && track.Id != "" {
+			ids = append(ids, track.Id)
+		}
+	}
+	return ids
+}
+
+// reconcileIncremental handles stages that stop paging at the high water mark. Spotify's total is
+// compared with the stored count, and only when they differ is the full id listing fetched to find the removed rows.
+func reconcileIncremental(ctx context.Context, relation string, userId string, total int,
+	count func(context.Context, string) (int, error),
+	listIds func(context.Context) ([]string, error),
+	deleteExcept func(context.Context, string, []string) (int64, error)) error {
+
+	stored, err := count(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("counting %s: %w", relation, err)
+	}
+	if stored == total {
+		return nil
+	}
+
+	logger.Debug("Stored library differs from Spotify, fetching full listing",
+		zap.String("relation", relation),
+		zap.String("userId", userId),
+		zap.Int("stored", stored),
+		zap.Int("total", total))
+
+	ids, err := listIds(ctx)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", relation, err)
+	}
+	return reconcile(ctx, relation, userId, ids, deleteExcept)
+}
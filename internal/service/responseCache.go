@@ -0,0 +1,162 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+const (
+	// defaultResponseCacheMaxAge is how long a response is kept after it was last downloaded. Responses
+	// revalidated with a 304 aren't stored again, so a page that never changes is downloaded again
+	// once per max age.
+	defaultResponseCacheMaxAge = 30 * 24 * time.Hour
+	// responseCachePruneInterval is how often expired responses are deleted
+	responseCachePruneInterval = time.Hour
+	// defaultResponseCacheMemoryBytes bounds the bodies kept in memory in front of Postgres
+	defaultResponseCacheMemoryBytes = 32 << 20
+)
+
+// ConfigureSpotifyClient replaces the default Spotify client with one that caches catalog
+// responses in Postgres, unless SPOTIFY_RESPONSE_CACHE is set to "false"
+func ConfigureSpotifyClient() {
+	if os.Getenv("SPOTIFY_RESPONSE_CACHE") == "false" {
+		return
+	}
+	spotify.SetDefaultClient(spotify.NewClient(spotify.ClientConfig{
+		ResponseCache: newSpotifyResponseCache(
+			getEnvDuration("SPOTIFY_RESPONSE_CACHE_MAX_AGE", defaultResponseCacheMaxAge),
+			getEnvInt("SPOTIFY_RESPONSE_CACHE_MEMORY_BYTES", defaultResponseCacheMemoryBytes)),
+	}))
+}
+
+// spotifyResponseCache stores Spotify responses in the http_cache table. The most recently used
+// responses are also kept in memory, so revalidating them doesn't cost a query first. Responses
+// older than maxAge are deleted every responseCachePruneInterval, from whichever Put comes due.
+type spotifyResponseCache struct {
+	maxAge      time.Duration
+	memoryBytes int
+
+	mu sync.Mutex
+	// recent holds *cachedEntry values, most recently used first, indexed by URL in entries
+	recent    *list.List
+	entries   map[string]*list.Element
+	size      int
+	lastPrune time.Time
+}
+
+type cachedEntry struct {
+	url      string
+	response *spotify.CachedResponse
+}
+
+func newSpotifyResponseCache(maxAge time.Duration, memoryBytes int) *spotifyResponseCache {
+	return &spotifyResponseCache{
+		maxAge:      maxAge,
+		memoryBytes: memoryBytes,
+		recent:      list.New(),
+		entries:     make(map[string]*list.Element),
+		lastPrune:   time.Now(),
+	}
+}
+
+func (c *spotifyResponseCache) Get(ctx context.Context, url string) (*spotify.CachedResponse, error) {
+	if response := c.recall(url); response != nil {
+		return response, nil
+	}
+
+	entry, err := db.GetHTTPCacheEntry(ctx, url)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	response := &spotify.CachedResponse{
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+		Body:         entry.Body,
+	}
+	c.remember(url, response)
+	return response, nil
+}
+
+func (c *spotifyResponseCache) Put(ctx context.Context, url string, response *spotify.CachedResponse) error {
+	err := db.SaveHTTPCacheEntry(ctx, &db.HTTPCacheEntry{
+		URL:          url,
+		ETag:         response.ETag,
+		LastModified: response.LastModified,
+		Body:         response.Body,
+	})
+	if err != nil {
+		return err
+	}
+	c.remember(url, response)
+
+	if c.prunePending() {
+		c.prune(ctx)
+	}
+	return nil
+}
+
+// recall returns the response for url if it is held in memory
+func (c *spotifyResponseCache) recall(url string) *spotify.CachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[url]
+	if !ok {
+		return nil
+	}
+	c.recent.MoveToFront(element)
+	return element.Value.(*cachedEntry).response
+}
+
+// remember holds response in memory, evicting the least recently used responses over the memory budget
+func (c *spotifyResponseCache) remember(url string, response *spotify.CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[url]; ok {
+		c.size -= len(element.Value.(*cachedEntry).response.Body)
+		c.recent.Remove(element)
+		delete(c.entries, url)
+	}
+	if len(response.Body) > c.memoryBytes {
+		return
+	}
+
+	c.entries[url] = c.recent.PushFront(&cachedEntry{url: url, response: response})
+	c.size += len(response.Body)
+	for c.size > c.memoryBytes {
+		oldest := c.recent.Back()
+		entry := oldest.Value.(*cachedEntry)
+		c.size -= len(entry.response.Body)
+		c.recent.Remove(oldest)
+		delete(c.entries, entry.url)
+	}
+}
+
+// prunePending reports whether expired responses are due to be deleted, and if so marks them as
+// being deleted so only one caller prunes
+func (c *spotifyResponseCache) prunePending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastPrune) < responseCachePruneInterval {
+		return false
+	}
+	c.lastPrune = time.Now()
+	return true
+}
+
+func (c *spotifyResponseCache) prune(ctx context.Context) {
+	deleted, err := db.DeleteHTTPCacheEntriesBefore(ctx, time.Now().Add(-c.maxAge))
+	if err != nil {
+		logger.Warn("Error pruning Spotify response cache", zap.Error(err))
+		return
+	}
+	logger.Debug("Pruned Spotify response cache", zap.Int64("deleted", deleted), zap.Duration("maxAge", c.maxAge))
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+func TestSpotifyResponseCacheKeepsRecentResponsesInMemory(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+	pool := &fakePool{}
+	db.SetPool(pool)
+	ctx := context.Background()
+
+	cache := newSpotifyResponseCache(time.Hour, 10)
+	for _, put := range []struct{ url, body string }{{"a", "aaaaaa"}, {"b", "bbbb"}} {
+		if err := cache.Put(ctx, put.url, &spotify.CachedResponse{ETag: `"` + put.url + `"`, Body: []byte(put.body)}); err != nil {
+			t.Fatalf("putting %s: %v", put.url, err)
+		}
+	}
+	if got := len(pool.matching(`INSERT INTO "http_cache"`)); got != 2 {
+		t.Fatalf("stored %d responses in Postgres, want 2", got)
+	}
+
+	// a is used, so b is the least recently used response when c pushes the cache over budget
+	if response, err := cache.Get(ctx, "a"); err != nil || response == nil || string(response.Body) != "aaaaaa" {
+		t.Fatalf("getting a returned %+v, %v", response, err)
+	}
+	if err := cache.Put(ctx, "c", &spotify.CachedResponse{ETag: `"c"`, Body: []byte("cccc")}); err != nil {
+		t.Fatalf("putting c: %v", err)
+	}
+	if got := len(pool.matching(`FROM "http_cache"`)); got != 0 {
+		t.Fatalf("queried Postgres %d times for responses held in memory, want 0", got)
+	}
+
+	if response, _ := cache.Get(ctx, "b"); response != nil {
+		t.Errorf("got %+v for the evicted response, want it looked up in Postgres, which has none", response)
+	}
+	if got := len(pool.matching(`FROM "http_cache"`)); got != 1 {
+		t.Errorf("queried Postgres %d times after evicting b, want 1", got)
+	}
+	if response, _ := cache.Get(ctx, "c"); response == nil || string(response.Body) != "cccc" {
+		t.Errorf("getting c returned %+v, want it from memory", response)
+	}
+}
+
+func TestSpotifyResponseCachePrunesExpiredResponses(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+	pool := &fakePool{}
+	db.SetPool(pool)
+	ctx := context.Background()
+
+	cache := newSpotifyResponseCache(24*time.Hour, 1<<10)
+	put := func() {
+		t.Helper()
+		if err := cache.Put(ctx, "a", &spotify.CachedResponse{ETag: `"a"`, Body: []byte("a")}); err != nil {
+			t.Fatalf("putting a: %v", err)
+		}
+	}
+	pruned := func() []fakeStatement { return pool.matching(`DELETE FROM "http_cache"`) }
+
+	put()
+	if got := len(pruned()); got != 0 {
+		t.Fatalf("pruned %d times within the prune interval, want 0", got)
+	}
+
+	cache.mu.Lock()
+	cache.lastPrune = time.Now().Add(-2 * responseCachePruneInterval)
+	cache.mu.Unlock()
+	put()
+	put()
+
+	statements := pruned()
+	if len(statements) != 1 {
+		t.Fatalf("pruned %d times once the interval passed, want 1", len(statements))
+	}
+	before, ok := statements[0].args[0].(time.Time)
+	if !ok {
+		t.Fatalf("pruned before %v, want a time", statements[0].args[0])
+	}
+	if age := time.Since(before); age < 24*time.Hour || age > 25*time.Hour {
+		t.Errorf("pruned responses older than %v, want the max age of 24h", age)
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"math"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/cover"
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+// saveRunDJPlaylist puts tracks into the user's RunDJ playlist for bpm. The playlist made by an
+// earlier request is updated in place; a new one is only created if there is none or the user
+// deleted it.
+func saveRunDJPlaylist(ctx context.Context, token string, userId string, bpm float64, min float64, max float64, trackIds []string) (*spotify.Playlist, error) {
+	client := spotifyClient()
+	roundedBPM := int(math.Round(bpm))
+
+	playlistId, err := db.GetRunDJPlaylistId(ctx, userId, roundedBPM)
+	if err != nil {
+		// Not knowing the old playlist only costs a duplicate, so don't fail the request over it
+		logger.Warn("Error getting existing RunDJ playlist, creating a new one",
+			zap.String("userId", userId),
+			zap.Int("bpm", roundedBPM),
+			zap.Error(err))
+	}
+
+	if playlistId != "" {
+		follows, err := client.FollowsPlaylist(ctx, token, playlistId, userId)
+		if err != nil {
+			return nil, err
+		}
+		if follows {
+			logger.Debug("Updating existing RunDJ playlist", zap.String("userId", userId), zap.String("playlistId", playlistId))
+			playlist, err := client.UpdatePlaylist(ctx, token, playlistId, bpm, min, max, trackIds)
+			if err != nil {
+				return nil, err
+			}
+			uploadRunDJPlaylistCover(ctx, token, playlist.Id, bpm, min, max)
+			return playlist, nil
+		}
+		logger.Info("RunDJ playlist was deleted by the user, creating a new one",
+			zap.String("userId", userId),
+			zap.String("playlistId", playlistId))
+	}
+
+	playlist, err := client.CreatePlaylist(ctx, token, userId, bpm, min, max, trackIds)
+	if playlist == nil || playlist.Id == "" {
+		return nil, err
+	}
+	// Remember the playlist even if adding its tracks failed, so a retry fills it instead of making another
+	if saveErr := db.SaveRunDJPlaylist(ctx, userId, roundedBPM, playlist.Id); saveErr != nil {
+		logger.Error("Error saving RunDJ playlist",
+			zap.String("userId", userId),
+			zap.String("playlistId", playlist.Id),
+			zap.Error(saveErr))
+	}
+	if err != nil {
+		return playlist, err
+	}
+	uploadRunDJPlaylistCover(ctx, token, playlist.Id, bpm, min, max)
+	return playlist, nil
+}
+
+// uploadRunDJPlaylistCover sets the generated cover of a RunDJ playlist. A missing cover leaves
+// Spotify's default mosaic, so failures are only logged.
+func uploadRunDJPlaylistCover(ctx context.Context, token string, playlistId string, bpm float64, min float64, max float64) {
+	jpeg, err := cover.EncodeJPEG(cover.Options{BPM: bpm, MinBPM: min, MaxBPM: max})
+	if err != nil {
+		logger.Error("Error rendering playlist cover", zap.String("playlistId", playlistId), zap.Error(err))
+		return
+	}
+	if err := spotifyClient().UploadPlaylistCover(ctx, token, playlistId, jpeg); err != nil {
+		logger.Warn("Error uploading playlist cover", zap.String("playlistId", playlistId), zap.Error(err))
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/spotify/spotifytest"
+)
+
+// rundjPlaylistPool is a fakePool that also remembers the RunDJ playlists saved through it
+type rundjPlaylistPool struct {
+	*fakePool
+
+	mu        sync.Mutex
+	playlists map[string]string // playlist id by "userId/bpm"
+}
+
+func (p *rundjPlaylistPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if strings.Contains(sql, `INSERT INTO "rundj_playlist"`) {
+		p.mu.Lock()
+		p.playlists[fmt.Sprintf("%v/%v", args[0], args[1])] = args[2].(string)
+		p.mu.Unlock()
+	}
+	return p.fakePool.Exec(ctx, sql, args...)
+}
+
+func (p *rundjPlaylistPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if strings.Contains(sql, `FROM "rundj_playlist"`) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		rows := &stringRows{}
+		if playlistId, ok := p.playlists[fmt.Sprintf("%v/%v", args[0], args[1])]; ok {
+			rows.values = []string{playlistId}
+		}
+		return rows, nil
+	}
+	return p.fakePool.Query(ctx, sql, args...)
+}
+
+// stringRows are rows of a single string column
+type stringRows struct {
+	pgx.Rows
+	values []string
+	next   int
+}
+
+func (r *stringRows) Next() bool {
+	r.next++
+	return r.next <= len(r.values)
+}
+
+func (r *stringRows) Scan(dest ...any) error {
+	*dest[0].(*string) = r.values[r.next-1]
+	return nil
+}
+
+func (r *stringRows) Err() error { return nil }
+func (r *stringRows) Close()     {}
+
+func trackURIs(ids ...string) []string {
+	uris := make([]string, len(ids))
+	for i, id := range ids {
+		uris[i] = "spotify:track:" + id
+	}
+	return uris
+}
+
+func TestSaveRunDJPlaylistUpdatesInPlace(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+	db.InitializeLogger(zap.NewNop())
+	spotify.InitializeLogger(zap.NewNop())
+	ctx := context.Background()
+
+	pool := &rundjPlaylistPool{fakePool: &fakePool{}, playlists: make(map[string]string)}
+	db.SetPool(pool)
+
+	server := spotifytest.NewServer()
+	defer server.Close()
+	previousClient := spotify.DefaultClient()
+	spotify.SetDefaultClient(server.Client())
+	defer spotify.SetDefaultClient(previousClient)
+	server.AddUser("alice-token", &spotifytest.Library{User: spotify.User{Id: "alice"}})
+
+	// The first request creates the playlist
+	created, err := saveRunDJPlaylist(ctx, "alice-token", "alice", 170, 165, 175, []string{"track-1", "track-2"})
+	if err != nil {
+		t.Fatalf("creating playlist: %v", err)
+	}
+	playlist, ok := server.CreatedPlaylist(created.Id)
+	if !ok {
+		t.Fatalf("playlist %s wasn't created", created.Id)
+	}
+	if !slices.Equal(playlist.TrackURIs, trackURIs("track-1", "track-2")) {
+		t.Errorf("created playlist has tracks %v", playlist.TrackURIs)
+	}
+	if len(playlist.CoverJPEG) == 0 {
+		t.Error("created playlist has no cover")
+	}
+	if pool.playlists["alice/170"] != created.Id {
+		t.Errorf("saved playlist %q for 170 BPM, want %q", pool.playlists["alice/170"], created.Id)
+	}
+
+	// A request at the same rounded BPM regenerates it in place
+	updated, err := saveRunDJPlaylist(ctx, "alice-token", "alice", 170.2, 168, 172, []string{"track-3"})
+	if err != nil {
+		t.Fatalf("regenerating playlist: %v", err)
+	}
+	if updated.Id != created.Id {
+		t.Errorf("regenerated playlist %s, want %s updated in place", updated.Id, created.Id)
+	}
+	playlist, _ = server.CreatedPlaylist(created.Id)
+	if !slices.Equal(playlist.TrackURIs, trackURIs("track-3")) {
+		t.Errorf("regenerated playlist has tracks %v, want its items replaced", playlist.TrackURIs)
+	}
+	if !strings.Contains(playlist.Playlist.Description, "168") || strings.Contains(playlist.Playlist.Description, "165") {
+		t.Errorf("regenerated playlist has description %q, want the new BPM range", playlist.Playlist.Description)
+	}
+	if got := server.Requests("POST", "/v1/users/alice/playlists"); got != 1 {
+		t.Errorf("created %d playlists, want 1", got)
+	}
+
+	// Once the user deletes it, the next request creates a new one
+	server.DeletePlaylist(created.Id)
+	recreated, err := saveRunDJPlaylist(ctx, "alice-token", "alice", 170, 165, 175, []string{"track-4"})
+	if err != nil {
+		t.Fatalf("recreating playlist: %v", err)
+	}
+	if recreated.Id == created.Id {
+		t.Fatalf("recreated playlist has the id %s of the deleted one", recreated.Id)
+	}
+	playlist, ok = server.CreatedPlaylist(recreated.Id)
+	if !ok || !slices.Equal(playlist.TrackURIs, trackURIs("track-4")) {
+		t.Errorf("recreated playlist has tracks %v", playlist.TrackURIs)
+	}
+	if pool.playlists["alice/170"] != recreated.Id {
+		t.Errorf("saved playlist %q for 170 BPM, want the recreated %q", pool.playlists["alice/170"], recreated.Id)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+// --- Scheduled Library Refresh ---
+//
+// The refresh scheduler periodically picks users whose last sync is older than
+// SYNC_REFRESH_STALE_AFTER, mints an access token from their stored refresh token and syncs
+// their library, so libraries stay fresh without the user having to open the app. Every
+// instance runs it; users are claimed in the user_credential table so each is picked by one.
+// Set SYNC_SCHEDULER=off to disable it.
+
+const (
+	defaultRefreshInterval    = 15 * time.Minute
+	defaultRefreshStaleAfter  = 72 * time.Hour
+	defaultRefreshConcurrency = 2
+	defaultRefreshBatchSize   = 20
+	// refreshMaxStartJitter spreads the syncs picked in one tick so they don't hit Spotify at once
+	refreshMaxStartJitter = 30 * time.Second
+)
+
+type refreshScheduler struct {
+	interval   time.Duration
+	staleAfter time.Duration
+	batchSize  int
+	// slots holds one entry per refresh in progress, bounding how many run at once
+	slots chan struct{}
+}
+
+func newRefreshScheduler() *refreshScheduler {
+	return &refreshScheduler{
+		interval:   getEnvDuration("SYNC_REFRESH_INTERVAL", defaultRefreshInterval),
+		staleAfter: getEnvDuration("SYNC_REFRESH_STALE_AFTER", defaultRefreshStaleAfter),
+		batchSize:  getEnvInt("SYNC_REFRESH_BATCH_SIZE", defaultRefreshBatchSize),
+		slots:      make(chan struct{}, getEnvInt("SYNC_REFRESH_CONCURRENCY", defaultRefreshConcurrency)),
+	}
+}
+
+// StartRefreshScheduler refreshes stale user libraries in the background until ctx is done
+func StartRefreshScheduler(ctx context.Context) {
+	if os.Getenv("SYNC_SCHEDULER") == "off" {
+		logger.Info("Refresh scheduler disabled")
+		return
+	}
+	go newRefreshScheduler().run(ctx)
+}
+
+func (s *refreshScheduler) run(ctx context.Context) {
+	logger.Info("Refresh scheduler started",
+		zap.Duration("interval", s.interval),
+		zap.Duration("staleAfter", s.staleAfter),
+		zap.Int("concurrency", cap(s.slots)),
+		zap.Int("batchSize", s.batchSize))
+
+	// Jitter every wait, including the first, so instances started together don't tick together
+	for spotify.SleepContext(ctx, s.interval/2+jitter(s.interval)) == nil {
+		s.tick(ctx)
+	}
+	logger.Info("Refresh scheduler stopped")
+}
+
+// tick claims as many stale users as there are free slots and starts refreshing them. It returns
+// without waiting for the refreshes; those still running from earlier ticks keep their slots.
+func (s *refreshScheduler) tick(ctx context.Context) {
+	free := min(s.batchSize, cap(s.slots)-len(s.slots))
+	if free == 0 {
+		logger.Debug("Refresh scheduler: All refresh slots busy, skipping tick")
+		return
+	}
+
+	// Claims outlast a tick, so a user whose sync couldn't start is retried a tick later
+	users, err := db.ClaimUsersDueForRefresh(ctx, time.Now().Add(-s.staleAfter), free, s.interval)
+	if err != nil {
+		logger.Error("Refresh scheduler: Error claiming users due for refresh", zap.Error(err))
+		return
+	}
+	if len(users) == 0 {
+		logger.Debug("Refresh scheduler: No users due for refresh")
+		return
+	}
+	logger.Info("Refresh scheduler: Refreshing stale users", zap.Int("count", len(users)))
+
+	for _, credential := range users {
+		// Only tick takes slots and it claimed no more users than were free, so this never blocks
+		s.slots <- struct{}{}
+
+		activeSyncsWg.Add(1)
+		go func() {
+			defer activeSyncsWg.Done()
+			defer func() { <-s.slots }()
+			if spotify.SleepContext(ctx, jitter(refreshMaxStartJitter)) != nil {
+				return
+			}
+			s.refreshUser(ctx, credential)
+		}()
+	}
+}
+
+func (s *refreshScheduler) refreshUser(ctx context.Context, credential *db.UserCredential) {
+	userId := credential.UserId
+	if hasActiveSync(userId) {
+		logger.Debug("Refresh scheduler: Sync already in progress, skipping", zap.String("userId", userId))
+		return
+	}
+
+	refreshToken, err := decryptRefreshToken(credential)
+	if err != nil {
+		logger.Error("Refresh scheduler: Error decrypting refresh token", zap.String("userId", userId), zap.Error(err))
+		return
+	}
+
+	token, err := spotifyClient().RefreshAccessToken(ctx, refreshToken)
+	if errors.Is(err, spotify.ErrInvalidGrant) {
+		// The user revoked access; stop trying until they log in again
+		logger.Warn("Refresh scheduler: Refresh token rejected, removing credential", zap.String("userId", userId))
+		if err := db.DeleteUserCredential(ctx, userId); err != nil {
+			logger.Error("Refresh scheduler: Error removing rejected credential", zap.String("userId", userId), zap.Error(err))
+		}
+		return
+	}
+	if err != nil {
+		logger.Error("Refresh scheduler: Error refreshing access token", zap.String("userId", userId), zap.Error(err))
+		return
+	}
+
+	if token.RefreshToken != refreshToken {
+		if err := saveRefreshToken(ctx, userId, token.RefreshToken, token.Scope); err != nil {
+			logger.Error("Refresh scheduler: Error saving rotated refresh token", zap.String("userId", userId), zap.Error(err))
+		}
+	}
+
+	logger.Info("Refresh scheduler: Syncing stale user library", zap.String("userId", userId))
+	syncRunId := syncLibrary(token.Token, userId)
+	logger.Info("Refresh scheduler: Stale user library synced",
+		zap.String("userId", userId),
+		zap.Int64("syncRunId", syncRunId))
+}
+
+// jitter returns a random duration in [0, max)
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
@@ -0,0 +1,488 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+const (
+	syncStatusPending   = "pending"
+	syncStatusRunning   = "running"
+	syncStatusCompleted = "completed"
+	syncStatusFailed    = "failed"
+	syncStatusCancelled = "cancelled"
+)
+
+// Sources tracked with an added_at high water mark for incremental sync
+const (
+	syncSourceSavedTracks = "saved_tracks"
+	syncSourceSavedAlbums = "saved_albums"
+)
+
+const (
+	// maxRecordedErrors caps how many error messages are persisted per stage and per run
+	maxRecordedErrors = 50
+	// syncRunPersistInterval is how often progress of a running sync is written to the DB
+	syncRunPersistInterval = 5 * time.Second
+	// syncRunStaleAfter is how long a running sync can go without being persisted before its
+	// instance is assumed to have died
+	syncRunStaleAfter = 12 * syncRunPersistInterval
+	// syncRunPersistTimeout bounds each write of the run. Writes are detached from the sync's
+	// own context so that a cancelled run can still record its final state.
+	syncRunPersistTimeout = 10 * time.Second
+)
+
+// SyncRun tracks one processAll invocation and persists its progress to the sync_run table
+type SyncRun struct {
+	id        int64
+	userId    string
+	startedAt time.Time
+	stages    []*StageContext
+	// durable runs also count jobs from the job_queue table
+	durable bool
+	// market is the user's country, passed on to catalog jobs so they only store playable tracks
+	market string
+	// cancel stops the run when another instance asks for it through the sync_run table
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	status     string
+	finishedAt time.Time
+}
+
+func newSyncRun(ctx context.Context, userId string, stageNames []string, durable bool) (*SyncRun, error) {
+	run := &SyncRun{
+		userId:    userId,
+		startedAt: time.Now(),
+		durable:   durable,
+		status:    syncStatusRunning,
+	}
+	for _, name := range stageNames {
+		run.stages = append(run.stages, &StageContext{
+			wg:     &sync.WaitGroup{},
+			name:   name,
+			run:    run,
+			status: syncStatusPending,
+		})
+	}
+
+	id, err := db.CreateSyncRun(ctx, userId, syncStatusRunning, run.stageSnapshots(ctx), run.startedAt)
+	if err != nil {
+		return nil, err
+	}
+	run.id = id
+	return run, nil
+}
+
+// stage returns the stage context with the given name
+func (r *SyncRun) stage(name string) *StageContext {
+	for _, stage := range r.stages {
+		if stage.name == name {
+			return stage
+		}
+	}
+	return nil
+}
+
+// finish marks the run as completed or failed depending on the outcome of its stages,
+// or as cancelled if ctx was cancelled before the run could finish
+func (r *SyncRun) finish(ctx context.Context) {
+	status := syncStatusCompleted
+	for _, stage := range r.stages {
+		stage.mu.Lock()
+		if stage.status == syncStatusFailed {
+			status = syncStatusFailed
+		}
+		stage.mu.Unlock()
+	}
+	if ctx.Err() != nil {
+		status = syncStatusCancelled
+	}
+
+	r.mu.Lock()
+	r.status = status
+	r.finishedAt = time.Now()
+	r.mu.Unlock()
+}
+
+// persistPeriodically writes the run snapshot to the DB until stop is closed
+func (r *SyncRun) persistPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(syncRunPersistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.persist()
+		}
+	}
+}
+
+func (r *SyncRun) persist() {
+	ctx, cancel := context.WithTimeout(context.Background(), syncRunPersistTimeout)
+	defer cancel()
+	cancelRequested, err := db.UpdateSyncRun(ctx, r.snapshot(ctx))
+	if err != nil {
+		logger.Error("Error persisting sync run",
+			zap.String("userId", r.userId),
+			zap.Int64("syncRunId", r.id),
+			zap.Error(err))
+		return
+	}
+	if cancelRequested && r.cancel != nil {
+		logger.Info("Cancellation of sync run was requested, cancelling",
+			zap.String("userId", r.userId),
+			zap.Int64("syncRunId", r.id))
+		r.cancel()
+	}
+}
+
+// cancelDurableJobs drops the jobs this run still has queued in the durable job queue
+func (r *SyncRun) cancelDurableJobs() {
+	ctx, cancel := context.WithTimeout(context.Background(), syncRunPersistTimeout)
+	defer cancel()
+	count, err := db.CancelJobs(ctx, r.id)
+	if err != nil {
+		logger.Error("Error cancelling durable jobs of sync run",
+			zap.String("userId", r.userId),
+			zap.Int64("syncRunId", r.id),
+			zap.Error(err))
+		return
+	}
+	logger.Info("Cancelled queued durable jobs of sync run",
+		zap.String("userId", r.userId),
+		zap.Int64("syncRunId", r.id),
+		zap.Int64("count", count))
+}
+
+func (r *SyncRun) stageSnapshots(ctx context.Context) []*db.SyncStage {
+	stages := make([]*db.SyncStage, len(r.stages))
+	for i, stage := range r.stages {
+		stages[i] = stage.snapshot()
+	}
+	if r.durable && r.id != 0 {
+		r.addDurableJobCounts(ctx, stages)
+	}
+	return stages
+}
+
+// addDurableJobCounts adds the jobs this run has in the durable queue to the stage snapshots
+func (r *SyncRun) addDurableJobCounts(ctx context.Context, stages []*db.SyncStage) {
+	counts, err := db.GetJobCounts(ctx, r.id)
+	if err != nil {
+		logger.Warn("Error getting durable job counts for sync run",
+			zap.Int64("syncRunId", r.id),
+			zap.Error(err))
+		return
+	}
+	for _, stage := range stages {
+		c, ok := counts[stage.Name]
+		if !ok {
+			continue
+		}
+		stage.JobsSubmitted += c.Total
+		stage.JobsCompleted += c.Done
+		stage.JobsFailed += c.Failed
+		for _, msg := range c.Errors {
+			if len(stage.Errors) < maxRecordedErrors {
+				stage.Errors = append(stage.Errors, msg)
+			}
+		}
+	}
+}
+
+func (r *SyncRun) snapshot(ctx context.Context) *db.SyncRun {
+	stages := r.stageSnapshots(ctx)
+
+	r.mu.Lock()
+	run := &db.SyncRun{
+		SyncRunId: r.id,
+		UserId:    r.userId,
+		Status:    r.status,
+		Stages:    stages,
+		StartedAt: r.startedAt,
+		Errors:    []string{},
+	}
+	if !r.finishedAt.IsZero() {
+		finishedAt := r.finishedAt
+		run.FinishedAt = &finishedAt
+		run.DurationMS = r.finishedAt.Sub(r.startedAt).Milliseconds()
+	} else {
+		run.DurationMS = time.Since(r.startedAt).Milliseconds()
+	}
+	r.mu.Unlock()
+
+	var progress float64
+	for _, stage := range stages {
+		run.JobsSubmitted += stage.JobsSubmitted
+		run.JobsCompleted += stage.JobsCompleted
+		run.JobsFailed += stage.JobsFailed
+		run.ErrorCount += int(stage.JobsFailed)
+		if stage.Status == syncStatusFailed {
+			run.ErrorCount++
+		}
+		for _, msg := range stage.Errors {
+			if len(run.Errors) < maxRecordedErrors {
+				run.Errors = append(run.Errors, stage.Name+": "+msg)
+			}
+		}
+		progress += stageProgress(stage)
+	}
+	if len(stages) > 0 {
+		progress = progress / float64(len(stages)) * 100
+	}
+	run.Progress = math.Round(progress*10) / 10
+
+	return run
+}
+
+// stageProgress returns the completed fraction (0-1) of a stage.
+// A running stage is measured by how many of its submitted jobs have finished.
+func stageProgress(stage *db.SyncStage) float64 {
+	switch stage.Status {
+	case syncStatusCompleted, syncStatusFailed, syncStatusCancelled:
+		return 1
+	case syncStatusRunning:
+		if stage.JobsSubmitted == 0 {
+			return 0
+		}
+		return float64(stage.JobsCompleted+stage.JobsFailed) / float64(stage.JobsSubmitted)
+	default:
+		return 0
+	}
+}
+
+func (s *StageContext) start() {
+	s.mu.Lock()
+	s.status = syncStatusRunning
+	s.startedAt = time.Now()
+	s.mu.Unlock()
+}
+
+// finish marks the stage as done. err is the error returned by the stage's own fetch, if any.
+// A stage interrupted by ctx being cancelled is marked cancelled rather than failed.
+func (s *StageContext) finish(ctx context.Context, err error) {
+	cancelled := ctx.Err() != nil
+
+	s.mu.Lock()
+	s.finishedAt = time.Now()
+	switch {
+	case cancelled:
+		s.status = syncStatusCancelled
+	case err != nil:
+		s.status = syncStatusFailed
+	default:
+		s.status = syncStatusCompleted
+	}
+	s.mu.Unlock()
+
+	if err != nil && !cancelled {
+		s.recordError(err)
+	}
+}
+
+// onJobsDone registers fn to run once every job submitted by the stage has finished. It is
+// skipped if any of them failed, so state like a high water mark never moves past lost items.
+func (s *StageContext) onJobsDone(fn func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.afterJobs = append(s.afterJobs, fn)
+}
+
+// runAfterJobs runs the funcs registered with onJobsDone. Callers must have waited for the
+// stage's jobs.
+func (s *StageContext) runAfterJobs(ctx context.Context) error {
+	s.mu.Lock()
+	afterJobs := s.afterJobs
+	s.mu.Unlock()
+	if len(afterJobs) == 0 {
+		return nil
+	}
+
+	failed := s.failed.Load()
+	if s.run.durable {
+		counts, err := db.GetJobCounts(ctx, s.run.id)
+		if err != nil {
+			return fmt.Errorf("getting durable job counts of stage %s: %w", s.name, err)
+		}
+		if c, ok := counts[s.name]; ok {
+			failed += c.Failed
+		}
+	}
+	if failed > 0 {
+		logger.Warn("Jobs of stage failed, skipping its completion steps",
+			zap.String("userId", s.run.userId),
+			zap.String("stage", s.name),
+			zap.Int64("failedJobs", failed))
+		return nil
+	}
+
+	for _, fn := range afterJobs {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StageContext) recordJobResult(err error) {
+	if err != nil {
+		s.failed.Add(1)
+		s.recordError(err)
+		return
+	}
+	s.completed.Add(1)
+}
+
+func (s *StageContext) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errors) < maxRecordedErrors {
+		s.errors = append(s.errors, err.Error())
+	}
+}
+
+func (s *StageContext) snapshot() *db.SyncStage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stage := &db.SyncStage{
+		Name:          s.name,
+		Status:        s.status,
+		JobsSubmitted: s.submitted.Load(),
+		JobsCompleted: s.completed.Load(),
+		JobsFailed:    s.failed.Load(),
+		Errors:        append([]string(nil), s.errors...),
+	}
+	if !s.startedAt.IsZero() {
+		startedAt := s.startedAt
+		stage.StartedAt = &startedAt
+		end := time.Now()
+		if !s.finishedAt.IsZero() {
+			finishedAt := s.finishedAt
+			stage.FinishedAt = &finishedAt
+			end = finishedAt
+		}
+		stage.DurationMS = end.Sub(startedAt).Milliseconds()
+	}
+	return stage
+}
+
+// getHighWaterMark returns the stored high water mark for an incremental source.
+// On error it falls back to the zero time, which makes the stage do a full crawl.
+func getHighWaterMark(ctx context.Context, userId string, source string) time.Time {
+	highWaterMark, err := db.GetSyncHighWaterMark(ctx, userId, source)
+	if err != nil {
+		logger.Warn("Error getting sync high water mark, falling back to full sync",
+			zap.String("userId", userId),
+			zap.String("source", source),
+			zap.Error(err))
+		return time.Time{}
+	}
+	return highWaterMark
+}
+
+// advanceHighWaterMark stores newest as the high water mark if it moves the mark forward
+func advanceHighWaterMark(ctx context.Context, userId string, source string, previous time.Time, newest time.Time) error {
+	if !newest.After(previous) {
+		return nil
+	}
+	if err := db.SaveSyncHighWaterMark(ctx, userId, source, newest); err != nil {
+		return fmt.Errorf("saving %s high water mark: %w", source, err)
+	}
+	logger.Debug("Advanced sync high water mark",
+		zap.String("userId", userId),
+		zap.String("source", source),
+		zap.Time("highWaterMark", newest))
+	return nil
+}
+
+// activeSyncs holds the cancel funcs of in-flight syncs, keyed by user id and then sync run id
+var (
+	activeSyncsMu sync.Mutex
+	activeSyncs   = make(map[string]map[int64]context.CancelFunc)
+	activeSyncsWg sync.WaitGroup
+)
+
+func registerSync(userId string, syncRunId int64, cancel context.CancelFunc) {
+	activeSyncsMu.Lock()
+	defer activeSyncsMu.Unlock()
+	if activeSyncs[userId] == nil {
+		activeSyncs[userId] = make(map[int64]context.CancelFunc)
+	}
+	activeSyncs[userId][syncRunId] = cancel
+}
+
+func unregisterSync(userId string, syncRunId int64) {
+	activeSyncsMu.Lock()
+	defer activeSyncsMu.Unlock()
+	delete(activeSyncs[userId], syncRunId)
+	if len(activeSyncs[userId]) == 0 {
+		delete(activeSyncs, userId)
+	}
+}
+
+// hasActiveSync reports whether this instance is currently syncing the user's library
+func hasActiveSync(userId string) bool {
+	activeSyncsMu.Lock()
+	defer activeSyncsMu.Unlock()
+	return len(activeSyncs[userId]) > 0
+}
+
+// CancelSync cancels the in-flight syncs of a user and returns the ids of the cancelled sync runs.
+// Syncs run by this instance stop right away; those run by other instances are flagged in the
+// sync_run table and stop once their instance next persists them.
+func CancelSync(ctx context.Context, userId string) ([]int64, error) {
+	activeSyncsMu.Lock()
+	var cancelled []int64
+	for syncRunId, cancel := range activeSyncs[userId] {
+		cancel()
+		cancelled = append(cancelled, syncRunId)
+	}
+	activeSyncsMu.Unlock()
+
+	requested, err := db.RequestSyncCancel(ctx, userId, syncRunStaleAfter)
+	if err != nil {
+		return cancelled, fmt.Errorf("requesting cancellation of sync runs: %w", err)
+	}
+	for _, syncRunId := range requested {
+		if !slices.Contains(cancelled, syncRunId) {
+			cancelled = append(cancelled, syncRunId)
+		}
+	}
+	return cancelled, nil
+}
+
+// StopSyncs cancels every in-flight sync and waits until they have recorded their final state,
+// or until ctx is done
+func StopSyncs(ctx context.Context) error {
+	activeSyncsMu.Lock()
+	for _, runs := range activeSyncs {
+		for _, cancel := range runs {
+			cancel()
+		}
+	}
+	activeSyncsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		activeSyncsWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for in-flight syncs to stop: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/tempo"
+)
+
+// defaultTempoProviders is the order tempo providers are asked in. Override with TEMPO_PROVIDERS,
+// a comma separated list of "cache", "spotify", "http" and "audio". The http provider is only used
+// when TEMPO_SERVICE_URL is set. The audio provider downloads and analyzes previews, which is slow
+// and CPU heavy, so it has to be listed in TEMPO_PROVIDERS to be used.
+const defaultTempoProviders = "cache,spotify,http"
+
+var (
+	tempoChain     *tempo.Chain
+	tempoChainOnce sync.Once
+)
+
+func getTempoChain() *tempo.Chain {
+	tempoChainOnce.Do(func() {
+		names := os.Getenv("TEMPO_PROVIDERS")
+		if names == "" {
+			names = defaultTempoProviders
+		}
+
+		var providers []tempo.Provider
+		for _, name := range strings.Split(names, ",") {
+			switch name = strings.TrimSpace(name); name {
+			case "cache":
+				providers = append(providers, tempo.NewCacheProvider())
+			case "spotify":
+				providers = append(providers, tempo.NewSpotifyProvider(spotifyClient()))
+			case "http":
+				if serviceURL := os.Getenv("TEMPO_SERVICE_URL"); serviceURL != "" {
+					providers = append(providers, tempo.NewHTTPProvider(serviceURL, nil))
+				}
+			case "audio":
+				providers = append(providers, tempo.NewAudioProvider(nil, 0))
+			case "":
+			default:
+				logger.Warn("Ignoring unknown tempo provider", zap.String("provider", name))
+			}
+		}
+
+		logger.Info("Configured tempo providers", zap.String("providers", names), zap.Int("count", len(providers)))
+		tempoChain = tempo.NewChain(providers...)
+	})
+	return tempoChain
+}
+
+// applyTempos fills in the tempo of tracks from the provider chain. Tracks no provider knows are saved with a
+// tempo of 0, which SaveTracks never writes over a tempo already stored.
+func applyTempos(ctx context.Context, tracks []*db.Track) {
+	if len(tracks) == 0 {
+		return
+	}
+
+	lookup := make([]tempo.Track, len(tracks))
+	for i, track := range tracks {
+		lookup[i] = tempo.Track{
+			Id:         track.TrackId,
+			Name:       track.Name,
+			Artists:    track.ArtistNames,
+			ISRC:       track.ISRC,
+			DurationMS: track.DurationMS,
+			PreviewURL: track.PreviewURL,
+		}
+	}
+
+	estimates := getTempoChain().Lookup(ctx, lookup)
+	for _, track := range tracks {
+		estimate, ok := estimates[track.TrackId]
+		if !ok {
+			continue
+		}
+		track.AudioFeatures = estimate.AudioFeatures()
+		track.BPM = estimate.BPM
+		track.TimeSignature = track.AudioFeatures.TimeSignature
+	}
+}
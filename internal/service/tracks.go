@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -10,7 +11,7 @@ import (
 	"github.com/rcong315/RunDJServer/internal/spotify"
 )
 
-func createTrackBatcher(parentType string, parentId string, tracker *ProcessedTracker, saveRelation func(string, []*db.Track) error) *spotify.BatchProcessor[*spotify.Track] {
+func createTrackBatcher(ctx context.Context, parentType string, parentId string, tracker *ProcessedTracker, saveRelation func(context.Context, string, []*db.Track) error) *spotify.BatchProcessor[*spotify.Track] {
 	return spotify.NewBatchProcessor(100, func(tracks []*spotify.Track) error {
 		dbTracks := convertSpotifyTracksToDBTracks(tracks)
 		var tracksToSave []*db.Track
@@ -21,14 +22,15 @@ func createTrackBatcher(parentType string, parentId string, tracker *ProcessedTr
 		}
 
 		if len(tracksToSave) > 0 {
-			if err := db.SaveTracks(tracksToSave); err != nil {
+			applyTempos(ctx, tracksToSave)
+			if err := db.SaveTracks(ctx, tracksToSave); err != nil {
 				return fmt.Errorf("saving tracks batch: %w", err)
 			}
 			logger.Debug("Saved batch of tracks to DB",
 				zap.String(parentType, parentId))
 		}
 
-		if err := saveRelation(parentId, dbTracks); err != nil {
+		if err := saveRelation(ctx, parentId, dbTracks); err != nil {
 			return fmt.Errorf("saving track relations: %w", err)
 		}
 
@@ -38,8 +40,8 @@ func createTrackBatcher(parentType string, parentId string, tracker *ProcessedTr
 	})
 }
 
-func createRankedTrackBatcher(parentType string, parentId string, tracker *ProcessedTracker,
-	saveRelation func(string, []*db.RankedTrack) error, rankCounter *int) *spotify.BatchProcessor[*spotify.Track] {
+func createRankedTrackBatcher(ctx context.Context, parentType string, parentId string, tracker *ProcessedTracker,
+	saveRelation func(context.Context, string, []*db.RankedTrack) error, rankCounter *int) *spotify.BatchProcessor[*spotify.Track] {
 
 	return spotify.NewBatchProcessor(100, func(tracks []*spotify.Track) error {
 		dbTracks := convertSpotifyTracksToDBTracks(tracks)
@@ -58,14 +60,15 @@ func createRankedTrackBatcher(parentType string, parentId string, tracker *Proce
 		}
 
 		if len(tracksToSave) > 0 {
-			if err := db.SaveTracks(tracksToSave); err != nil {
+			applyTempos(ctx, tracksToSave)
+			if err := db.SaveTracks(ctx, tracksToSave); err != nil {
 				return fmt.Errorf("saving tracks batch: %w", err)
 			}
 			logger.Debug("Saved batch of tracks to DB",
 				zap.String(parentType, parentId))
 		}
 
-		if err := saveRelation(parentId, rankedTracks); err != nil {
+		if err := saveRelation(ctx, parentId, rankedTracks); err != nil {
 			return fmt.Errorf("saving track relations: %w", err)
 		}
 
@@ -77,7 +80,7 @@ func createRankedTrackBatcher(parentType string, parentId string, tracker *Proce
 	})
 }
 
-func processTopTracks(userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
+func processTopTracks(ctx context.Context, userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
 	logger.Debug("Processing user top tracks",
 		zap.String("userId", userId))
 
@@ -85,13 +88,15 @@ func processTopTracks(userId string, token string, pool *WorkerPool, tracker *Pr
 	rankCounter := 0
 
 	// Create a wrapper function that converts RankedTrack to the expected format
-	saveRankedTracks := func(userId string, rankedTracks []*db.RankedTrack) error {
-		return db.SaveUserTopTracks(userId, rankedTracks)
+	saveRankedTracks := func(ctx context.Context, userId string, rankedTracks []*db.RankedTrack) error {
+		return db.SaveUserTopTracks(ctx, userId, rankedTracks)
 	}
 
-	trackBatcher := createRankedTrackBatcher("user", userId, tracker, saveRankedTracks, &rankCounter)
+	trackBatcher := createRankedTrackBatcher(ctx, "user", userId, tracker, saveRankedTracks, &rankCounter)
 
-	err := spotify.GetUsersTopTracks(token, func(tracks []*spotify.Track) error {
+	var seenIds []string
+	err := spotifyClient().GetUsersTopTracks(ctx, token, func(tracks []*spotify.Track) error {
+		seenIds = append(seenIds, trackIds(tracks)...)
 		for _, track := range tracks {
 			if err := trackBatcher.Add(track); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
@@ -107,19 +112,26 @@ func processTopTracks(userId string, token string, pool *WorkerPool, tracker *Pr
 		return fmt.Errorf("flushing remaining tracks: %w", err)
 	}
 
+	if err := reconcile(ctx, "user top tracks", userId, seenIds, db.DeleteUserTopTracksExcept); err != nil {
+		return err
+	}
+
 	logger.Debug("Processed user top tracks",
 		zap.String("userId", userId),
 		zap.Int("totalRanked", rankCounter))
 	return nil
 }
 
-func processSavedTracks(userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
+func processSavedTracks(ctx context.Context, userId string, token string, pool *WorkerPool, tracker *ProcessedTracker, jobWg *sync.WaitGroup, stage *StageContext) error {
 	logger.Debug("Processing user saved tracks",
 		zap.String("userId", userId))
 
-	trackBatcher := createTrackBatcher("user", userId, tracker, db.SaveUserSavedTracks)
+	trackBatcher := createTrackBatcher(ctx, "user", userId, tracker, db.SaveUserSavedTracks)
 
-	err := spotify.GetUsersSavedTracks(token, func(tracks []*spotify.Track) error {
+	since := getHighWaterMark(ctx, userId, syncSourceSavedTracks)
+	var seenIds []string
+	newest, total, err := spotifyClient().GetUsersSavedTracks(ctx, token, since, func(tracks []*spotify.Track) error {
+		seenIds = append(seenIds, trackIds(tracks)...)
 		for _, track := range tracks {
 			if err := trackBatcher.Add(track); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
@@ -135,7 +147,25 @@ func processSavedTracks(userId string, token string, pool *WorkerPool, tracker *
 		return fmt.Errorf("flushing remaining tracks: %w", err)
 	}
 
+	// Only advance once everything up to newest, including the jobs it queued, has been saved
+	stage.onJobsDone(func(ctx context.Context) error {
+		return advanceHighWaterMark(ctx, userId, syncSourceSavedTracks, since, newest)
+	})
+
+	if since.IsZero() {
+		err = reconcile(ctx, "user saved tracks", userId, seenIds, db.DeleteUserSavedTracksExcept)
+	} else {
+		err = reconcileIncremental(ctx, "user saved tracks", userId, total,
+			db.CountUserSavedTracks, func(ctx context.Context) ([]string, error) {
+				return spotifyClient().GetUsersSavedTrackIds(ctx, token)
+			}, db.DeleteUserSavedTracksExcept)
+	}
+	if err != nil {
+		return err
+	}
+
 	logger.Debug("Processed user saved tracks",
-		zap.String("userId", userId))
+		zap.String("userId", userId),
+		zap.Time("since", since))
 	return nil
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 
 	"go.uber.org/zap"
@@ -9,14 +10,14 @@ import (
 	"github.com/rcong315/RunDJServer/internal/spotify"
 )
 
-func saveUser(user *spotify.User) (bool, error) {
+func saveUser(ctx context.Context, user *spotify.User) (bool, error) {
 	if user == nil {
 		return false, fmt.Errorf("cannot save nil user")
 	}
 	logger.Debug("Attempting to save user to DB", zap.String("spotifyUserId", user.Id), zap.String("displayName", user.DisplayName))
 
 	dbUser := convertSpotifyUserToDBUser(user)
-	isNewUser, err := db.SaveUser(dbUser)
+	isNewUser, err := db.SaveUser(ctx, dbUser)
 	if err != nil {
 		return false, fmt.Errorf("error saving user %s to DB: %w", user.Id, err)
 	}
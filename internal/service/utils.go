@@ -26,6 +26,11 @@ var presetPlaylists = map[int]string{
 	190: "37i9dQZF1EIcID9rq1OAoH",
 }
 
+// spotifyClient returns the client used to talk to Spotify
+func spotifyClient() *spotify.Client {
+	return spotify.DefaultClient()
+}
+
 func convertSpotifyUserToDBUser(user *spotify.User) *db.User {
 	imageURLs := make([]string, len(user.ImageURLs))
 	for i, img := range user.ImageURLs {
@@ -51,8 +56,10 @@ func convertSpotifyTracksToDBTracks(tracks []*spotify.Track) []*db.Track {
 		}
 
 		artistIds := make([]string, len(track.Artists))
+		artistNames := make([]string, len(track.Artists))
 		for i, artist := range track.Artists {
 			artistIds[i] = artist.Id
+			artistNames[i] = artist.Name
 		}
 
 		var albumId string
@@ -92,8 +99,16 @@ func convertSpotifyTracksToDBTracks(tracks []*spotify.Track) []*db.Track {
 			Popularity:       track.Popularity,
 			DurationMS:       track.DurationMS,
 			AvailableMarkets: track.AvailableMarkets,
+			PreviewURL:       track.PreviewURL,
 			AudioFeatures:    dbAudioFeatures,
 			TimeSignature:    dbAudioFeatures.TimeSignature,
+			ArtistNames:      artistNames,
+			ISRC:             track.ExternalIds.ISRC,
+			Market:           track.Market,
+			IsPlayable:       track.IsPlayable,
+		}
+		if track.LinkedFrom != nil {
+			dbTrack.LinkedFromId = track.LinkedFrom.Id
 		}
 		dbTracks = append(dbTracks, dbTrack)
 	}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+// --- Workouts ---
+//
+// A workout is a list of segments, each run at one cadence for a while, like a warm-up at 155
+// followed by intervals at 180 with recoveries at 160. Each segment gets the best ranked tracks
+// matching its cadence whose lengths add up as close to its duration as possible, and no track is
+// used twice. When a segment runs long or short the next one is planned around the difference, so
+// segment changes stay close to where they belong on the clock.
+
+const (
+	maxWorkoutSegments        = 100
+	maxWorkoutSegmentSeconds  = 2 * 60 * 60
+	maxWorkoutDurationSeconds = 6 * 60 * 60
+	minWorkoutBPM             = 40
+	maxWorkoutBPM             = 250
+
+	// workoutBPMTolerance is how far a track's tempo may be from a segment's, like the single BPM endpoints
+	workoutBPMTolerance = 1.5
+
+	// Spotify cuts playlist descriptions off at 300 characters
+	maxPlaylistDescriptionLength = 300
+	// maxPlaylistNameLength keeps names to what Spotify's apps show in full
+	maxPlaylistNameLength = 100
+)
+
+// WorkoutSegment is part of a workout run at one cadence
+type WorkoutSegment struct {
+	Label           string  `json:"label,omitempty"`
+	BPM             float64 `json:"bpm"`
+	DurationSeconds int     `json:"duration_seconds"`
+}
+
+// WorkoutRequest is the body of a request for a workout playlist
+type WorkoutRequest struct {
+	Segments []WorkoutSegment `json:"segments"`
+	// Sources are the sources tracks are taken from, all of them if empty
+	Sources   []string `json:"sources"`
+	Multiples string   `json:"multiples"`
+	// CreatePlaylist saves the workout as a playlist in the user's Spotify account
+	CreatePlaylist bool   `json:"create_playlist"`
+	Name           string `json:"name,omitempty"`
+}
+
+// WorkoutSegmentPlan is the tracks picked for a segment
+type WorkoutSegmentPlan struct {
+	WorkoutSegment
+	// DurationMS is the combined length of Tracks
+	DurationMS int            `json:"duration_ms"`
+	Tracks     []*RankedTrack `json:"tracks"`
+}
+
+// WorkoutPlan is the tracks picked for a whole workout, in order
+type WorkoutPlan struct {
+	Segments         []*WorkoutSegmentPlan `json:"segments"`
+	TargetDurationMS int                   `json:"target_duration_ms"`
+	DurationMS       int                   `json:"duration_ms"`
+	Playlist         *spotify.Playlist     `json:"playlist,omitempty"`
+}
+
+// TrackIds returns the ids of the tracks of the plan in the order they play
+func (p *WorkoutPlan) TrackIds() []string {
+	var ids []string
+	for _, segment := range p.Segments {
+		for _, track := range segment.Tracks {
+			ids = append(ids, track.Id)
+		}
+	}
+	return ids
+}
+
+// validate checks the request and fills in its defaults
+func (r *WorkoutRequest) validate() error {
+	if len(r.Segments) == 0 {
+		return errors.New("a workout needs at least one segment")
+	}
+	if len(r.Segments) > maxWorkoutSegments {
+		return fmt.Errorf("a workout has at most %d segments", maxWorkoutSegments)
+	}
+
+	total := 0
+	for i, segment := range r.Segments {
+		if segment.BPM < minWorkoutBPM || segment.BPM > maxWorkoutBPM {
+			return fmt.Errorf("segment %d: bpm must be between %d and %d", i+1, minWorkoutBPM, maxWorkoutBPM)
+		}
+		if segment.DurationSeconds <= 0 || segment.DurationSeconds > maxWorkoutSegmentSeconds {
+			return fmt.Errorf("segment %d: duration_seconds must be between 1 and %d", i+1, maxWorkoutSegmentSeconds)
+		}
+		total += segment.DurationSeconds
+	}
+	if total > maxWorkoutDurationSeconds {
+		return fmt.Errorf("a workout lasts at most %d seconds", maxWorkoutDurationSeconds)
+	}
+	if utf8.RuneCountInString(r.Name) > maxPlaylistNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxPlaylistNameLength)
+	}
+
+	if len(r.Sources) == 0 {
+		r.Sources = slices.Sorted(maps.Keys(sourceStrengths))
+	}
+	return nil
+}
+
+// buildWorkoutPlan picks the tracks of each segment of a validated workout request
+func buildWorkoutPlan(ctx context.Context, userId string, request *WorkoutRequest) (*WorkoutPlan, error) {
+	ratios, err := parseTempoMultiples(request.Multiples)
+	if err != nil {
+		return nil, err
+	}
+
+	return planWorkout(request.Segments, func(bpm float64) ([]*RankedTrack, error) {
+		minBPM := bpm - workoutBPMTolerance
+		maxBPM := bpm + workoutBPMTolerance
+		matches, err := matchTracksByBPM(ctx, userId, minBPM, maxBPM, ratios, request.Sources)
+		if err != nil {
+			return nil, fmt.Errorf("matching tracks at %g BPM: %w", bpm, err)
+		}
+		candidates, err := rankTracks(ctx, userId, minBPM, maxBPM, matches)
+		if err != nil {
+			return nil, fmt.Errorf("ranking tracks at %g BPM: %w", bpm, err)
+		}
+		return candidates, nil
+	})
+}
+
+// planWorkout picks the tracks of each segment from the ranked candidates for its cadence
+func planWorkout(segments []WorkoutSegment, candidatesFor func(bpm float64) ([]*RankedTrack, error)) (*WorkoutPlan, error) {
+	// Intervals come back to the same cadences, so each is only matched and ranked once
+	candidatesByBPM := make(map[float64][]*RankedTrack)
+	used := make(map[string]bool)
+	plan := &WorkoutPlan{}
+
+	for _, segment := range segments {
+		candidates, ok := candidatesByBPM[segment.BPM]
+		if !ok {
+			var err error
+			candidates, err = candidatesFor(segment.BPM)
+			if err != nil {
+				return nil, err
+			}
+			candidatesByBPM[segment.BPM] = candidates
+		}
+
+		plan.TargetDurationMS += segment.DurationSeconds * 1000
+		// Plan to the segment's end on the workout clock rather than its own length
+		targetMS := plan.TargetDurationMS - plan.DurationMS
+		tracks, durationMS := fitDuration(candidates, targetMS, durationTolerance(targetMS), 0, used)
+
+		plan.Segments = append(plan.Segments, &WorkoutSegmentPlan{
+			WorkoutSegment: segment,
+			DurationMS:     durationMS,
+			Tracks:         tracks,
+		})
+		plan.DurationMS += durationMS
+
+		logger.Debug("Planned workout segment",
+			zap.Float64("bpm", segment.BPM),
+			zap.Int("targetMS", targetMS),
+			zap.Int("durationMS", durationMS),
+			zap.Int("trackCount", len(tracks)))
+	}
+
+	return plan, nil
+}
+
+// createWorkoutPlaylist saves plan as a new playlist in the user's Spotify account
+func createWorkoutPlaylist(ctx context.Context, token string, userId string, request *WorkoutRequest, plan *WorkoutPlan) (*spotify.Playlist, error) {
+	minBPM, maxBPM := math.Inf(1), math.Inf(-1)
+	for _, segment := range request.Segments {
+		minBPM = math.Min(minBPM, segment.BPM)
+		maxBPM = math.Max(maxBPM, segment.BPM)
+	}
+
+	name := request.Name
+	if name == "" {
+		name = fmt.Sprintf("RunDJ Workout %s-%s BPM", formatWorkoutBPM(minBPM), formatWorkoutBPM(maxBPM))
+	}
+
+	playlist, err := spotifyClient().CreateNamedPlaylist(ctx, token, userId, name, workoutDescription(request.Segments), plan.TrackIds())
+	if playlist == nil || playlist.Id == "" {
+		return nil, err
+	}
+	if err != nil {
+		return playlist, err
+	}
+	// The cover shows the fastest cadence of the workout over its range
+	uploadRunDJPlaylistCover(ctx, token, playlist.Id, maxBPM, minBPM, maxBPM)
+	return playlist, nil
+}
+
+// workoutDescription lists the segments of a workout, e.g. "Created by RunDJ: warm-up 10 min at
+// 155 BPM, 3 min at 180 BPM, ...", cut off to fit a playlist description
+func workoutDescription(segments []WorkoutSegment) string {
+	parts := make([]string, len(segments))
+	for i, segment := range segments {
+		part := fmt.Sprintf("%s at %s BPM", formatWorkoutDuration(segment.DurationSeconds), formatWorkoutBPM(segment.BPM))
+		if segment.Label != "" {
+			part = segment.Label + " " + part
+		}
+		parts[i] = part
+	}
+
+	description := "Created by RunDJ: " + strings.Join(parts, ", ")
+	if len(description) > maxPlaylistDescriptionLength {
+		description = strings.ToValidUTF8(description[:maxPlaylistDescriptionLength-3], "") + "..."
+	}
+	return description
+}
+
+func formatWorkoutDuration(seconds int) string {
+	if seconds%60 == 0 {
+		return fmt.Sprintf("%d min", seconds/60)
+	}
+	if seconds < 60 {
+		return fmt.Sprintf("%d s", seconds)
+	}
+	return fmt.Sprintf("%d:%02d min", seconds/60, seconds%60)
+}
+
+func formatWorkoutBPM(bpm float64) string {
+	return fmt.Sprintf("%g", math.Round(bpm*10)/10)
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// testCandidates returns ranked tracks with the given lengths in seconds, best first
+func testCandidates(prefix string, seconds ...int) []*RankedTrack {
+	tracks := make([]*RankedTrack, len(seconds))
+	for i, s := range seconds {
+		tracks[i] = &RankedTrack{
+			Id:         prefix + string(rune('a'+i)),
+			Score:      1 - float64(i)/100,
+			DurationMS: s * 1000,
+		}
+	}
+	return tracks
+}
+
+func planIds(plan *WorkoutPlan) [][]string {
+	ids := make([][]string, len(plan.Segments))
+	for i, segment := range plan.Segments {
+		ids[i] = rankedIds(segment.Tracks)
+	}
+	return ids
+}
+
+func TestPlanWorkoutPlansAgainstTheClock(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+
+	candidates := map[float64][]*RankedTrack{
+		// Nothing adds up to 5 minutes, so the warm-up runs a minute long
+		155: testCandidates("warm-", 180, 180),
+		// On its own the interval would take the 5 minute track, but a minute is already gone
+		180: testCandidates("fast-", 300, 240),
+	}
+	plan, err := planWorkout([]WorkoutSegment{
+		{Label: "warm-up", BPM: 155, DurationSeconds: 300},
+		{BPM: 180, DurationSeconds: 300},
+	}, func(bpm float64) ([]*RankedTrack, error) {
+		return candidates[bpm], nil
+	})
+	if err != nil {
+		t.Fatalf("planning workout: %v", err)
+	}
+
+	wanted := [][]string{{"warm-a", "warm-b"}, {"fast-b"}}
+	if got := planIds(plan); !slices.EqualFunc(got, wanted, slices.Equal) {
+		t.Errorf("planned %v, want %v", got, wanted)
+	}
+	if plan.Segments[0].DurationMS != 360*1000 || plan.Segments[1].DurationMS != 240*1000 {
+		t.Errorf("segments last %d and %d ms, want 360000 and 240000", plan.Segments[0].DurationMS, plan.Segments[1].DurationMS)
+	}
+	if plan.TargetDurationMS != 600*1000 || plan.DurationMS != 600*1000 {
+		t.Errorf("plan lasts %d ms of %d, want 600000 of 600000", plan.DurationMS, plan.TargetDurationMS)
+	}
+}
+
+func TestPlanWorkoutReusesCandidatesOfRepeatedCadences(t *testing.T) {
+	InitializeLogger(zap.NewNop())
+
+	calls := make(map[float64]int)
+	candidates := map[float64][]*RankedTrack{
+		170: testCandidates("easy-", 60, 60, 60, 60),
+		180: testCandidates("fast-", 60, 60),
+	}
+	plan, err := planWorkout([]WorkoutSegment{
+		{BPM: 170, DurationSeconds: 120},
+		{BPM: 180, DurationSeconds: 120},
+		{BPM: 170, DurationSeconds: 120},
+	}, func(bpm float64) ([]*RankedTrack, error) {
+		calls[bpm]++
+		return candidates[bpm], nil
+	})
+	if err != nil {
+		t.Fatalf("planning workout: %v", err)
+	}
+
+	if calls[170] != 1 || calls[180] != 1 {
+		t.Errorf("fetched candidates %v times per cadence, want once each", calls)
+	}
+	// The second easy segment gets the tracks the first one didn't use
+	wanted := [][]string{{"easy-a", "easy-b"}, {"fast-a", "fast-b"}, {"easy-c", "easy-d"}}
+	if got := planIds(plan); !slices.EqualFunc(got, wanted, slices.Equal) {
+		t.Errorf("planned %v, want %v", got, wanted)
+	}
+}
+
+func TestWorkoutDescription(t *testing.T) {
+	short := workoutDescription([]WorkoutSegment{
+		{Label: "warm-up", BPM: 155, DurationSeconds: 600},
+		{BPM: 180.25, DurationSeconds: 90},
+		{BPM: 160, DurationSeconds: 45},
+	})
+	if want := "Created by RunDJ: warm-up 10 min at 155 BPM, 1:30 min at 180.3 BPM, 45 s at 160 BPM"; short != want {
+		t.Errorf("description = %q, want %q", short, want)
+	}
+
+	segments := make([]WorkoutSegment, 40)
+	for i := range segments {
+		segments[i] = WorkoutSegment{Label: "intervallé", BPM: 180, DurationSeconds: 60}
+	}
+	long := workoutDescription(segments)
+	if len(long) > maxPlaylistDescriptionLength {
+		t.Errorf("description is %d bytes, want at most %d", len(long), maxPlaylistDescriptionLength)
+	}
+	if !strings.HasSuffix(long, "...") {
+		t.Errorf("cut off description %q doesn't end in ...", long)
+	}
+	if !utf8.ValidString(long) {
+		t.Errorf("cut off description %q isn't valid UTF-8", long)
+	}
+}
+
+func TestWorkoutRequestValidate(t *testing.T) {
+	valid := func() WorkoutRequest {
+		return WorkoutRequest{Segments: []WorkoutSegment{{BPM: 170, DurationSeconds: 600}}}
+	}
+	tests := []struct {
+		name    string
+		change  func(*WorkoutRequest)
+		wantErr bool
+	}{
+		{name: "valid", change: func(r *WorkoutRequest) {}},
+		{name: "no segments", change: func(r *WorkoutRequest) { r.Segments = nil }, wantErr: true},
+		{name: "too slow", change: func(r *WorkoutRequest) { r.Segments[0].BPM = 30 }, wantErr: true},
+		{name: "too fast", change: func(r *WorkoutRequest) { r.Segments[0].BPM = 300 }, wantErr: true},
+		{name: "no duration", change: func(r *WorkoutRequest) { r.Segments[0].DurationSeconds = 0 }, wantErr: true},
+		{
+			name: "too long in total",
+			change: func(r *WorkoutRequest) {
+				for range 3 {
+					r.Segments = append(r.Segments, WorkoutSegment{BPM: 170, DurationSeconds: maxWorkoutSegmentSeconds})
+				}
+			},
+			wantErr: true,
+		},
+		{name: "longest name", change: func(r *WorkoutRequest) { r.Name = strings.Repeat("é", maxPlaylistNameLength) }},
+		{name: "name too long", change: func(r *WorkoutRequest) { r.Name = strings.Repeat("a", maxPlaylistNameLength+1) }, wantErr: true},
+	}
+	for _, test := range tests {
+		request := valid()
+		test.change(&request)
+		err := request.validate()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: validate returned %v, want error %t", test.name, err, test.wantErr)
+		}
+	}
+
+	request := valid()
+	if err := request.validate(); err != nil {
+		t.Fatalf("validating: %v", err)
+	}
+	if len(request.Sources) != len(sourceStrengths) {
+		t.Errorf("request without sources defaulted to %v, want every source", request.Sources)
+	}
+}
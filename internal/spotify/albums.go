@@ -1,7 +1,9 @@
 package spotify
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -20,56 +22,112 @@ type Album struct {
 }
 
 type SavedAlbum struct {
-	Album Album `json:"album"`
+	AddedAt time.Time `json:"added_at"`
+	Album   Album     `json:"album"`
 }
 
 type UsersSavedAlbumsResponse struct {
 	Items []SavedAlbum `json:"items"`
 	Next  string       `json:"next"`
+	Total int          `json:"total"`
 }
 
 type AlbumsTracksResponse struct {
 	Items []Track `json:"items"`
 	Next  string  `json:"next"`
+	Total int     `json:"total"`
 }
 
-func GetUsersSavedAlbums(token string, processor func([]*Album) error) error {
-	logger.Debug("Attempting to get user's saved albums")
-	url := fmt.Sprintf("%s/me/albums?limit=%d&offset=%d", spotifyAPIURL, limitMax, 0)
-
-	err := fetchAllResultsStreaming(token, url, func(response *UsersSavedAlbumsResponse) error {
-		albums := make([]*Album, len(response.Items))
+// GetUsersSavedAlbums streams the user's saved albums, newest first. Paging stops at the first
+// album added before since; albums added in the same second as since are fetched again, like in
+// GetUsersSavedTracks. It returns the newest added_at seen and the total number of saved albums.
+func (c *Client) GetUsersSavedAlbums(ctx context.Context, token string, since time.Time, processor func([]*Album) error) (time.Time, int, error) {
+	logger.Debug("Attempting to get user's saved albums", zap.Time("since", since))
+	url := fmt.Sprintf("%s/me/albums?limit=%d&offset=%d", c.apiURL, limitMax, 0)
+
+	var newest time.Time
+	newCount := 0
+	total := 0
+	seen := make(map[string]bool)
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersSavedAlbumsResponse) error {
+		total = response.Total
+		albums := make([]*Album, 0, len(response.Items))
+		reachedSynced := false
 		for i := range response.Items {
-			albums[i] = &response.Items[i].Album
+			item := &response.Items[i]
+			if !since.IsZero() && item.AddedAt.Before(since) {
+				reachedSynced = true
+				break
+			}
+			if item.Album.Id != "" {
+				if seen[item.Album.Id] {
+					continue
+				}
+				seen[item.Album.Id] = true
+			}
+			if item.AddedAt.After(newest) {
+				newest = item.AddedAt
+			}
+			albums = append(albums, &item.Album)
 		}
+		newCount += len(albums)
 
-		if err := processor(albums); err != nil {
-			return fmt.Errorf("processing saved albums batch: %w", err)
+		if len(albums) > 0 {
+			if err := processor(albums); err != nil {
+				return fmt.Errorf("processing saved albums batch: %w", err)
+			}
 		}
 
+		if reachedSynced {
+			logger.Debug("Reached previously synced saved albums, stopping", zap.Int("newCount", newCount))
+			return errStopPaging
+		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("fetching saved albums: %w", err)
+		return time.Time{}, 0, fmt.Errorf("fetching saved albums: %w", err)
 	}
 
-	logger.Debug("Retrieved user's saved albums")
-	return nil
+	logger.Debug("Retrieved user's saved albums", zap.Int("newCount", newCount), zap.Int("total", total))
+	return newest, total, nil
+}
+
+// GetUsersSavedAlbumIds returns the ids of all the user's saved albums
+func (c *Client) GetUsersSavedAlbumIds(ctx context.Context, token string) ([]string, error) {
+	logger.Debug("Attempting to get user's saved album ids")
+	url := fmt.Sprintf("%s/me/albums?limit=%d&offset=%d", c.apiURL, limitMax, 0)
+
+	var ids []string
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersSavedAlbumsResponse) error {
+		for _, item := range response.Items {
+			if item.Album.Id != "" {
+				ids = append(ids, item.Album.Id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching saved album ids: %w", err)
+	}
+
+	logger.Debug("Retrieved user's saved album ids", zap.Int("count", len(ids)))
+	return ids, nil
 }
 
-func GetAlbumsTracks(albumId string, processor func([]*Track) error) error {
+func (c *Client) GetAlbumsTracks(ctx context.Context, albumId string, market string, processor func([]*Track) error) error {
 	logger.Debug("Attempting to get tracks for album", zap.String("albumId", albumId))
-	url := fmt.Sprintf("%s/albums/%s/tracks?limit=%d&offset=%d", spotifyAPIURL, albumId, limitMax, 0)
-	token, err := getSecretToken()
+	url := fmt.Sprintf("%s/albums/%s/tracks?limit=%d&offset=%d", c.apiURL, albumId, limitMax, 0) + marketParam("&", market)
+	token, err := c.catalogToken(ctx)
 	if err != nil {
 		return fmt.Errorf("getting secret token: %w", err)
 	}
 
-	audioFeaturesBatcher := createAudioFeaturesBatcher(processor)
+	trackBatcher := NewBatchProcessor(trackBatchSize, processor)
 
-	err = fetchAllResultsStreaming(token, url, func(response *AlbumsTracksResponse) error {
+	err = fetchAllResultsStreaming(ctx, c, token, url, func(response *AlbumsTracksResponse) error {
 		for i := range response.Items {
-			if err := audioFeaturesBatcher.Add(&response.Items[i]); err != nil {
+			response.Items[i].Market = market
+			if err := trackBatcher.Add(&response.Items[i]); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
 			}
 		}
@@ -79,8 +137,8 @@ func GetAlbumsTracks(albumId string, processor func([]*Track) error) error {
 		return fmt.Errorf("fetching tracks for album %s: %w", albumId, err)
 	}
 
-	if err := audioFeaturesBatcher.Flush(); err != nil {
-		return fmt.Errorf("flushing audio features for album %s: %w", albumId, err)
+	if err := trackBatcher.Flush(); err != nil {
+		return fmt.Errorf("flushing remaining tracks for album %s: %w", albumId, err)
 	}
 
 	return nil
@@ -1,6 +1,7 @@
 package spotify
 
 import (
+	"context"
 	"fmt"
 
 	"go.uber.org/zap"
@@ -20,6 +21,7 @@ type Artist struct {
 type UsersTopArtistsResponse struct {
 	Items []Artist `json:"items"`
 	Next  string   `json:"next"`
+	Total int      `json:"total"`
 }
 
 type UsersFollowedArtistsResponse struct {
@@ -36,13 +38,14 @@ type ArtistsTopTracksResponse struct {
 type ArtistsAlbumsResponse struct {
 	Items []Album `json:"items"`
 	Next  string  `json:"next"`
+	Total int     `json:"total"`
 }
 
-func GetUsersTopArtists(token string, processor func([]*Artist) error) error {
+func (c *Client) GetUsersTopArtists(ctx context.Context, token string, processor func([]*Artist) error) error {
 	logger.Debug("Attempting to get user's top artists")
-	url := fmt.Sprintf("%s/me/top/artists/?limit=%d&offset=%d", spotifyAPIURL, limitMax, 0)
+	url := fmt.Sprintf("%s/me/top/artists/?limit=%d&offset=%d", c.apiURL, limitMax, 0)
 
-	err := fetchAllResultsStreaming(token, url, func(response *UsersTopArtistsResponse) error {
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersTopArtistsResponse) error {
 		artists := make([]*Artist, len(response.Items))
 		for i := range response.Items {
 			artists[i] = &response.Items[i]
@@ -58,11 +61,11 @@ func GetUsersTopArtists(token string, processor func([]*Artist) error) error {
 	return nil
 }
 
-func GetUsersFollowedArtists(token string, processor func([]*Artist) error) error {
+func (c *Client) GetUsersFollowedArtists(ctx context.Context, token string, processor func([]*Artist) error) error {
 	logger.Debug("Attempting to get user's followed artists")
-	url := fmt.Sprintf("%s/me/following?type=artist&limit=%d&offset=%d", spotifyAPIURL, limitMax, 0)
+	url := fmt.Sprintf("%s/me/following?type=artist&limit=%d&offset=%d", c.apiURL, limitMax, 0)
 
-	err := fetchAllResultsStreaming(token, url, func(response *UsersFollowedArtistsResponse) error {
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersFollowedArtistsResponse) error {
 		artists := make([]*Artist, len(response.Artists.Items))
 		for i := range response.Artists.Items {
 			artists[i] = &response.Artists.Items[i]
@@ -78,23 +81,23 @@ func GetUsersFollowedArtists(token string, processor func([]*Artist) error) erro
 	return nil
 }
 
-func GetArtistsAlbumsAndSingles(artistId string, processor func([]*Album) error) error {
-	if err := getArtistsAlbums(artistId, "album,single", processor); err != nil {
+func (c *Client) GetArtistsAlbumsAndSingles(ctx context.Context, artistId string, market string, processor func([]*Album) error) error {
+	if err := c.getArtistsAlbums(ctx, artistId, "album,single", market, processor); err != nil {
 		return fmt.Errorf("getting albums and singles for artist %s: %w", artistId, err)
 	}
 	return nil
 }
 
-func getArtistsAlbums(artistId string, include_groups string, processor func([]*Album) error) error {
+func (c *Client) getArtistsAlbums(ctx context.Context, artistId string, include_groups string, market string, processor func([]*Album) error) error {
 	logger.Debug("Getting artist albums", zap.String("artistId", artistId), zap.String("include_groups", include_groups))
-	token, err := getSecretToken()
+	token, err := c.catalogToken(ctx)
 	if err != nil {
 		return fmt.Errorf("getting secret token: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/artists/%s/albums?include_groups=%s&limit=%d&offset=%d", spotifyAPIURL, artistId, include_groups, limitMax, 0)
+	url := fmt.Sprintf("%s/artists/%s/albums?include_groups=%s&limit=%d&offset=%d", c.apiURL, artistId, include_groups, limitMax, 0) + marketParam("&", market)
 
-	err = fetchAllResultsStreaming(token, url, func(response *ArtistsAlbumsResponse) error {
+	err = fetchAllResultsStreaming(ctx, c, token, url, func(response *ArtistsAlbumsResponse) error {
 		albums := make([]*Album, len(response.Items))
 		for i := range response.Items {
 			albums[i] = &response.Items[i]
@@ -113,23 +116,24 @@ func getArtistsAlbums(artistId string, include_groups string, processor func([]*
 	return nil
 }
 
-func GetArtistsTopTracks(artistId string, processor func([]*Track) error) error {
+func (c *Client) GetArtistsTopTracks(ctx context.Context, artistId string, market string, processor func([]*Track) error) error {
 	logger.Debug("Attempting to get top tracks for artist",
 		zap.String("artistId", artistId))
-	token, err := getSecretToken()
+	token, err := c.catalogToken(ctx)
 	if err != nil {
 		return fmt.Errorf("getting secret token: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/artists/%s/top-tracks", spotifyAPIURL, artistId)
+	url := fmt.Sprintf("%s/artists/%s/top-tracks", c.apiURL, artistId) + marketParam("?", market)
 	logger.Debug("Fetching artist top tracks from URL",
 		zap.String("artistId", artistId),
 		zap.String("url", url))
 
-	err = fetchAllResultsStreaming(token, url, func(response *ArtistsTopTracksResponse) error {
+	err = fetchAllResultsStreaming(ctx, c, token, url, func(response *ArtistsTopTracksResponse) error {
 		tracks := make([]*Track, len(response.Tracks))
 		for i := range response.Tracks {
 			tracks[i] = &response.Tracks[i]
+			tracks[i].Market = market
 		}
 		logger.Debug("Processing batch of artist top tracks",
 			zap.String("artistId", artistId))
@@ -2,8 +2,10 @@ package spotify
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,8 +17,7 @@ import (
 )
 
 const (
-	spotifyAuthURL  = "https://accounts.spotify.com/authorize"
-	spotifyTokenURL = "https://accounts.spotify.com/api/token"
+	spotifyAuthURL = "https://accounts.spotify.com/authorize"
 )
 
 type TokenRequest struct {
@@ -39,6 +40,28 @@ type TokenResponse struct {
 	Scope        string `json:"scope"`
 }
 
+// ErrInvalidGrant is returned when Spotify rejects a refresh token, e.g. because the user revoked access
+var ErrInvalidGrant = errors.New("invalid_grant")
+
+// TokenObserver is called in the background with every token issued by TokenHandler, and with tokens
+// whose refresh token RefreshHandler rotated
+type TokenObserver func(ctx context.Context, token *TokenResponse)
+
+var tokenObserver TokenObserver
+
+// SetTokenObserver registers the observer notified of issued tokens
+func SetTokenObserver(observer TokenObserver) {
+	tokenObserver = observer
+}
+
+func notifyTokenObserver(token *TokenResponse) {
+	if tokenObserver == nil {
+		return
+	}
+	// The observer must not hold up or be cancelled with the auth response
+	go tokenObserver(context.Background(), token)
+}
+
 func TokenHandler(c *gin.Context) {
 	clientIP := c.ClientIP()
 	logger.Debug("TokenHandler: Processing request", zap.String("clientIP", clientIP))
@@ -68,7 +91,7 @@ func TokenHandler(c *gin.Context) {
 	data.Set("grant_type", "authorization_code")
 
 	// Make request to Spotify token API
-	tokenResponse, err := makeTokenRequest(config.ClientId, config.ClientSecret, data)
+	tokenResponse, err := DefaultClient().makeTokenRequest(c.Request.Context(), config.ClientId, config.ClientSecret, data)
 	if err != nil {
 		logger.Error("TokenHandler: Token exchange error", zap.Error(err), zap.String("clientIP", clientIP))
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get token"})
@@ -76,6 +99,7 @@ func TokenHandler(c *gin.Context) {
 	}
 
 	logger.Debug("TokenHandler: Token exchange successful", zap.String("clientIP", clientIP))
+	notifyTokenObserver(tokenResponse)
 	c.JSON(http.StatusOK, tokenResponse)
 }
 
@@ -153,52 +177,71 @@ func RefreshHandler(c *gin.Context) {
 	data.Set("grant_type", "refresh_token")
 
 	// Make request to Spotify token API
-	tokenResponse, err := makeTokenRequest(config.ClientId, config.ClientSecret, data)
+	tokenResponse, err := DefaultClient().makeTokenRequest(c.Request.Context(), config.ClientId, config.ClientSecret, data)
 	if err != nil {
 		logger.Error("RefreshHandler: Token refresh error", zap.Error(err), zap.String("clientIP", clientIP))
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to refresh token: %v", err)})
 		return
 	}
 
-	// If the response doesn't include a refresh token, add the one we used
+	// If the response doesn't include a refresh token, add the one we used. The observer is only
+	// told about rotated refresh tokens; the one we used is stored already.
 	if tokenResponse.RefreshToken == "" {
 		tokenResponse.RefreshToken = refreshToken
+	} else if tokenResponse.RefreshToken != refreshToken {
+		notifyTokenObserver(tokenResponse)
 	}
 
 	c.JSON(http.StatusOK, tokenResponse)
 }
 
-// makeTokenRequest sends a request to the Spotify token API
-func makeTokenRequest(clientId string, clientSecret string, data url.Values) (*TokenResponse, error) {
-	// Create authorization header
-	authHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte(clientId+":"+clientSecret))
-
-	// Create request
-	req, err := http.NewRequest("POST", spotifyTokenURL, bytes.NewBufferString(data.Encode()))
+// RefreshAccessToken mints a new access token from a stored refresh token.
+// The returned RefreshToken is the one to keep: Spotify may rotate it.
+func (c *Client) RefreshAccessToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	config, err := GetConfig()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("getting config: %w", err)
 	}
 
-	// Set headers
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Content-Length", fmt.Sprintf("%d", len(data.Encode())))
-	req.Header.Add("Authorization", authHeader)
+	data := url.Values{}
+	data.Set("refresh_token", refreshToken)
+	data.Set("grant_type", "refresh_token")
 
-	resp, err := httpClient.Do(req)
+	tokenResponse, err := c.makeTokenRequest(ctx, config.ClientId, config.ClientSecret, data)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("refreshing access token: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if tokenResponse.RefreshToken == "" {
+		tokenResponse.RefreshToken = refreshToken
 	}
+	return tokenResponse, nil
+}
 
-	// Check for error status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify API returned %d: %s", resp.StatusCode, string(body))
+// makeTokenRequest sends a request to the Spotify token API
+func (c *Client) makeTokenRequest(ctx context.Context, clientId string, clientSecret string, data url.Values) (*TokenResponse, error) {
+	// Create authorization header
+	authHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte(clientId+":"+clientSecret))
+
+	// Refreshing a token is safe to repeat, but an authorization code can only be exchanged once
+	body, err := c.execute(ctx, apiRequest{
+		method:      "POST",
+		url:         c.tokenURL,
+		body:        []byte(data.Encode()),
+		contentType: "application/x-www-form-urlencoded",
+		header:      http.Header{"Authorization": {authHeader}},
+		idempotent:  data.Get("grant_type") == "refresh_token",
+	})
+	if err != nil {
+		var apiErr *APIError
+		var errorBody struct {
+			Error string `json:"error"`
+		}
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest &&
+			json.Unmarshal([]byte(apiErr.Body), &errorBody) == nil && errorBody.Error == ErrInvalidGrant.Error() {
+			return nil, fmt.Errorf("spotify API returned %d: %w", apiErr.StatusCode, ErrInvalidGrant)
+		}
+		return nil, err
 	}
 
 	// Parse token response
@@ -0,0 +1,151 @@
+package spotify
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// --- Circuit Breaking ---
+//
+// Every endpoint of a Client has its own circuit breaker. After breakerFailureThreshold
+// consecutive server errors or network failures the breaker opens and requests to the endpoint
+// fail fast with ErrCircuitOpen instead of piling up retries during a Spotify outage. Once
+// breakerCooldown has passed a single probe request is let through: if it succeeds the breaker
+// closes again, otherwise it stays open for another cooldown.
+
+const (
+	breakerFailureThreshold = 5
+	breakerCooldown         = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without sending a request when the endpoint's circuit breaker is open
+var ErrCircuitOpen = errors.New("spotify circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type circuitBreaker struct {
+	endpoint string
+	clock    Clock
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// breakerFor returns the circuit breaker of endpoint, creating it on first use
+func (c *Client) breakerFor(endpoint string) *circuitBreaker {
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+	breaker, ok := c.breakers[endpoint]
+	if !ok {
+		breaker = &circuitBreaker{endpoint: endpoint, clock: c.clock}
+		c.breakers[endpoint] = breaker
+	}
+	return breaker
+}
+
+// allow reports whether a request may be sent. In the half-open state only one probe is allowed
+// at a time.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < breakerCooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of an allowed request
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= breakerFailureThreshold {
+		b.openedAt = b.clock.Now()
+		if b.state != breakerOpen {
+			b.setState(breakerOpen)
+		}
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	logger.Warn("Spotify circuit breaker changed state",
+		zap.String("endpoint", b.endpoint),
+		zap.Stringer("from", b.state),
+		zap.Stringer("to", state),
+		zap.Int("failures", b.failures))
+	b.state = state
+}
+
+// idSegmentAfter lists the path segments that are followed by an id, e.g. /albums/{id}/tracks
+var idSegmentAfter = map[string]bool{
+	"users":     true,
+	"playlists": true,
+	"albums":    true,
+	"artists":   true,
+}
+
+// endpointKey names the endpoint of a request for its circuit breaker and outcome reports, with
+// ids replaced by placeholders, e.g. "GET /albums/{id}/tracks"
+func endpointKey(method string, rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return method + " " + rawURL
+	}
+
+	segments := strings.Split(strings.TrimPrefix(parsed.Path, "/v1"), "/")
+	for i := 1; i < len(segments); i++ {
+		if idSegmentAfter[segments[i-1]] && segments[i] != "" {
+			segments[i] = "{id}"
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
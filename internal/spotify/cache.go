@@ -0,0 +1,63 @@
+package spotify
+
+import (
+	"context"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// --- Response Caching ---
+//
+// Catalog pages such as an artist's albums or an album's tracks rarely change. When a Client has a
+// ResponseCache, the body of each catalog GET is stored with the ETag and Last-Modified it was
+// served with. The next request for the URL is sent with If-None-Match and If-Modified-Since, and
+// a 304 reuses the stored body instead of downloading the page again.
+
+// CachedResponse is a stored response body with its validators
+type CachedResponse struct {
+	ETag         string
+	LastModified string
+	Body         []byte
+}
+
+// ResponseCache stores responses by URL. Get returns nil for URLs it has nothing for.
+type ResponseCache interface {
+	Get(ctx context.Context, url string) (*CachedResponse, error)
+	Put(ctx context.Context, url string, response *CachedResponse) error
+}
+
+// cachedResponse returns the stored response for url, if any. Cache errors only cost the
+// conditional request, so they are logged rather than failing the request.
+func (c *Client) cachedResponse(ctx context.Context, url string) *CachedResponse {
+	if c.cache == nil {
+		return nil
+	}
+	cached, err := c.cache.Get(ctx, url)
+	if err != nil {
+		logger.Warn("Error reading Spotify response cache", zap.String("url", url), zap.Error(err))
+		return nil
+	}
+	if cached == nil || (cached.ETag == "" && cached.LastModified == "") {
+		return nil
+	}
+	return cached
+}
+
+// storeResponse caches body if the response came with a validator to revalidate it with later
+func (c *Client) storeResponse(ctx context.Context, url string, header http.Header, body []byte) {
+	if c.cache == nil {
+		return
+	}
+	response := &CachedResponse{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Body:         body,
+	}
+	if response.ETag == "" && response.LastModified == "" {
+		return
+	}
+	if err := c.cache.Put(ctx, url, response); err != nil {
+		logger.Warn("Error writing Spotify response cache", zap.String("url", url), zap.Error(err))
+	}
+}
@@ -0,0 +1,99 @@
+package spotify_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+// memoryCache is a spotify.ResponseCache in a map
+type memoryCache struct {
+	mu        sync.Mutex
+	responses map[string]*spotify.CachedResponse
+}
+
+func (c *memoryCache) Get(ctx context.Context, url string) (*spotify.CachedResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.responses[url], nil
+}
+
+func (c *memoryCache) Put(ctx context.Context, url string, response *spotify.CachedResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses[url] = response
+	return nil
+}
+
+func TestCachedPageRevalidatedWithETag(t *testing.T) {
+	server := newTestServer(t)
+	server.SetAlbumTracks("album-1", []spotify.Track{{Id: "track-1"}, {Id: "track-2"}})
+
+	var mu sync.Mutex
+	var ifNoneMatch []string
+	server.FailRequests(func(r *http.Request) int {
+		if r.URL.Path == "/v1/albums/album-1/tracks" {
+			mu.Lock()
+			ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+			mu.Unlock()
+		}
+		return 0
+	})
+
+	cache := &memoryCache{responses: make(map[string]*spotify.CachedResponse)}
+	outcomes := &outcomeRecorder{}
+	cfg := server.ClientConfig()
+	cfg.ResponseCache = cache
+	cfg.OnOutcome = outcomes.record
+	client := spotify.NewClient(cfg)
+
+	getTracks := func() []string {
+		t.Helper()
+		var trackIds []string
+		err := client.GetAlbumsTracks(context.Background(), "album-1", "", func(tracks []*spotify.Track) error {
+			for _, track := range tracks {
+				trackIds = append(trackIds, track.Id)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("getting album tracks: %v", err)
+		}
+		return trackIds
+	}
+
+	first := getTracks()
+	if outcome := outcomes.last(); outcome.StatusCode != http.StatusOK {
+		t.Fatalf("first request got a %d, want a 200", outcome.StatusCode)
+	}
+	if len(cache.responses) != 1 {
+		t.Fatalf("cache holds %d responses after the first request, want 1", len(cache.responses))
+	}
+	var etag string
+	for _, response := range cache.responses {
+		etag = response.ETag
+	}
+	if etag == "" {
+		t.Fatal("cached response has no ETag")
+	}
+
+	second := getTracks()
+	if outcome := outcomes.last(); outcome.StatusCode != http.StatusNotModified || outcome.Err != nil {
+		t.Fatalf("second request reported %+v, want a 304", outcome)
+	}
+	if len(ifNoneMatch) != 2 || ifNoneMatch[0] != "" || ifNoneMatch[1] != etag {
+		t.Errorf("requests sent If-None-Match %q, want none and then %q", ifNoneMatch, etag)
+	}
+	if len(second) != len(first) || len(second) != 2 {
+		t.Fatalf("got tracks %v from the 304, want %v", second, first)
+	}
+	for i := range first {
+		if second[i] != first[i] {
+			t.Errorf("got tracks %v from the 304, want %v", second, first)
+			break
+		}
+	}
+}
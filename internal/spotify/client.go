@@ -0,0 +1,150 @@
+package spotify
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAPIURL   = "https://api.spotify.com/v1"
+	defaultTokenURL = "https://accounts.spotify.com/api/token"
+)
+
+// TokenSource provides the client-credentials token used for catalog endpoints (artists, albums,
+// audio features) that aren't called on behalf of a user
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticTokenSource always returns the same token
+type StaticTokenSource string
+
+func (s StaticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// Clock is the time source of a Client
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ClientConfig configures a Client. Zero fields fall back to the production defaults.
+type ClientConfig struct {
+	// APIURL is the Web API base URL, e.g. "https://api.spotify.com/v1"
+	APIURL string
+	// TokenURL is the accounts service endpoint used to exchange and refresh user tokens
+	TokenURL string
+	// HTTPClient sends every request of the client
+	HTTPClient *http.Client
+	// TokenSource provides the catalog token. Defaults to the token service at TOKEN_URL.
+	TokenSource TokenSource
+	Clock       Clock
+	// ResponseCache, if set, stores catalog responses so they can be revalidated with conditional requests
+	ResponseCache ResponseCache
+	// OnOutcome, if set, is called with the outcome of every request, e.g. to export metrics
+	OnOutcome func(RequestOutcome)
+}
+
+// Client talks to the Spotify Web API. All requests of a client share its rate limiters and
+// circuit breakers.
+type Client struct {
+	apiURL      string
+	tokenURL    string
+	httpClient  *http.Client
+	tokenSource TokenSource
+	clock       Clock
+	onOutcome   func(RequestOutcome)
+	cache       ResponseCache
+
+	clientCredentialsLimiter *rateLimiter
+	userTokenLimiter         *rateLimiter
+
+	breakersMu sync.Mutex
+	breakers   map[string]*circuitBreaker
+
+	// lastCatalogToken is the token last handed out by tokenSource, used to pick the limiter of a request
+	lastCatalogTokenMu sync.RWMutex
+	lastCatalogToken   string
+}
+
+func NewClient(cfg ClientConfig) *Client {
+	c := &Client{
+		apiURL:      cfg.APIURL,
+		tokenURL:    cfg.TokenURL,
+		httpClient:  cfg.HTTPClient,
+		tokenSource: cfg.TokenSource,
+		clock:       cfg.Clock,
+		onOutcome:   cfg.OnOutcome,
+		cache:       cfg.ResponseCache,
+		breakers:    make(map[string]*circuitBreaker),
+	}
+	if c.apiURL == "" {
+		c.apiURL = defaultAPIURL
+	}
+	if c.tokenURL == "" {
+		c.tokenURL = defaultTokenURL
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConns:        100,
+				IdleConnTimeout:     90 * time.Second,
+				MaxIdleConnsPerHost: 10,
+			},
+			Timeout: 30 * time.Second,
+		}
+	}
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
+	if c.tokenSource == nil {
+		c.tokenSource = newSecretTokenSource(c.httpClient, c.clock)
+	}
+	c.clientCredentialsLimiter = newRateLimiter("clientCredentials", clientCredentialsRate, clientCredentialsBurst, c.clock)
+	c.userTokenLimiter = newRateLimiter("userToken", userTokenRate, userTokenBurst, c.clock)
+	return c
+}
+
+var (
+	defaultClientMu sync.RWMutex
+	defaultClient   = NewClient(ClientConfig{})
+)
+
+// DefaultClient returns the client used by the auth handlers and the sync pipeline
+func DefaultClient() *Client {
+	defaultClientMu.RLock()
+	defer defaultClientMu.RUnlock()
+	return defaultClient
+}
+
+// SetDefaultClient replaces the default client, e.g. with one pointed at a fake server
+func SetDefaultClient(client *Client) {
+	defaultClientMu.Lock()
+	defer defaultClientMu.Unlock()
+	defaultClient = client
+}
+
+// catalogToken returns a token from the client's TokenSource
+func (c *Client) catalogToken(ctx context.Context) (string, error) {
+	token, err := c.tokenSource.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.lastCatalogTokenMu.Lock()
+	c.lastCatalogToken = token
+	c.lastCatalogTokenMu.Unlock()
+	return token, nil
+}
+
+// isCatalogToken reports whether token is the client-credentials token from the client's TokenSource
+func (c *Client) isCatalogToken(token string) bool {
+	c.lastCatalogTokenMu.RLock()
+	defer c.lastCatalogTokenMu.RUnlock()
+	return token != "" && token == c.lastCatalogToken
+}
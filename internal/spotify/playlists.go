@@ -1,13 +1,13 @@
 package spotify
 
 import (
-	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"math"
 	"net/http"
-	"time"
 
 	"go.uber.org/zap"
 )
@@ -24,24 +24,27 @@ type Playlist struct {
 	Followers   struct {
 		Total int `json:"total"`
 	} `json:"followers"`
-	Images []Image `json:"images"`
+	Images     []Image `json:"images"`
+	SnapshotId string  `json:"snapshot_id"`
 }
 
 type UsersPlaylistsResponse struct {
 	Items []Playlist `json:"items"`
 	Next  string     `json:"next"`
+	Total int        `json:"total"`
 }
 
 type PlaylistsTracksResponse struct {
 	Items []UsersSavedTrackItem `json:"items"`
 	Next  string                `json:"next"`
+	Total int                   `json:"total"`
 }
 
-func GetUsersPlaylists(token string, processor func([]*Playlist) error) error {
+func (c *Client) GetUsersPlaylists(ctx context.Context, token string, processor func([]*Playlist) error) error {
 	logger.Debug("Attempting to get user's playlists")
-	url := fmt.Sprintf("%s/me/playlists/?limit=%d&offset=%d", spotifyAPIURL, limitMax, 0)
+	url := fmt.Sprintf("%s/me/playlists/?limit=%d&offset=%d", c.apiURL, limitMax, 0)
 
-	err := fetchAllResultsStreaming(token, url, func(response *UsersPlaylistsResponse) error {
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersPlaylistsResponse) error {
 		playlists := make([]*Playlist, len(response.Items))
 		for i := range response.Items {
 			playlists[i] = &response.Items[i]
@@ -61,15 +64,15 @@ func GetUsersPlaylists(token string, processor func([]*Playlist) error) error {
 	return nil
 }
 
-func GetPlaylistsTracks(token string, playlistId string, processor func([]*Track) error) error {
+func (c *Client) GetPlaylistsTracks(ctx context.Context, token string, playlistId string, processor func([]*Track) error) error {
 	logger.Debug("Attempting to get tracks for playlist", zap.String("playlistId", playlistId))
-	url := fmt.Sprintf("%s/playlists/%s/tracks?limit=%d&offset=%d", spotifyAPIURL, playlistId, limitMax, 0)
+	url := fmt.Sprintf("%s/playlists/%s/tracks?limit=%d&offset=%d", c.apiURL, playlistId, limitMax, 0)
 
-	audioFeaturesBatcher := createAudioFeaturesBatcher(processor)
+	trackBatcher := NewBatchProcessor(trackBatchSize, processor)
 
-	err := fetchAllResultsStreaming(token, url, func(response *PlaylistsTracksResponse) error {
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *PlaylistsTracksResponse) error {
 		for i := range response.Items {
-			if err := audioFeaturesBatcher.Add(&response.Items[i].Track); err != nil {
+			if err := trackBatcher.Add(&response.Items[i].Track); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
 			}
 		}
@@ -79,31 +82,44 @@ func GetPlaylistsTracks(token string, playlistId string, processor func([]*Track
 		return fmt.Errorf("fetching tracks for playlist %s: %w", playlistId, err)
 	}
 
-	if err := audioFeaturesBatcher.Flush(); err != nil {
-		return fmt.Errorf("flushing audio features for playlist %s: %w", playlistId, err)
+	if err := trackBatcher.Flush(); err != nil {
+		return fmt.Errorf("flushing remaining tracks for playlist %s: %w", playlistId, err)
 	}
 
 	return nil
 }
 
+// playlistItemsBatchSize is the most tracks Spotify accepts in one request to add or replace playlist items
+const playlistItemsBatchSize = 100
+
+func runDJPlaylistName(bpm float64) string {
+	return fmt.Sprintf("RunDJ %d BPM", int(math.Round(bpm)))
+}
+
+func runDJPlaylistDescription(minBPM float64, maxBPM float64) string {
+	return fmt.Sprintf("This playlist was created by RunDJ. All songs in this playlist have a BPM range of %f-%f", minBPM, maxBPM)
+}
+
 // TODO: Review
-func CreatePlaylist(token string, userId string, bpm float64, min float64, max float64, tracks []string) (*Playlist, error) {
+func (c *Client) CreatePlaylist(ctx context.Context, token string, userId string, bpm float64, minBPM float64, maxBPM float64, tracks []string) (*Playlist, error) {
 	logger.Debug("Attempting to create playlist for user",
 		zap.String("userId", userId),
 		zap.Float64("bpm", bpm),
 		zap.Int("trackCount", len(tracks)))
 
-	name := fmt.Sprintf("RunDJ %d BPM", int(math.Round(bpm)))
-	description := fmt.Sprintf("This playlist was created by RunDJ. All songs in this playlist have a BPM range of %f-%f", min, max)
-	public := false
+	return c.CreateNamedPlaylist(ctx, token, userId, runDJPlaylistName(bpm), runDJPlaylistDescription(minBPM, maxBPM), tracks)
+}
 
-	url := fmt.Sprintf("%s/users/%s/playlists", spotifyAPIURL, userId)
+// CreateNamedPlaylist creates a private playlist with the given name and description and adds
+// tracks to it in order. If adding tracks fails the created playlist is returned with the error.
+func (c *Client) CreateNamedPlaylist(ctx context.Context, token string, userId string, name string, description string, tracks []string) (*Playlist, error) {
+	url := fmt.Sprintf("%s/users/%s/playlists", c.apiURL, userId)
 	logger.Debug("Create playlist request URL", zap.String("url", url))
 
 	postData := map[string]any{
 		"name":        name,
 		"description": description,
-		"public":      public,
+		"public":      false,
 	}
 	jsonData, err := json.Marshal(postData)
 	if err != nil {
@@ -111,33 +127,18 @@ func CreatePlaylist(token string, userId string, bpm float64, min float64, max f
 	}
 	logger.Debug("Create playlist request body", zap.ByteString("jsonData", jsonData))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("creating POST request for create playlist: %w", err)
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("sending create playlist request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := c.execute(ctx, apiRequest{
+		method:      "POST",
+		url:         url,
+		token:       token,
+		body:        jsonData,
+		contentType: "application/json",
+	})
 	if err != nil {
-		return nil, fmt.Errorf("reading create playlist response body: %w", err)
+		return nil, fmt.Errorf("creating playlist: %w", err)
 	}
 	bodyString := string(bodyBytes)
-	logger.Debug("Create playlist response body", zap.String("body", bodyString), zap.Int("statusCode", resp.StatusCode))
-
-	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("creating playlist: status %d, body: %s", resp.StatusCode, bodyString)
-	}
+	logger.Debug("Create playlist response body", zap.String("body", bodyString))
 
 	playlist := &Playlist{}
 	err = json.Unmarshal(bodyBytes, playlist)
@@ -151,48 +152,125 @@ func CreatePlaylist(token string, userId string, bpm float64, min float64, max f
 	logger.Debug("Successfully created playlist", zap.String("playlistId", playlistId), zap.String("userId", userId), zap.String("name", name))
 
 	// Add tracks to the created playlist
-	if len(tracks) > 0 {
-		logger.Debug("Adding tracks to created playlist", zap.String("playlistId", playlistId), zap.Int("trackCount", len(tracks)))
-		for i := 0; i < len(tracks); i += 100 {
-			var ids []string
-			for j := i; j < i+100 && j < len(tracks); j++ {
-				ids = append(ids, "spotify:track:"+tracks[j])
-			}
-
-			addTracksURL := fmt.Sprintf("%s/playlists/%s/tracks", spotifyAPIURL, playlistId)
-			logger.Debug("Add tracks to playlist URL", zap.String("url", addTracksURL), zap.Int("batchSize", len(ids)))
+	for i := 0; i < len(tracks); i += playlistItemsBatchSize {
+		batch := tracks[i:min(i+playlistItemsBatchSize, len(tracks))]
+		if err := c.sendPlaylistItems(ctx, token, playlistId, "POST", batch); err != nil {
+			return playlist, fmt.Errorf("adding tracks to playlist %s: %w", playlistId, err)
+		}
+	}
+	logger.Debug("Finished adding all tracks to playlist", zap.String("playlistId", playlistId), zap.Int("totalTracksAdded", len(tracks)))
 
-			addTracksJsonData, err := json.Marshal(map[string]any{
-				"uris": ids,
-			})
-			if err != nil {
-				return playlist, fmt.Errorf("marshalling add tracks data: %w", err)
-			}
-			logger.Debug("Add tracks request body", zap.ByteString("jsonData", addTracksJsonData))
+	return playlist, nil
+}
 
-			addTracksReq, err := http.NewRequest("POST", addTracksURL, bytes.NewBuffer(addTracksJsonData))
-			if err != nil {
-				return playlist, fmt.Errorf("creating POST request for add tracks: %w", err)
-			}
+// UpdatePlaylist regenerates a playlist made by CreatePlaylist in place: its name and description
+// are updated for the new BPM range and its items are replaced by tracks
+func (c *Client) UpdatePlaylist(ctx context.Context, token string, playlistId string, bpm float64, minBPM float64, maxBPM float64, tracks []string) (*Playlist, error) {
+	logger.Debug("Attempting to update playlist",
+		zap.String("playlistId", playlistId),
+		zap.Float64("bpm", bpm),
+		zap.Int("trackCount", len(tracks)))
 
-			addTracksReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-			addTracksReq.Header.Set("Content-Type", "application/json")
-			// client is already defined
+	jsonData, err := json.Marshal(map[string]any{
+		"name":        runDJPlaylistName(bpm),
+		"description": runDJPlaylistDescription(minBPM, maxBPM),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling update playlist data: %w", err)
+	}
 
-			addTracksResp, err := client.Do(addTracksReq)
-			if err != nil {
-				return playlist, fmt.Errorf("sending add tracks request: %w", err)
-			}
-			defer addTracksResp.Body.Close() // Defer inside loop is okay for non-critical resources
+	// Changing details is a PUT of the same body, so it is safe to retry
+	_, err = c.execute(ctx, apiRequest{
+		method:      "PUT",
+		url:         fmt.Sprintf("%s/playlists/%s", c.apiURL, playlistId),
+		token:       token,
+		body:        jsonData,
+		contentType: "application/json",
+		idempotent:  true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating details of playlist %s: %w", playlistId, err)
+	}
 
-			if addTracksResp.StatusCode != http.StatusCreated && addTracksResp.StatusCode != http.StatusOK { // Some APIs use 200 for adding items
-				addTracksBodyBytes, _ := io.ReadAll(addTracksResp.Body) // Read body for error logging
-				return playlist, fmt.Errorf("adding tracks to playlist %s: status %d, body: %s", playlistId, addTracksResp.StatusCode, string(addTracksBodyBytes))
-			}
-			logger.Debug("Successfully added batch of tracks to playlist", zap.String("playlistId", playlistId), zap.Int("batchSize", len(ids)))
+	// The first batch replaces the items, including when there are no tracks at all, and the rest are appended
+	first := tracks[:min(playlistItemsBatchSize, len(tracks))]
+	if err := c.sendPlaylistItems(ctx, token, playlistId, "PUT", first); err != nil {
+		return nil, fmt.Errorf("replacing tracks of playlist %s: %w", playlistId, err)
+	}
+	for i := playlistItemsBatchSize; i < len(tracks); i += playlistItemsBatchSize {
+		batch := tracks[i:min(i+playlistItemsBatchSize, len(tracks))]
+		if err := c.sendPlaylistItems(ctx, token, playlistId, "POST", batch); err != nil {
+			return nil, fmt.Errorf("adding tracks to playlist %s: %w", playlistId, err)
 		}
-		logger.Debug("Finished adding all tracks to playlist", zap.String("playlistId", playlistId), zap.Int("totalTracksAdded", len(tracks)))
 	}
 
+	playlist, err := fetchPage[Playlist](ctx, c, token, fmt.Sprintf("%s/playlists/%s", c.apiURL, playlistId))
+	if err != nil {
+		return nil, fmt.Errorf("getting updated playlist %s: %w", playlistId, err)
+	}
+	logger.Debug("Successfully updated playlist", zap.String("playlistId", playlistId), zap.Int("trackCount", len(tracks)))
 	return playlist, nil
 }
+
+// FollowsPlaylist reports whether the user follows a playlist. Deleting a playlist in Spotify only
+// unfollows it, so this is how to tell whether a user still has a playlist made for them.
+func (c *Client) FollowsPlaylist(ctx context.Context, token string, playlistId string, userId string) (bool, error) {
+	url := fmt.Sprintf("%s/playlists/%s/followers/contains?ids=%s", c.apiURL, playlistId, userId)
+	response, err := fetchPage[[]bool](ctx, c, token, url)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("checking whether user follows playlist %s: %w", playlistId, err)
+	}
+	return len(*response) > 0 && (*response)[0], nil
+}
+
+// UploadPlaylistCover replaces the cover image of a playlist with a JPEG of at most 256 KB once
+// base64 encoded. It needs the ugc-image-upload scope.
+func (c *Client) UploadPlaylistCover(ctx context.Context, token string, playlistId string, jpeg []byte) error {
+	logger.Debug("Uploading playlist cover", zap.String("playlistId", playlistId), zap.Int("bytes", len(jpeg)))
+
+	_, err := c.execute(ctx, apiRequest{
+		method:      "PUT",
+		url:         fmt.Sprintf("%s/playlists/%s/images", c.apiURL, playlistId),
+		token:       token,
+		body:        []byte(base64.StdEncoding.EncodeToString(jpeg)),
+		contentType: "image/jpeg",
+		idempotent:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("uploading cover of playlist %s: %w", playlistId, err)
+	}
+	return nil
+}
+
+// sendPlaylistItems adds tracks to a playlist with POST, or replaces its items with them with PUT
+func (c *Client) sendPlaylistItems(ctx context.Context, token string, playlistId string, method string, tracks []string) error {
+	uris := make([]string, len(tracks))
+	for i, trackId := range tracks {
+		uris[i] = "spotify:track:" + trackId
+	}
+	jsonData, err := json.Marshal(map[string]any{
+		"uris": uris,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling playlist items: %w", err)
+	}
+
+	_, err = c.execute(ctx, apiRequest{
+		method:      method,
+		url:         fmt.Sprintf("%s/playlists/%s/tracks", c.apiURL, playlistId),
+		token:       token,
+		body:        jsonData,
+		contentType: "application/json",
+		// Replacing is idempotent, appending is not
+		idempotent: method == "PUT",
+	})
+	if err != nil {
+		return err
+	}
+	logger.Debug("Sent batch of playlist items", zap.String("playlistId", playlistId), zap.String("method", method), zap.Int("batchSize", len(uris)))
+	return nil
+}
@@ -0,0 +1,159 @@
+package spotify
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// --- Rate Limiting ---
+//
+// Every request to the Web API goes through a rateLimiter shared by all users of a Client. A token
+// bucket caps the request rate, and the number of requests in flight adapts AIMD-style: it grows
+// by one for every window of successful requests and halves on a 429. A 429 also pauses every
+// caller of the limiter until Retry-After has passed, instead of only the goroutine that saw it.
+//
+// Requests made with the client-credentials token and with user tokens are budgeted separately,
+// so a burst of catalog crawling can't starve user-facing calls and vice versa.
+
+const (
+	defaultRetryAfter = 60 * time.Second
+
+	clientCredentialsRate  = 10 // requests per second
+	clientCredentialsBurst = 20
+	userTokenRate          = 10
+	userTokenBurst         = 20
+
+	minConcurrency     = 1
+	maxConcurrency     = 32
+	initialConcurrency = 8
+)
+
+type rateLimiter struct {
+	name  string
+	rate  float64
+	burst float64
+	clock Clock
+
+	mu          sync.Mutex
+	tokens      float64
+	lastRefill  time.Time
+	concurrency float64
+	inFlight    int
+	pausedUntil time.Time
+	// changed is closed and replaced whenever capacity is freed, to wake up waiting callers
+	changed chan struct{}
+}
+
+func newRateLimiter(name string, rate float64, burst float64, clock Clock) *rateLimiter {
+	return &rateLimiter{
+		name:        name,
+		rate:        rate,
+		burst:       burst,
+		clock:       clock,
+		tokens:      burst,
+		lastRefill:  clock.Now(),
+		concurrency: initialConcurrency,
+		changed:     make(chan struct{}),
+	}
+}
+
+// limiterFor returns the limiter budgeting requests made with token
+func (c *Client) limiterFor(token string) *rateLimiter {
+	if c.isCatalogToken(token) {
+		return c.clientCredentialsLimiter
+	}
+	return c.userTokenLimiter
+}
+
+// acquire blocks until a request may be sent, or ctx is done. The caller must call release
+// with the outcome of the request.
+func (l *rateLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := l.clock.Now()
+		l.refill(now)
+
+		var wait time.Duration
+		switch {
+		case now.Before(l.pausedUntil):
+			wait = l.pausedUntil.Sub(now)
+		case l.inFlight >= int(l.concurrency):
+			// Wait for a release; the timer is only a safety net
+			wait = time.Second
+		case l.tokens < 1:
+			wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		default:
+			l.tokens--
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// release records the outcome of a request. resp is nil if the request failed without a response.
+// Only successful responses grow concurrency; other client errors say nothing about how much load
+// Spotify takes, so they leave it unchanged.
+func (l *rateLimiter) release(resp *http.Response) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if pausedUntil := l.clock.Now().Add(retryAfter); pausedUntil.After(l.pausedUntil) {
+			l.pausedUntil = pausedUntil
+		}
+		l.concurrency = max(minConcurrency, l.concurrency/2)
+		l.tokens = 0
+		logger.Warn("Rate limited by Spotify, pausing all requests",
+			zap.String("limiter", l.name),
+			zap.Duration("retryAfter", retryAfter),
+			zap.Float64("concurrency", l.concurrency))
+	case resp != nil && (resp.StatusCode >= 200 && resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified):
+		l.concurrency = min(maxConcurrency, l.concurrency+1/l.concurrency)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.lastRefill).Seconds()*l.rate)
+	l.lastRefill = now
+}
+
+// doRateLimited sends req through the limiter of its token
+func (c *Client) doRateLimited(ctx context.Context, token string, req *http.Request) (*http.Response, error) {
+	limiter := c.limiterFor(token)
+	if err := limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	limiter.release(resp)
+	return resp, err
+}
+
+func parseRetryAfter(header string) time.Duration {
+	if retryAfter, ok := retryAfterHeader(header); ok {
+		return retryAfter
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,282 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// --- Request Execution ---
+//
+// Every request to Spotify goes through Client.execute, which applies the same policy to all
+// endpoints: the request waits for the rate limiter of its token, fails fast while the endpoint's
+// circuit breaker is open, and is retried on network failures, 429s and server errors with
+// jittered exponential backoff that honours Retry-After. Each request is reported as a
+// RequestOutcome once it succeeds or gives up.
+
+const (
+	maxAttempts      = 4
+	retryBaseDelay   = 250 * time.Millisecond
+	retryMaxDelay    = 10 * time.Second
+	minShrunkenLimit = 10
+)
+
+// retryLimits are the page sizes tried, in order, when Spotify returns a 502 for a large page
+var retryLimits = []int{20, 10, 5}
+
+// APIError is returned for a response with a status code that isn't retried, or that still
+// failed after the last attempt
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("spotify API returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("spotify API returned %d: %s", e.StatusCode, e.Body)
+}
+
+// RequestOutcome describes how a request went, once it has succeeded or been given up on
+type RequestOutcome struct {
+	// Endpoint is the method and path of the request with ids replaced, e.g. "GET /albums/{id}/tracks"
+	Endpoint   string
+	StatusCode int // 0 if no response was received
+	Attempts   int
+	Duration   time.Duration
+	Err        error
+}
+
+// apiRequest is a request for Client.execute
+type apiRequest struct {
+	method string
+	url    string
+	// token is the bearer token. Requests without one, like token exchanges, skip the rate limiters.
+	token       string
+	body        []byte
+	contentType string
+	header      http.Header
+	// idempotent requests are also retried after server errors and network failures; other
+	// requests are only retried after a 429, which Spotify sends before doing any work
+	idempotent bool
+	// cacheable GETs are revalidated against the client's ResponseCache
+	cacheable bool
+	cached    *CachedResponse
+}
+
+// execute sends r and returns the body of its 2xx response, or the cached body on a 304. Other
+// responses are returned as an *APIError.
+func (c *Client) execute(ctx context.Context, r apiRequest) ([]byte, error) {
+	endpoint := endpointKey(r.method, r.url)
+	breaker := c.breakerFor(endpoint)
+	start := c.clock.Now()
+	requestURL := r.url
+	outcome := RequestOutcome{Endpoint: endpoint}
+	if r.cacheable {
+		r.cached = c.cachedResponse(ctx, r.url)
+	}
+
+	var result attemptResult
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		outcome.Attempts = attempt
+
+		result, err = c.attempt(ctx, breaker, r, requestURL, &outcome)
+		if err == nil || !result.retry || attempt == maxAttempts {
+			break
+		}
+
+		// Spotify assembles large pages slowly and answers 502 when it gives up, so ask for less
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadGateway {
+			requestURL = shrinkLimit(requestURL)
+		}
+
+		logger.Debug("Retrying Spotify request",
+			zap.String("endpoint", endpoint),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("delay", result.delay),
+			zap.Error(err))
+		if sleepErr := SleepContext(ctx, result.delay); sleepErr != nil {
+			err = sleepErr
+			break
+		}
+	}
+
+	if err == nil && r.cacheable && outcome.StatusCode == http.StatusOK {
+		c.storeResponse(ctx, requestURL, result.header, result.body)
+	}
+
+	outcome.Duration = c.clock.Now().Sub(start)
+	outcome.Err = err
+	c.report(outcome)
+	if err != nil {
+		return nil, err
+	}
+	return result.body, nil
+}
+
+// attemptResult is the response to a single attempt of a request
+type attemptResult struct {
+	body   []byte
+	header http.Header
+	// delay is how long to wait before retrying, and retry whether a retry may help
+	delay time.Duration
+	retry bool
+}
+
+// attempt sends r once
+func (c *Client) attempt(ctx context.Context, breaker *circuitBreaker, r apiRequest, requestURL string, outcome *RequestOutcome) (attemptResult, error) {
+	backoff := retryDelay(outcome.Attempts)
+	outcome.StatusCode = 0
+
+	if !breaker.allow() {
+		return attemptResult{}, fmt.Errorf("%s: %w", outcome.Endpoint, ErrCircuitOpen)
+	}
+
+	var bodyReader io.Reader
+	if r.body != nil {
+		bodyReader = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, requestURL, bodyReader)
+	if err != nil {
+		breaker.record(false)
+		return attemptResult{}, fmt.Errorf("creating request: %w", err)
+	}
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if r.cached != nil && requestURL == r.url {
+		if r.cached.ETag != "" {
+			req.Header.Set("If-None-Match", r.cached.ETag)
+		}
+		if r.cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", r.cached.LastModified)
+		}
+	}
+
+	var resp *http.Response
+	if r.token != "" {
+		resp, err = c.doRateLimited(ctx, r.token, req)
+	} else {
+		resp, err = c.httpClient.Do(req)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// Cancellation says nothing about the health of the endpoint
+			breaker.record(false)
+			return attemptResult{}, ctx.Err()
+		}
+		breaker.record(true)
+		return attemptResult{delay: backoff, retry: r.idempotent}, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	outcome.StatusCode = resp.StatusCode
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		breaker.record(true)
+		return attemptResult{delay: backoff, retry: r.idempotent}, fmt.Errorf("reading response body: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		breaker.record(false)
+		return attemptResult{body: body, header: resp.Header}, nil
+	case resp.StatusCode == http.StatusNotModified && r.cached != nil:
+		breaker.record(false)
+		return attemptResult{body: r.cached.Body, header: resp.Header}, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// The rate limiter has already paused every caller until Retry-After has passed, so the
+		// retry only needs to queue up behind it
+		breaker.record(false)
+		return attemptResult{retry: true}, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	case resp.StatusCode >= 500:
+		breaker.record(true)
+		if retryAfter, ok := retryAfterHeader(resp.Header.Get("Retry-After")); ok {
+			backoff = max(backoff, retryAfter)
+		}
+		return attemptResult{delay: backoff, retry: r.idempotent}, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	default:
+		// Other client errors won't go away by retrying
+		breaker.record(false)
+		return attemptResult{}, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+}
+
+// report logs the outcome of a request and passes it to the client's OnOutcome hook
+func (c *Client) report(outcome RequestOutcome) {
+	fields := []zap.Field{
+		zap.String("endpoint", outcome.Endpoint),
+		zap.Int("statusCode", outcome.StatusCode),
+		zap.Int("attempts", outcome.Attempts),
+		zap.Duration("duration", outcome.Duration),
+	}
+	if outcome.Err != nil && !errors.Is(outcome.Err, context.Canceled) {
+		logger.Warn("Spotify request failed", append(fields, zap.Error(outcome.Err))...)
+	} else {
+		logger.Debug("Spotify request finished", fields...)
+	}
+
+	if c.onOutcome != nil {
+		c.onOutcome(outcome)
+	}
+}
+
+// retryDelay is the backoff before the attempt after attempt: exponential with equal jitter, so
+// callers that failed together don't retry together
+func retryDelay(attempt int) time.Duration {
+	delay := min(retryMaxDelay, retryBaseDelay<<(attempt-1))
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryAfterHeader parses a Retry-After header given in seconds
+func retryAfterHeader(header string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// shrinkLimit lowers the page size of a paginated URL to the next of retryLimits
+func shrinkLimit(apiURL string) string {
+	if !strings.Contains(apiURL, "limit=") {
+		return apiURL
+	}
+	parsedURL, err := url.Parse(apiURL)
+	if err != nil {
+		return apiURL
+	}
+	current, err := strconv.Atoi(parsedURL.Query().Get("limit"))
+	if err != nil || current <= minShrunkenLimit {
+		return apiURL
+	}
+
+	for _, limit := range retryLimits {
+		if limit < current {
+			logger.Warn("Got a 502 error, reducing request limit",
+				zap.Int("newLimit", limit),
+				zap.String("url", apiURL))
+			return modifyURLLimit(apiURL, limit)
+		}
+	}
+	return apiURL
+}
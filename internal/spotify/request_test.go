@@ -0,0 +1,237 @@
+package spotify_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/spotify/spotifytest"
+)
+
+// fakeClock is a spotify.Clock that only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// outcomeRecorder collects the outcomes reported to a client's OnOutcome hook
+type outcomeRecorder struct {
+	mu       sync.Mutex
+	outcomes []spotify.RequestOutcome
+}
+
+func (r *outcomeRecorder) record(outcome spotify.RequestOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = append(r.outcomes, outcome)
+}
+
+func (r *outcomeRecorder) last() spotify.RequestOutcome {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.outcomes) == 0 {
+		return spotify.RequestOutcome{}
+	}
+	return r.outcomes[len(r.outcomes)-1]
+}
+
+func newTestServer(t *testing.T) *spotifytest.Server {
+	t.Helper()
+	spotify.InitializeLogger(zap.NewNop())
+	server := spotifytest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser("alice-token", &spotifytest.Library{User: spotify.User{Id: "alice"}})
+	return server
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	server := newTestServer(t)
+	const createPath = "/v1/users/alice/playlists"
+	var failing atomic.Bool
+	failing.Store(true)
+	server.FailRequests(func(r *http.Request) int {
+		if r.Method == "POST" && r.URL.Path == createPath && failing.Load() {
+			return http.StatusInternalServerError
+		}
+		return 0
+	})
+
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	outcomes := &outcomeRecorder{}
+	cfg := server.ClientConfig()
+	cfg.Clock = clock
+	cfg.OnOutcome = outcomes.record
+	client := spotify.NewClient(cfg)
+
+	create := func() error {
+		_, err := client.CreateNamedPlaylist(context.Background(), "alice-token", "alice", "Run", "", nil)
+		return err
+	}
+
+	// Playlist creation isn't idempotent, so each server error is returned without a retry
+	for i := 1; i <= 5; i++ {
+		err := create()
+		var apiErr *spotify.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("create %d returned %v, want a 500", i, err)
+		}
+		if got := server.Requests("POST", createPath); got != i {
+			t.Fatalf("after create %d the server received %d requests, want %d", i, got, i)
+		}
+		outcome := outcomes.last()
+		if outcome.Endpoint != "POST /users/{id}/playlists" || outcome.StatusCode != http.StatusInternalServerError || outcome.Attempts != 1 || outcome.Err == nil {
+			t.Fatalf("create %d reported %+v, want one failed attempt with a 500", i, outcome)
+		}
+	}
+
+	// Five failures in a row open the breaker, so the next request isn't sent
+	if err := create(); !errors.Is(err, spotify.ErrCircuitOpen) {
+		t.Fatalf("create with the breaker open returned %v, want %v", err, spotify.ErrCircuitOpen)
+	}
+	if got := server.Requests("POST", createPath); got != 5 {
+		t.Fatalf("the server received %d requests with the breaker open, want 5", got)
+	}
+	if outcome := outcomes.last(); outcome.StatusCode != 0 || !errors.Is(outcome.Err, spotify.ErrCircuitOpen) {
+		t.Errorf("create with the breaker open reported %+v, want no status and %v", outcome, spotify.ErrCircuitOpen)
+	}
+
+	// After the cooldown a probe is let through; its failure opens the breaker again
+	clock.Advance(30 * time.Second)
+	if err := create(); errors.Is(err, spotify.ErrCircuitOpen) || err == nil {
+		t.Fatalf("probe returned %v, want a 500", err)
+	}
+	if err := create(); !errors.Is(err, spotify.ErrCircuitOpen) {
+		t.Fatalf("create after a failed probe returned %v, want %v", err, spotify.ErrCircuitOpen)
+	}
+	if got := server.Requests("POST", createPath); got != 6 {
+		t.Fatalf("the server received %d requests, want 6", got)
+	}
+
+	// A successful probe closes the breaker
+	clock.Advance(30 * time.Second)
+	failing.Store(false)
+	for i := range 2 {
+		if err := create(); err != nil {
+			t.Fatalf("create %d after recovery returned %v", i+1, err)
+		}
+	}
+	if got := server.Requests("POST", createPath); got != 8 {
+		t.Fatalf("the server received %d requests, want 8", got)
+	}
+	if outcome := outcomes.last(); outcome.StatusCode != http.StatusCreated || outcome.Err != nil {
+		t.Errorf("create after recovery reported %+v, want a 201", outcome)
+	}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	server := newTestServer(t)
+	var failed atomic.Bool
+	server.FailRequests(func(r *http.Request) int {
+		if r.URL.Path == "/v1/me" && !failed.Swap(true) {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	})
+	server.SetRetryAfter(1)
+
+	outcomes := &outcomeRecorder{}
+	cfg := server.ClientConfig()
+	cfg.OnOutcome = outcomes.record
+	client := spotify.NewClient(cfg)
+
+	start := time.Now()
+	user, err := client.GetUser(context.Background(), "alice-token")
+	if err != nil {
+		t.Fatalf("getting user: %v", err)
+	}
+	if user.Id != "alice" {
+		t.Errorf("got user %q, want alice", user.Id)
+	}
+	// The backoff of a first retry is well under a second, so only Retry-After makes it wait this long
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s of Retry-After", elapsed)
+	}
+
+	outcome := outcomes.last()
+	if outcome.Endpoint != "GET /me" || outcome.StatusCode != http.StatusOK || outcome.Attempts != 2 || outcome.Err != nil {
+		t.Errorf("reported %+v, want a 200 on the second attempt", outcome)
+	}
+}
+
+func TestRetryShrinksPageAfterBadGateway(t *testing.T) {
+	server := newTestServer(t)
+	server.SetAlbumTracks("album-1", []spotify.Track{{Id: "track-1"}, {Id: "track-2"}})
+
+	var mu sync.Mutex
+	var limits []int
+	server.FailRequests(func(r *http.Request) int {
+		if r.URL.Path != "/v1/albums/album-1/tracks" {
+			return 0
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		mu.Lock()
+		defer mu.Unlock()
+		limits = append(limits, limit)
+		if len(limits) == 1 {
+			return http.StatusBadGateway
+		}
+		return 0
+	})
+
+	var trackCount int
+	err := server.Client().GetAlbumsTracks(context.Background(), "album-1", "", func(tracks []*spotify.Track) error {
+		trackCount += len(tracks)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("getting album tracks: %v", err)
+	}
+	if trackCount != 2 {
+		t.Errorf("got %d tracks, want 2", trackCount)
+	}
+	if len(limits) != 2 || limits[0] <= 20 || limits[1] != 20 {
+		t.Errorf("requested limits %v, want a page over 20 retried with 20", limits)
+	}
+}
+
+func TestGetUserFailsOnFailedPage(t *testing.T) {
+	server := newTestServer(t)
+	server.FailRequests(func(r *http.Request) int {
+		if r.URL.Path == "/v1/me" {
+			return http.StatusUnauthorized
+		}
+		return 0
+	})
+
+	user, err := server.Client().GetUser(context.Background(), "alice-token")
+	var apiErr *spotify.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("getting user returned %v, want a 401", err)
+	}
+	if user != nil {
+		t.Errorf("got user %+v alongside the error, want none", user)
+	}
+	if got := server.Requests("GET", "/v1/me"); got != 1 {
+		t.Errorf("the server received %d requests, want 1 since a 401 isn't retried", got)
+	}
+}
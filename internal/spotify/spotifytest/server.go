@@ -0,0 +1,707 @@
+// Package spotifytest provides an in-memory fake of the Spotify endpoints used by the sync pipeline,
+// so it can run without reaching api.spotify.com.
+//
+//	server := spotifytest.NewServer()
+//	defer server.Close()
+//	server.AddUser("user-token", &spotifytest.Library{User: spotify.User{Id: "alice"}})
+//	spotify.SetDefaultClient(server.Client())
+package spotifytest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rcong315/RunDJServer/internal/spotify"
+)
+
+// CatalogToken is the client-credentials token handed out by the clients of a Server
+const CatalogToken = "spotifytest-catalog-token"
+
+const (
+	defaultLimit = 20
+	maxLimit     = 50
+)
+
+// Library is the data a user token can see
+type Library struct {
+	User            spotify.User
+	SavedTracks     []spotify.UsersSavedTrackItem // newest first, like Spotify
+	SavedAlbums     []spotify.SavedAlbum          // newest first
+	TopTracks       []spotify.Track
+	TopArtists      []spotify.Artist
+	FollowedArtists []spotify.Artist
+	Playlists       []spotify.Playlist
+}
+
+// CreatedPlaylist is a playlist created through the fake, with the track URIs added to it
+type CreatedPlaylist struct {
+	Playlist  spotify.Playlist
+	OwnerId   string
+	TrackURIs []string
+	// CoverJPEG is the last cover uploaded for the playlist
+	CoverJPEG []byte
+	// Unfollowed is set when the owner deleted the playlist, which Spotify treats as unfollowing it
+	Unfollowed bool
+}
+
+// Server is a fake Spotify Web API and accounts service. It is safe for concurrent use, and its
+// data can be changed between syncs to simulate library edits.
+type Server struct {
+	URL string
+
+	server *httptest.Server
+
+	mu               sync.Mutex
+	libraries        map[string]*Library // by access token
+	grants           map[string]*spotify.TokenResponse
+	playlistTracks   map[string][]spotify.UsersSavedTrackItem
+	albumTracks      map[string][]spotify.Track
+	artistAlbums     map[string][]spotify.Album
+	artistTopTracks  map[string][]spotify.Track
+	audioFeatures    map[string]spotify.AudioFeatures
+	createdPlaylists map[string]*CreatedPlaylist
+	requests         map[string]int
+	fail             func(*http.Request) int
+	retryAfter       string
+}
+
+func NewServer() *Server {
+	s := &Server{
+		libraries:        make(map[string]*Library),
+		grants:           make(map[string]*spotify.TokenResponse),
+		playlistTracks:   make(map[string][]spotify.UsersSavedTrackItem),
+		albumTracks:      make(map[string][]spotify.Track),
+		artistAlbums:     make(map[string][]spotify.Album),
+		artistTopTracks:  make(map[string][]spotify.Track),
+		audioFeatures:    make(map[string]spotify.AudioFeatures),
+		createdPlaylists: make(map[string]*CreatedPlaylist),
+		requests:         make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/token", s.handleToken)
+	mux.HandleFunc("GET /v1/me", s.userHandler(s.handleMe))
+	mux.HandleFunc("GET /v1/me/tracks", s.userHandler(s.handleSavedTracks))
+	mux.HandleFunc("GET /v1/me/albums", s.userHandler(s.handleSavedAlbums))
+	mux.HandleFunc("GET /v1/me/top/tracks", s.userHandler(s.handleTopTracks))
+	mux.HandleFunc("GET /v1/me/top/artists", s.userHandler(s.handleTopArtists))
+	mux.HandleFunc("GET /v1/me/following", s.userHandler(s.handleFollowedArtists))
+	mux.HandleFunc("GET /v1/me/playlists", s.userHandler(s.handlePlaylists))
+	mux.HandleFunc("POST /v1/users/{userId}/playlists", s.userHandler(s.handleCreatePlaylist))
+	mux.HandleFunc("GET /v1/playlists/{playlistId}/tracks", s.catalogHandler(s.handlePlaylistTracks))
+	mux.HandleFunc("GET /v1/playlists/{playlistId}", s.userHandler(s.handleGetPlaylist))
+	mux.HandleFunc("PUT /v1/playlists/{playlistId}", s.userHandler(s.handleUpdatePlaylist))
+	mux.HandleFunc("GET /v1/playlists/{playlistId}/followers/contains", s.userHandler(s.handleFollowsPlaylist))
+	mux.HandleFunc("POST /v1/playlists/{playlistId}/tracks", s.userHandler(s.handleAddTracks))
+	mux.HandleFunc("PUT /v1/playlists/{playlistId}/tracks", s.userHandler(s.handleReplaceTracks))
+	mux.HandleFunc("PUT /v1/playlists/{playlistId}/images", s.userHandler(s.handleUploadCover))
+	mux.HandleFunc("GET /v1/albums/{albumId}/tracks", s.catalogHandler(s.handleAlbumTracks))
+	mux.HandleFunc("GET /v1/artists/{artistId}/albums", s.catalogHandler(s.handleArtistAlbums))
+	mux.HandleFunc("GET /v1/artists/{artistId}/top-tracks", s.catalogHandler(s.handleArtistTopTracks))
+	mux.HandleFunc("GET /v1/audio-features", s.catalogHandler(s.handleAudioFeatures))
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The client requests some collections with a trailing slash, e.g. /me/tracks/
+		if len(r.URL.Path) > 1 {
+			r.URL.Path = strings.TrimSuffix(r.URL.Path, "/")
+		}
+		s.mu.Lock()
+		s.requests[r.Method+" "+r.URL.Path]++
+		fail := s.fail
+		retryAfter := s.retryAfter
+		s.mu.Unlock()
+		if fail != nil {
+			if status := fail(r); status != 0 {
+				if retryAfter != "" {
+					w.Header().Set("Retry-After", retryAfter)
+				}
+				writeError(w, status, http.StatusText(status))
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns a spotify.Client that sends every request to the fake
+func (s *Server) Client() *spotify.Client {
+	return spotify.NewClient(s.ClientConfig())
+}
+
+// ClientConfig returns the configuration of Client, to build a client with other options such as a Clock
+func (s *Server) ClientConfig() spotify.ClientConfig {
+	return spotify.ClientConfig{
+		APIURL:      s.URL + "/v1",
+		TokenURL:    s.URL + "/api/token",
+		HTTPClient:  s.server.Client(),
+		TokenSource: spotify.StaticTokenSource(CatalogToken),
+	}
+}
+
+// AddUser makes library visible to requests authorized with token. Adding a token again replaces its library.
+func (s *Server) AddUser(token string, library *Library) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.libraries[token] = library
+}
+
+// UpdateLibrary runs update on the library of token while holding the server's lock
+func (s *Server) UpdateLibrary(token string, update func(*Library)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if library, ok := s.libraries[token]; ok {
+		update(library)
+	}
+}
+
+// AddGrant makes the accounts service exchange an authorization code or refresh token for token.
+// Codes and refresh tokens without a grant are rejected with invalid_grant.
+func (s *Server) AddGrant(codeOrRefreshToken string, token spotify.TokenResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.grants[codeOrRefreshToken] = &token
+}
+
+func (s *Server) SetPlaylistTracks(playlistId string, items []spotify.UsersSavedTrackItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playlistTracks[playlistId] = items
+}
+
+func (s *Server) SetAlbumTracks(albumId string, tracks []spotify.Track) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.albumTracks[albumId] = tracks
+}
+
+func (s *Server) SetArtistAlbums(artistId string, albums []spotify.Album) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artistAlbums[artistId] = albums
+}
+
+func (s *Server) SetArtistTopTracks(artistId string, tracks []spotify.Track) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artistTopTracks[artistId] = tracks
+}
+
+func (s *Server) SetAudioFeatures(features ...spotify.AudioFeatures) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range features {
+		s.audioFeatures[f.Id] = f
+	}
+}
+
+// CreatedPlaylist returns a playlist created through the fake
+func (s *Server) CreatedPlaylist(playlistId string) (*CreatedPlaylist, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created, ok := s.createdPlaylists[playlistId]
+	if !ok {
+		return nil, false
+	}
+	copied := *created
+	copied.TrackURIs = slices.Clone(created.TrackURIs)
+	copied.CoverJPEG = slices.Clone(created.CoverJPEG)
+	return &copied, true
+}
+
+// DeletePlaylist simulates the owner deleting a playlist created through the fake
+func (s *Server) DeletePlaylist(playlistId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if created, ok := s.createdPlaylists[playlistId]; ok {
+		created.Unfollowed = true
+	}
+}
+
+// Requests returns how many requests were made to path with method, e.g. Requests("GET", "/v1/me/tracks")
+func (s *Server) Requests(method string, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method+" "+path]
+}
+
+// FailRequests makes the server answer every request fail returns a non-zero status for with that
+// status, e.g. to fail a single page of a collection with a 502. Pass nil to serve everything again.
+func (s *Server) FailRequests(fail func(r *http.Request) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+// SetRetryAfter makes the responses failed by FailRequests carry a Retry-After header of seconds.
+// Pass 0 to leave the header out again.
+func (s *Server) SetRetryAfter(seconds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryAfter = ""
+	if seconds > 0 {
+		s.retryAfter = strconv.Itoa(seconds)
+	}
+}
+
+// --- Auth ---
+
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// userHandler serves endpoints that act on behalf of the user owning the bearer token
+func (s *Server) userHandler(handler func(http.ResponseWriter, *http.Request, *Library)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		library, ok := s.libraries[bearerToken(r)]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		handler(w, r, library)
+	}
+}
+
+// catalogHandler serves endpoints that any valid token may call
+func (s *Server) catalogHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		s.mu.Lock()
+		_, ok := s.libraries[token]
+		s.mu.Unlock()
+		if !ok && token != CatalogToken {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	var key string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		key = r.PostForm.Get("code")
+	case "refresh_token":
+		key = r.PostForm.Get("refresh_token")
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.mu.Lock()
+	grant, ok := s.grants[key]
+	s.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeJSON(w, http.StatusOK, grant)
+}
+
+// --- User endpoints ---
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	user := library.User
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) handleSavedTracks(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	items := slices.Clone(library.SavedTracks)
+	s.mu.Unlock()
+	writePage(w, r, s.URL, items)
+}
+
+func (s *Server) handleSavedAlbums(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	items := slices.Clone(library.SavedAlbums)
+	s.mu.Unlock()
+	writePage(w, r, s.URL, items)
+}
+
+func (s *Server) handleTopTracks(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	items := slices.Clone(library.TopTracks)
+	s.mu.Unlock()
+	writePage(w, r, s.URL, items)
+}
+
+func (s *Server) handleTopArtists(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	items := slices.Clone(library.TopArtists)
+	s.mu.Unlock()
+	writePage(w, r, s.URL, items)
+}
+
+// handleFollowedArtists pages with an "after" cursor instead of an offset, like Spotify
+func (s *Server) handleFollowedArtists(w http.ResponseWriter, r *http.Request, library *Library) {
+	if r.URL.Query().Get("type") != "artist" {
+		writeError(w, http.StatusBadRequest, "type must be artist")
+		return
+	}
+
+	s.mu.Lock()
+	artists := slices.Clone(library.FollowedArtists)
+	s.mu.Unlock()
+
+	start := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		start = slices.IndexFunc(artists, func(a spotify.Artist) bool { return a.Id == after }) + 1
+	}
+	limit := parseLimit(r)
+	end := min(start+limit, len(artists))
+
+	next := ""
+	if end < len(artists) {
+		query := url.Values{}
+		query.Set("type", "artist")
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("after", artists[end-1].Id)
+		next = s.URL + r.URL.Path + "?" + query.Encode()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"artists": map[string]any{
+			"items": nonNil(artists[start:end]),
+			"next":  next,
+			"total": len(artists),
+		},
+	})
+}
+
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	items := slices.Clone(library.Playlists)
+	s.mu.Unlock()
+	writePage(w, r, s.URL, items)
+}
+
+func (s *Server) handleCreatePlaylist(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	userId := library.User.Id
+	s.mu.Unlock()
+	if r.PathValue("userId") != userId {
+		writeError(w, http.StatusForbidden, "You cannot create a playlist for another user")
+		return
+	}
+
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON")
+		return
+	}
+
+	s.mu.Lock()
+	playlist := spotify.Playlist{
+		Id:          fmt.Sprintf("spotifytest-playlist-%d", len(s.createdPlaylists)+1),
+		Name:        body.Name,
+		Description: body.Description,
+		Public:      body.Public,
+		SnapshotId:  "1",
+	}
+	playlist.Owner.Id = userId
+	s.createdPlaylists[playlist.Id] = &CreatedPlaylist{Playlist: playlist, OwnerId: userId}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, playlist)
+}
+
+func (s *Server) handleGetPlaylist(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created, ok := s.createdPlaylists[r.PathValue("playlistId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, created.Playlist)
+}
+
+func (s *Server) handleUpdatePlaylist(w http.ResponseWriter, r *http.Request, library *Library) {
+	var body struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created, ok := s.ownedPlaylist(w, r, library)
+	if !ok {
+		return
+	}
+	if body.Name != nil {
+		created.Playlist.Name = *body.Name
+	}
+	if body.Description != nil {
+		created.Playlist.Description = *body.Description
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleFollowsPlaylist treats the owner of a created playlist as its only follower
+func (s *Server) handleFollowsPlaylist(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created, ok := s.createdPlaylists[r.PathValue("playlistId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	follows := created.OwnerId == r.URL.Query().Get("ids") && !created.Unfollowed
+	writeJSON(w, http.StatusOK, []bool{follows})
+}
+
+func (s *Server) handleAddTracks(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.writePlaylistItems(w, r, library, false)
+}
+
+func (s *Server) handleReplaceTracks(w http.ResponseWriter, r *http.Request, library *Library) {
+	s.writePlaylistItems(w, r, library, true)
+}
+
+func (s *Server) writePlaylistItems(w http.ResponseWriter, r *http.Request, library *Library, replace bool) {
+	var body struct {
+		URIs []string `json:"uris"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Error parsing JSON")
+		return
+	}
+	if len(body.URIs) > 100 {
+		writeError(w, http.StatusBadRequest, "Too many tracks requested")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created, ok := s.ownedPlaylist(w, r, library)
+	if !ok {
+		return
+	}
+	if replace {
+		created.TrackURIs = slices.Clone(body.URIs)
+	} else {
+		created.TrackURIs = append(created.TrackURIs, body.URIs...)
+	}
+	snapshot, _ := strconv.Atoi(created.Playlist.SnapshotId)
+	created.Playlist.SnapshotId = strconv.Itoa(snapshot + 1)
+
+	status := http.StatusCreated
+	if replace {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, map[string]string{"snapshot_id": created.Playlist.SnapshotId})
+}
+
+// handleUploadCover accepts a base64 encoded JPEG of at most 256 KB, like Spotify
+func (s *Server) handleUploadCover(w http.ResponseWriter, r *http.Request, library *Library) {
+	encoded, err := io.ReadAll(io.LimitReader(r.Body, 256*1024+1))
+	if err != nil || len(encoded) > 256*1024 {
+		writeError(w, http.StatusRequestEntityTooLarge, "Image too large")
+		return
+	}
+	jpeg, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil || !bytes.HasPrefix(jpeg, []byte{0xFF, 0xD8}) {
+		writeError(w, http.StatusBadRequest, "Image is not a base64 encoded JPEG")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created, ok := s.ownedPlaylist(w, r, library)
+	if !ok {
+		return
+	}
+	created.CoverJPEG = jpeg
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ownedPlaylist returns the created playlist of the request if the user owns it, and writes an
+// error otherwise. The caller must hold s.mu.
+func (s *Server) ownedPlaylist(w http.ResponseWriter, r *http.Request, library *Library) (*CreatedPlaylist, bool) {
+	created, ok := s.createdPlaylists[r.PathValue("playlistId")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return nil, false
+	}
+	if created.OwnerId != library.User.Id {
+		writeError(w, http.StatusForbidden, "You cannot change a playlist you don't own")
+		return nil, false
+	}
+	return created, true
+}
+
+// --- Catalog endpoints ---
+
+func (s *Server) handlePlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	items, ok := s.playlistTracks[r.PathValue("playlistId")]
+	items = slices.Clone(items)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	writePage(w, r, s.URL, items)
+}
+
+func (s *Server) handleAlbumTracks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	items, ok := s.albumTracks[r.PathValue("albumId")]
+	items = slices.Clone(items)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	writePage(w, r, s.URL, items)
+}
+
+func (s *Server) handleArtistAlbums(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	albums := slices.Clone(s.artistAlbums[r.PathValue("artistId")])
+	s.mu.Unlock()
+
+	if groups := r.URL.Query().Get("include_groups"); groups != "" {
+		include := strings.Split(groups, ",")
+		albums = slices.DeleteFunc(albums, func(a spotify.Album) bool {
+			return !slices.Contains(include, a.AlbumType)
+		})
+	}
+	writePage(w, r, s.URL, albums)
+}
+
+func (s *Server) handleArtistTopTracks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	tracks := slices.Clone(s.artistTopTracks[r.PathValue("artistId")])
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": nonNil(tracks)})
+}
+
+// handleAudioFeatures returns null for unknown ids, like Spotify
+func (s *Server) handleAudioFeatures(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) > 100 {
+		writeError(w, http.StatusBadRequest, "Too many ids requested")
+		return
+	}
+
+	s.mu.Lock()
+	features := make([]*spotify.AudioFeatures, len(ids))
+	for i, id := range ids {
+		if f, ok := s.audioFeatures[id]; ok {
+			features[i] = &f
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{"audio_features": features})
+}
+
+// --- Helpers ---
+
+func parseLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultLimit
+	}
+	return min(limit, maxLimit)
+}
+
+// writePage writes an offset-paged collection in Spotify's paging object format
+func writePage[T any](w http.ResponseWriter, r *http.Request, baseURL string, items []T) {
+	query := r.URL.Query()
+	limit := parseLimit(r)
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	start := min(offset, len(items))
+	end := min(start+limit, len(items))
+
+	next := ""
+	if end < len(items) {
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(end))
+		next = baseURL + r.URL.Path + "?" + query.Encode()
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"items":  nonNil(items[start:end]),
+		"limit":  limit,
+		"offset": offset,
+		"next":   next,
+		"total":  len(items),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Pages carry an ETag like Spotify's, so conditional requests for unchanged pages get a 304
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// nonNil makes empty collections encode as [] rather than null
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{"status": status, "message": message},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
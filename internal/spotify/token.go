@@ -1,6 +1,7 @@
 package spotify
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,8 +19,12 @@ type SecretTokenResponse struct {
 	ExpiresMs int64  `json:"expiration"`
 }
 
-// Cache structure holding token, expiration, and mutex
-var tokenCache struct {
+// secretTokenSource fetches client-credentials tokens from the token service at TOKEN_URL and
+// caches them until shortly before they expire
+type secretTokenSource struct {
+	httpClient *http.Client
+	clock      Clock
+
 	sync.RWMutex     // Embed RWMutex for read/write locking
 	token            string
 	expiresAt        time.Time
@@ -27,13 +32,17 @@ var tokenCache struct {
 	fetchErr         error     // Store the last fetch error
 }
 
+func newSecretTokenSource(httpClient *http.Client, clock Clock) *secretTokenSource {
+	return &secretTokenSource{httpClient: httpClient, clock: clock}
+}
+
 // expirationBuffer defines how close to expiration we trigger a refresh.
 const expirationBuffer = 60 * time.Second // Refresh if expires within 60 seconds
 
 // retryCooldown defines minimum time before retrying after a failed fetch (optional)
 const retryCooldown = 15 * time.Second
 
-func fetchNewToken() (string, time.Time, error) {
+func (s *secretTokenSource) fetchNewToken(ctx context.Context) (string, time.Time, error) {
 	logger.Debug("Attempting to fetch a new secret token")
 
 	apiURL := os.Getenv("TOKEN_URL")
@@ -42,14 +51,14 @@ func fetchNewToken() (string, time.Time, error) {
 	}
 	url := apiURL + "/token"
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("failed to create request for %s: %w", url, err)
 	}
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("X-API-Key", os.Getenv("TOKEN_API_KEY"))
 
-	resp, err := httpClient.Do(req)
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("failed to execute request to %s: %w", url, err)
 	}
@@ -83,73 +92,76 @@ func fetchNewToken() (string, time.Time, error) {
 	return result.Token, expirationTime, nil
 }
 
-func getSecretToken() (string, error) {
-	now := time.Now()
+func (s *secretTokenSource) Token(ctx context.Context) (string, error) {
+	now := s.clock.Now()
 
 	// --- Fast path: Check cache with Read Lock ---
-	tokenCache.RLock()
+	s.RLock()
 	// Check if token exists and is valid (not expired or expiring soon)
-	if tokenCache.token != "" && now.Before(tokenCache.expiresAt.Add(-expirationBuffer)) {
-		logger.Debug("Returning valid token from cache", zap.Time("cachedExpiresAt", tokenCache.expiresAt))
-		token := tokenCache.token // Copy value while holding lock
-		tokenCache.RUnlock()
+	if s.token != "" && now.Before(s.expiresAt.Add(-expirationBuffer)) {
+		logger.Debug("Returning valid token from cache", zap.Time("cachedExpiresAt", s.expiresAt))
+		token := s.token // Copy value while holding lock
+		s.RUnlock()
 		return token, nil
 	}
 	// Token is invalid or doesn't exist, need to potentially fetch.
 	// Release read lock before attempting write lock.
-	tokenCache.RUnlock()
+	s.RUnlock()
 
 	// Safely log token info, handling case where token might be empty or too short
 	tokenInfo := "empty"
-	if len(tokenCache.token) > 0 {
-		if len(tokenCache.token) >= 4 {
-			tokenInfo = "****" + tokenCache.token[len(tokenCache.token)-4:]
+	if len(s.token) > 0 {
+		if len(s.token) >= 4 {
+			tokenInfo = "****" + s.token[len(s.token)-4:]
 		} else {
-			tokenInfo = "****" + tokenCache.token // Log full token if less than 4 chars
+			tokenInfo = "****" + s.token // Log full token if less than 4 chars
 		}
 	}
 
 	logger.Debug("Cached token invalid, missing, or expiring soon. Attempting refresh.",
 		zap.String("currentToken", tokenInfo), // Safely log token identification
-		zap.Time("currentExpiresAt", tokenCache.expiresAt))
+		zap.Time("currentExpiresAt", s.expiresAt))
 
 	// --- Slow path: Acquire Write Lock to Update ---
-	tokenCache.Lock()
-	defer tokenCache.Unlock() // Ensure unlock happens even on errors during fetch
+	s.Lock()
+	defer s.Unlock() // Ensure unlock happens even on errors during fetch
 
 	// !!! Double-check validity after acquiring write lock !!!
 	// Another goroutine might have refreshed the token while we waited for the lock.
-	now = time.Now() // Re-check current time
-	if tokenCache.token != "" && now.Before(tokenCache.expiresAt.Add(-expirationBuffer)) {
+	now = s.clock.Now() // Re-check current time
+	if s.token != "" && now.Before(s.expiresAt.Add(-expirationBuffer)) {
 		logger.Debug("Token refreshed by another goroutine while waiting for lock; returning cached token.",
-			zap.Time("newCachedExpiresAt", tokenCache.expiresAt))
-		return tokenCache.token, nil // Return the newly cached token
+			zap.Time("newCachedExpiresAt", s.expiresAt))
+		return s.token, nil // Return the newly cached token
 	}
 
 	// Optional: Prevent rapid-fire retries if the last fetch failed recently
-	if tokenCache.fetchErr != nil && now.Before(tokenCache.lastFetchAttempt.Add(retryCooldown)) {
+	if s.fetchErr != nil && now.Before(s.lastFetchAttempt.Add(retryCooldown)) {
 		logger.Warn("Returning previous fetch error due to retry cooldown",
-			zap.Time("lastAttempt", tokenCache.lastFetchAttempt),
-			zap.Error(tokenCache.fetchErr))
+			zap.Time("lastAttempt", s.lastFetchAttempt),
+			zap.Error(s.fetchErr))
 		// Return the specific error from the last failed attempt
-		return "", fmt.Errorf("token refresh failed recently, try again after %v: %w", retryCooldown, tokenCache.fetchErr)
+		return "", fmt.Errorf("token refresh failed recently, try again after %v: %w", retryCooldown, s.fetchErr)
 	}
 
 	// --- Perform the fetch ---
-	newToken, newExpiresAt, err := fetchNewToken()
-	tokenCache.lastFetchAttempt = time.Now() // Record attempt time regardless of outcome
+	newToken, newExpiresAt, err := s.fetchNewToken(ctx)
+	s.lastFetchAttempt = s.clock.Now() // Record attempt time regardless of outcome
 
 	// If fetch failed, store the error and return it. Don't update token/expiry.
+	// A cancelled caller says nothing about the token service, so don't trigger the cooldown for it.
 	if err != nil {
-		tokenCache.fetchErr = err // Store the fetch error
+		if ctx.Err() == nil {
+			s.fetchErr = err // Store the fetch error
+		}
 		return "", err
 	}
 
 	// --- Success: Update cache ---
 	logger.Debug("Updating token cache with newly fetched token", zap.Time("newExpiresAt", newExpiresAt))
-	tokenCache.token = newToken
-	tokenCache.expiresAt = newExpiresAt
-	tokenCache.fetchErr = nil // Clear any previous error on success
+	s.token = newToken
+	s.expiresAt = newExpiresAt
+	s.fetchErr = nil // Clear any previous error on success
 
-	return tokenCache.token, nil
+	return s.token, nil
 }
@@ -1,8 +1,10 @@
 package spotify
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -15,7 +17,22 @@ type Track struct {
 	Popularity       int            `json:"popularity"`
 	DurationMS       int            `json:"duration_ms"`
 	AvailableMarkets []string       `json:"available_markets"`
+	PreviewURL       string         `json:"preview_url"`
 	AudioFeatures    *AudioFeatures `json:"audio_features"`
+	ExternalIds      struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+	// IsPlayable and LinkedFrom are only set on tracks requested for a market. When the original
+	// track isn't playable there, Spotify returns a playable copy and LinkedFrom is the original.
+	IsPlayable *bool        `json:"is_playable,omitempty"`
+	LinkedFrom *LinkedTrack `json:"linked_from,omitempty"`
+	// Market is the market the track was requested for, which Spotify doesn't echo back
+	Market string `json:"-"`
+}
+
+// LinkedTrack is the track a relinked track was requested as
+type LinkedTrack struct {
+	Id string `json:"id"`
 }
 
 type AudioFeatures struct {
@@ -48,50 +65,39 @@ type RecommendationsResponse struct {
 }
 
 type UsersSavedTrackItem struct {
-	Track Track `json:"track"`
+	AddedAt time.Time `json:"added_at"`
+	Track   Track     `json:"track"`
 }
 
 type UsersTopTracksResponse struct {
 	Items []Track `json:"items"`
 	Next  string  `json:"next"`
+	Total int     `json:"total"`
 }
 
 type UsersSavedTracksResponse struct {
 	Items []UsersSavedTrackItem `json:"items"`
 	Next  string                `json:"next"`
+	Total int                   `json:"total"`
 }
 
 type AudioFeaturesResponse struct {
 	AudioFeatures []AudioFeatures `json:"audio_features"`
 }
 
-func createAudioFeaturesBatcher(processor func([]*Track) error) *BatchProcessor[*Track] {
-	return NewBatchProcessor(100, func(tracks []*Track) error {
-		enrichedTracks, err := getAudioFeatures(tracks)
-		if err != nil {
-			return fmt.Errorf("getting audio features batch: %w", err)
-		}
-		logger.Debug("Got audio features for batch")
-
-		if err := processor(enrichedTracks); err != nil {
-			return fmt.Errorf("processing enriched tracks: %w", err)
-		}
-		logger.Debug("Processed batch of enriched tracks")
-
-		return nil
-	})
-}
+// trackBatchSize is how many tracks the fetchers hand to their processor at a time
+const trackBatchSize = 100
 
-func GetUsersTopTracks(token string, processor func([]*Track) error) error {
+func (c *Client) GetUsersTopTracks(ctx context.Context, token string, processor func([]*Track) error) error {
 	logger.Debug("Attempting to get user's top tracks")
 
-	url := fmt.Sprintf("%s/me/top/tracks/?limit=%d&offset=%d", spotifyAPIURL, limitMax, 0)
+	url := fmt.Sprintf("%s/me/top/tracks/?limit=%d&offset=%d", c.apiURL, limitMax, 0)
 
-	audioFeaturesBatcher := createAudioFeaturesBatcher(processor)
+	trackBatcher := NewBatchProcessor(trackBatchSize, processor)
 
-	err := fetchAllResultsStreaming(token, url, func(response *UsersTopTracksResponse) error {
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersTopTracksResponse) error {
 		for i := range response.Items {
-			if err := audioFeaturesBatcher.Add(&response.Items[i]); err != nil {
+			if err := trackBatcher.Add(&response.Items[i]); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
 			}
 		}
@@ -101,7 +107,7 @@ func GetUsersTopTracks(token string, processor func([]*Track) error) error {
 		return fmt.Errorf("fetching top tracks: %w", err)
 	}
 
-	if err := audioFeaturesBatcher.Flush(); err != nil {
+	if err := trackBatcher.Flush(); err != nil {
 		return fmt.Errorf("flushing remaining tracks: %w", err)
 	}
 
@@ -109,103 +115,117 @@ func GetUsersTopTracks(token string, processor func([]*Track) error) error {
 	return nil
 }
 
-func GetUsersSavedTracks(token string, processor func([]*Track) error) error {
-	logger.Debug("Attempting to get user's saved tracks")
-
-	url := fmt.Sprintf("%s/me/tracks/?limit=%d&offset=%d", spotifyAPIURL, limitMax, 0)
-
-	audioFeaturesBatcher := createAudioFeaturesBatcher(processor)
-
-	err := fetchAllResultsStreaming(token, url, func(response *UsersSavedTracksResponse) error {
+// GetUsersSavedTracks streams the user's saved tracks, newest first. Paging stops at the first
+// track added before since, so a zero since fetches the whole library. Tracks added in the same
+// second as since are fetched again, as added_at only has second precision and some of them may
+// not have been seen yet. It returns the newest added_at seen, to be used as the next high water
+// mark, and the total number of saved tracks.
+func (c *Client) GetUsersSavedTracks(ctx context.Context, token string, since time.Time, processor func([]*Track) error) (time.Time, int, error) {
+	logger.Debug("Attempting to get user's saved tracks", zap.Time("since", since))
+
+	url := fmt.Sprintf("%s/me/tracks/?limit=%d&offset=%d", c.apiURL, limitMax, 0)
+
+	trackBatcher := NewBatchProcessor(trackBatchSize, processor)
+
+	var newest time.Time
+	newCount := 0
+	total := 0
+	// Tracks saved while paging shift later pages, so the same track can show up twice
+	seen := make(map[string]bool)
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersSavedTracksResponse) error {
+		total = response.Total
 		for i := range response.Items {
-			if err := audioFeaturesBatcher.Add(&response.Items[i].Track); err != nil {
+			item := &response.Items[i]
+			if !since.IsZero() && item.AddedAt.Before(since) {
+				logger.Debug("Reached previously synced saved tracks, stopping",
+					zap.Time("addedAt", item.AddedAt),
+					zap.Int("newCount", newCount))
+				return errStopPaging
+			}
+			if item.Track.Id != "" {
+				if seen[item.Track.Id] {
+					continue
+				}
+				seen[item.Track.Id] = true
+			}
+			if item.AddedAt.After(newest) {
+				newest = item.AddedAt
+			}
+			if err := trackBatcher.Add(&item.Track); err != nil {
 				return fmt.Errorf("adding track to batch: %w", err)
 			}
+			newCount++
 		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("fetching saved tracks: %w", err)
+		return time.Time{}, 0, fmt.Errorf("fetching saved tracks: %w", err)
 	}
 
-	if err := audioFeaturesBatcher.Flush(); err != nil {
-		return fmt.Errorf("flushing remaining tracks: %w", err)
+	if err := trackBatcher.Flush(); err != nil {
+		return time.Time{}, 0, fmt.Errorf("flushing remaining tracks: %w", err)
 	}
 
-	logger.Debug("Retrieved user's saved tracks")
-	return nil
+	logger.Debug("Retrieved user's saved tracks", zap.Int("newCount", newCount), zap.Int("total", total))
+	return newest, total, nil
 }
 
-// TODO: review
-func getAudioFeatures(tracks []*Track) ([]*Track, error) {
-	if len(tracks) == 0 {
-		logger.Debug("getAudioFeatures: No tracks provided to fetch audio features for.")
-		return tracks, nil
-	}
-	logger.Debug("Attempting to get audio features for tracks", zap.Int("trackCount", len(tracks)))
-	token, err := getSecretToken()
+// GetUsersSavedTrackIds returns the ids of all the user's saved tracks, without fetching audio features
+func (c *Client) GetUsersSavedTrackIds(ctx context.Context, token string) ([]string, error) {
+	logger.Debug("Attempting to get user's saved track ids")
+
+	url := fmt.Sprintf("%s/me/tracks/?limit=%d&offset=%d", c.apiURL, limitMax, 0)
+
+	var ids []string
+	err := fetchAllResultsStreaming(ctx, c, token, url, func(response *UsersSavedTracksResponse) error {
+		for _, item := range response.Items {
+			if item.Track.Id != "" {
+				ids = append(ids, item.Track.Id)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("getting secret token: %w", err)
+		return nil, fmt.Errorf("fetching saved track ids: %w", err)
 	}
 
-	trackMap := make(map[string]*Track)
-	for _, track := range tracks {
-		trackMap[track.Id] = track
+	logger.Debug("Retrieved user's saved track ids", zap.Int("count", len(ids)))
+	return ids, nil
+}
+
+// GetAudioFeatures returns the audio features of the tracks in ids, keyed by track id. Tracks
+// Spotify has no features for are left out.
+func (c *Client) GetAudioFeatures(ctx context.Context, ids []string) (map[string]*AudioFeatures, error) {
+	features := make(map[string]*AudioFeatures, len(ids))
+	if len(ids) == 0 {
+		return features, nil
 	}
+	logger.Debug("Attempting to get audio features for tracks", zap.Int("trackCount", len(ids)))
 
-	for i := 0; i < len(tracks); i += 100 { // Iterate in batches of 100
-		var ids []string
-		for j := i; j < i+100 && j < len(tracks); j++ {
-			ids = append(ids, tracks[j].Id)
-		}
-		logger.Debug("Fetching audio features for batch", zap.Int("batchStartIndex", i), zap.Int("batchSize", len(ids)))
+	token, err := c.catalogToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting secret token: %w", err)
+	}
 
-		url := fmt.Sprintf("%s/audio-features?ids=", spotifyAPIURL) + strings.Join(ids, ",")
-		logger.Debug("Audio features request URL", zap.String("url", url))
+	for i := 0; i < len(ids); i += 100 { // Iterate in batches of 100
+		batch := ids[i:min(i+100, len(ids))]
+		url := fmt.Sprintf("%s/audio-features?ids=%s", c.apiURL, strings.Join(batch, ","))
 
-		// fetchAllResultsStreaming expects a slice of responses, but /audio-features returns a single object with a list.
-		// We need a direct fetch or adapt fetchAllResultsStreaming if it can handle single-object root.
-		// For simplicity, let's assume fetchAllResultsStreaming is adapted or we use a direct fetch.
-		// If fetchAllResultsStreaming is strictly for paginated list of T, this part needs adjustment.
-		// Assuming fetchAllResultsStreaming returns []*AudioFeaturesResponse and we take the first.
-		audioFeaturesResponses, err := fetchAllResults[AudioFeaturesResponse](token, url)
+		response, err := fetchPage[AudioFeaturesResponse](ctx, c, token, url)
 		if err != nil {
-			logger.Error("Error fetching audio features batch", zap.Error(err), zap.String("url", url))
-			// TODO: Refetch token and retry? For now, continue, some tracks might not get features.
-			continue // Continue to next batch if one fails
+			return nil, fmt.Errorf("fetching audio features batch: %w", err)
 		}
 
-		if len(audioFeaturesResponses) > 0 && audioFeaturesResponses[0] != nil {
-			for _, audioFeature := range audioFeaturesResponses[0].AudioFeatures {
-				if audioFeature.Id != "" {
-					if track, ok := trackMap[audioFeature.Id]; ok {
-						track.AudioFeatures = &audioFeature
-					} else {
-						logger.Warn("Received audio feature for an unknown track ID", zap.String("trackId", audioFeature.Id))
-					}
-				}
+		for j := range response.AudioFeatures {
+			audioFeatures := &response.AudioFeatures[j]
+			if audioFeatures.Id != "" {
+				features[audioFeatures.Id] = audioFeatures
 			}
-		} else {
-			logger.Warn("No audio features data received in response for batch", zap.String("url", url))
-		}
-	}
-	logger.Debug("Finished processing audio features for all batches")
-
-	// The trackMap already contains the updated tracks.
-	// The original 'tracks' slice order might be preferred by callers.
-	// For now, returning based on trackMap. If order matters, reconstruct from original 'tracks' slice.
-	// result := make([]*Track, 0, len(trackMap))
-	// for _, track := range trackMap { // This loses original order if tracks had duplicates or if map iteration order is not stable.
-	// 	result = append(result, track)
-	// }
-	// To preserve order and ensure all original tracks are returned (even if features failed):
-	for i, track := range tracks {
-		if updatedTrack, ok := trackMap[track.Id]; ok {
-			tracks[i] = updatedTrack // Ensure the original slice is updated
 		}
 	}
 
-	return tracks, nil // Return the modified original slice
+	logger.Debug("Retrieved audio features", zap.Int("trackCount", len(ids)), zap.Int("found", len(features)))
+	return features, nil
 }
 
 // func GetRecommendations(seedArtists, seedGenres []string, minTempo float64, maxTempo float64) ([]*Track, error) {
@@ -1,6 +1,7 @@
 package spotify
 
 import (
+	"context"
 	"fmt"
 
 	"go.uber.org/zap"
@@ -22,12 +23,12 @@ type WhoAmIResponse struct {
 	Id string `json:"id"`
 }
 
-func GetUser(token string) (*User, error) {
+func (c *Client) GetUser(ctx context.Context, token string) (*User, error) {
 	logger.Debug("Attempting to get user details")
-	url := fmt.Sprintf("%s/me", spotifyAPIURL)
+	url := fmt.Sprintf("%s/me", c.apiURL)
 	logger.Debug("Fetching user details from URL", zap.String("url", url))
 
-	responses, err := fetchAllResults[User](token, url)
+	responses, err := fetchAllResults[User](ctx, c, token, url)
 	if err != nil {
 		return nil, fmt.Errorf("fetching user details: %w", err)
 	}
@@ -1,13 +1,13 @@
 package spotify
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -15,34 +15,19 @@ import (
 )
 
 var (
-	config         *Config
-	configOnce     sync.Once
-	configErr      error
-	httpClient     *http.Client
-	httpClientOnce sync.Once
+	config     *Config
+	configOnce sync.Once
+	configErr  error
 )
 
 const (
-	spotifyAPIURL = "https://api.spotify.com/v1"
-	limitMax      = 50
-	maxRetries    = 3
+	limitMax = 50
+	// pageConcurrency is how many pages of a collection are fetched at once
+	pageConcurrency = 4
 )
 
-var retryLimits = []int{20, 10, 5}
-
-func init() {
-	httpClientOnce.Do(func() {
-		transport := &http.Transport{
-			MaxIdleConns:        100,
-			IdleConnTimeout:     90 * time.Second,
-			MaxIdleConnsPerHost: 10,
-		}
-		httpClient = &http.Client{
-			Transport: transport,
-			Timeout:   30 * time.Second,
-		}
-	})
-}
+// errStopPaging can be returned by a streaming processor to stop fetching further pages without failing
+var errStopPaging = errors.New("stop paging")
 
 type Image struct {
 	URL string `json:"url"`
@@ -137,6 +122,30 @@ func getNextURL(response any) string {
 	}
 }
 
+// getTotal returns the size of the collection a page belongs to, or 0 if the page doesn't say
+func getTotal(response any) int {
+	switch r := response.(type) {
+	case *UsersTopTracksResponse:
+		return r.Total
+	case *UsersSavedTracksResponse:
+		return r.Total
+	case *UsersPlaylistsResponse:
+		return r.Total
+	case *PlaylistsTracksResponse:
+		return r.Total
+	case *UsersTopArtistsResponse:
+		return r.Total
+	case *ArtistsAlbumsResponse:
+		return r.Total
+	case *AlbumsTracksResponse:
+		return r.Total
+	case *UsersSavedAlbumsResponse:
+		return r.Total
+	default:
+		return 0
+	}
+}
+
 func modifyURLLimit(apiURL string, newLimit int) string {
 	parsedURL, err := url.Parse(apiURL)
 	if err != nil {
@@ -150,101 +159,47 @@ func modifyURLLimit(apiURL string, newLimit int) string {
 	return parsedURL.String()
 }
 
-func fetchPaginatedItems[T any](token string, url string) (*T, error) {
-	// TODO: Manage rate limiting
-	var lastErr error
-	currentLimit := limitMax
-
-	for attempt := range maxRetries {
-		if attempt > 0 {
-			// Exponential backoff
-			waitTime := time.Duration(attempt*3) * time.Second
-			logger.Debug("Retrying request",
-				zap.Int("attempt", attempt+1),
-				zap.Duration("waitTime", waitTime),
-				zap.String("url", url))
-			time.Sleep(waitTime)
-		}
-
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			logger.Error("Error creating HTTP request", zap.Error(err), zap.String("url", url))
-			lastErr = err
-			continue
-		}
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			logger.Error("Error making GET request", zap.Error(err), zap.String("url", url))
-			lastErr = err
-			continue
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close() // Close the body before processing errors
-
-			logger.Error("Error response from Spotify server",
-				zap.Int("statusCode", resp.StatusCode),
-				zap.String("url", url))
-
-			// Handle rate limiting
-			if resp.StatusCode == http.StatusTooManyRequests {
-				retryAfter := 60
-				// if retryHeader := resp.Header.Get("Retry-After"); retryHeader != "" {
-				// 	if seconds, err := strconv.Atoi(retryHeader); err == nil {
-				// 		retryAfter = seconds
-				// 	}
-				// }
-				logger.Warn("Rate limited by Spotify",
-					zap.Int("retryAfterSeconds", retryAfter),
-					zap.String("url", url))
-				time.Sleep(time.Duration(retryAfter) * time.Second)
-				lastErr = fmt.Errorf("rate limited, retrying after %d seconds", retryAfter)
-				continue
-			} else if resp.StatusCode == http.StatusBadGateway {
-				// For 502 errors, try reducing the limit
-				if strings.Contains(url, "limit=") && currentLimit > 10 {
-					currentLimit = retryLimits[attempt] // Be careful with index out of bounds if maxRetries > len(retryLimits)
-					logger.Warn("Got a 502 error, reducing request limit",
-						zap.Int("newLimit", currentLimit),
-						zap.String("url", url))
-					url = modifyURLLimit(url, currentLimit)
-					lastErr = fmt.Errorf("reduced limit to %d after 502 error", currentLimit)
-					continue
-				}
-			} else if resp.StatusCode >= 500 {
-				// Other server errors might be temporary
-				lastErr = fmt.Errorf("server returned status code %d, may retry", resp.StatusCode)
-				continue
-			} else {
-				// Client errors (4xx) except 429 are likely not recoverable with retries
-				return nil, fmt.Errorf("server returned status code %d", resp.StatusCode)
-			}
-		} else {
-			// Success path
-			defer resp.Body.Close()
-			var result T
-			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-				logger.Error("Error decoding Spotify response body", zap.Error(err), zap.String("url", url))
-				lastErr = err
-				continue
-			}
+// marketParam is the market query parameter to append to a URL after separator, or "" when the
+// market is unknown. Catalog requests for a market only return content playable there, relinking
+// tracks that aren't.
+func marketParam(separator string, market string) string {
+	if market == "" {
+		return ""
+	}
+	return separator + "market=" + url.QueryEscape(market)
+}
 
-			return &result, nil
-		}
+// fetchPage GETs a single page or object from the Web API and decodes it
+func fetchPage[T any](ctx context.Context, c *Client, token string, url string) (*T, error) {
+	body, err := c.execute(ctx, apiRequest{
+		method:     "GET",
+		url:        url,
+		token:      token,
+		idempotent: true,
+		// Catalog responses are the same for every user, so they can be shared through the cache
+		cacheable: c.isCatalogToken(token),
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("all %d attempts failed, last error: %v", maxRetries, lastErr)
+	var result T
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decoding Spotify response body: %w", err)
+	}
+	return &result, nil
 }
 
-func fetchAllResults[T any](token string, initialURL string) ([]*T, error) {
+// fetchAllResults returns every page of a collection. A failed page fails the whole call: it used
+// to end the collection quietly and return the pages fetched before it, which callers couldn't
+// tell apart from a complete result.
+func fetchAllResults[T any](ctx context.Context, c *Client, token string, initialURL string) ([]*T, error) {
 	var results []*T
 	url := initialURL
 	for {
-		response, err := fetchPaginatedItems[T](token, url)
+		response, err := fetchPage[T](ctx, c, token, url)
 		if err != nil {
-			break
+			return nil, err
 		}
 		results = append(results, response)
 
@@ -256,86 +211,206 @@ func fetchAllResults[T any](token string, initialURL string) ([]*T, error) {
 	return results, nil
 }
 
-func fetchAllResultsStreaming[T any](token string, initialURL string, processor func(*T) error) error {
-	url := initialURL
-	for {
-		response, err := fetchPaginatedItemsWithRetry[T](token, url)
+// fetchAllResultsStreaming hands every page of a collection to processor, in order. Once the first
+// page has been processed, the rest of an offset-paged collection is fetched pageConcurrency pages
+// at a time using its total; cursor-paged collections are followed page by page. Because fanning
+// out waits for the first page, a processor that stops paging there costs no extra requests.
+func fetchAllResultsStreaming[T any](ctx context.Context, c *Client, token string, initialURL string, processor func(*T) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	first, err := fetchPage[T](ctx, c, token, initialURL)
+	if err != nil {
+		return fmt.Errorf("fetch failed: %w", err)
+	}
+	if err := processor(first); err != nil {
+		if errors.Is(err, errStopPaging) {
+			return nil
+		}
+		return fmt.Errorf("processor failed: %w", err)
+	}
+
+	nextURL := getNextURL(first)
+	if nextURL == "" {
+		return nil
+	}
+	total := getTotal(first)
+	if pageURLs := offsetPageURLs(nextURL, total); len(pageURLs) > 1 {
+		return fetchPagesConcurrently(ctx, c, token, pageURLs, total, processor)
+	}
+	return followNextURLs(ctx, c, token, nextURL, processor)
+}
+
+// followNextURLs fetches pages one after the other by following their next links
+func followNextURLs[T any](ctx context.Context, c *Client, token string, pageURL string, processor func(*T) error) error {
+	for pageURL != "" {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		response, err := fetchPage[T](ctx, c, token, pageURL)
 		if err != nil {
 			return fmt.Errorf("fetch failed: %w", err)
 		}
 
 		if err := processor(response); err != nil {
+			if errors.Is(err, errStopPaging) {
+				return nil
+			}
 			return fmt.Errorf("processor failed: %w", err)
 		}
 
-		url = getNextURL(response)
-		if url == "" {
-			break
-		}
+		pageURL = getNextURL(response)
 	}
 	return nil
 }
 
-func fetchPaginatedItemsWithRetry[T any](token string, url string) (*T, error) {
-	const maxRetries = 4
-	baseDelay := 100 * time.Millisecond
-	maxDelay := 10 * time.Second
+// fetchPagesConcurrently fetches up to pageConcurrency of pageURLs ahead of the processor, which
+// still gets the pages in order. Pages still in flight are cancelled when paging stops early. A page
+// whose limit was shrunk on a retry ends before the next one starts, so the items in between are
+// fetched before moving on.
+func fetchPagesConcurrently[T any](ctx context.Context, c *Client, token string, pageURLs []string, total int, processor func(*T) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type pageResult struct {
+		page *T
+		err  error
+	}
+	start := func(pageURL string) chan pageResult {
+		result := make(chan pageResult, 1)
+		go func() {
+			page, err := fetchPage[T](ctx, c, token, pageURL)
+			result <- pageResult{page: page, err: err}
+		}()
+		return result
+	}
 
-	var lastErr error
-	for attempt := range maxRetries {
-		if attempt > 0 {
-			delay := baseDelay * time.Duration(1<<uint(attempt-1))
-			if delay > maxDelay {
-				delay = maxDelay
-			}
-			time.Sleep(delay)
-		}
+	logger.Debug("Fetching pages concurrently", zap.Int("pageCount", len(pageURLs)))
+	var window []chan pageResult
+	next := 0
+	for ; next < len(pageURLs) && next < pageConcurrency; next++ {
+		window = append(window, start(pageURLs[next]))
+	}
 
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, err
+	for current := 0; len(window) > 0; current++ {
+		result := <-window[0]
+		window = window[1:]
+		if result.err != nil {
+			return fmt.Errorf("fetch failed: %w", result.err)
 		}
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			lastErr = err
-			continue
+		if err := processor(result.page); err != nil {
+			if errors.Is(err, errStopPaging) {
+				return nil
+			}
+			return fmt.Errorf("processor failed: %w", err)
 		}
 
-		if resp.StatusCode == http.StatusOK {
-			defer resp.Body.Close()
-			var result T
-			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-				return nil, err
+		plannedEnd := total
+		if current+1 < len(pageURLs) {
+			plannedEnd, _, _ = pageBounds(pageURLs[current+1])
+		}
+		if err := fetchPageGap(ctx, c, token, getNextURL(result.page), plannedEnd, processor); err != nil {
+			if errors.Is(err, errStopPaging) {
+				return nil
 			}
-			return &result, nil
+			return err
 		}
 
-		resp.Body.Close()
+		if next < len(pageURLs) {
+			window = append(window, start(pageURLs[next]))
+			next++
+		}
+	}
+	return nil
+}
 
-		// Handle rate limiting
-		if resp.StatusCode == http.StatusTooManyRequests {
-			retryAfter := 60
-			if retryHeader := resp.Header.Get("Retry-After"); retryHeader != "" {
-				if seconds, err := strconv.Atoi(retryHeader); err == nil {
-					retryAfter = seconds
-				}
-			}
-			time.Sleep(time.Duration(retryAfter) * time.Second)
-			lastErr = fmt.Errorf("rate limited")
-			continue
+// fetchPageGap follows next links from nextURL up to the item at plannedEnd, where the next planned
+// page starts, without fetching past it
+func fetchPageGap[T any](ctx context.Context, c *Client, token string, nextURL string, plannedEnd int, processor func(*T) error) error {
+	for nextURL != "" {
+		offset, limit, ok := pageBounds(nextURL)
+		if !ok || offset >= plannedEnd {
+			return nil
 		}
 
-		// Don't retry client errors except rate limiting
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			return nil, fmt.Errorf("client error: %d", resp.StatusCode)
+		logger.Debug("Fetching items skipped by a shrunken page",
+			zap.Int("offset", offset),
+			zap.Int("plannedEnd", plannedEnd))
+		page, err := fetchPage[T](ctx, c, token, modifyURLPage(nextURL, offset, min(limit, plannedEnd-offset)))
+		if err != nil {
+			return fmt.Errorf("fetch failed: %w", err)
+		}
+		if err := processor(page); err != nil {
+			if errors.Is(err, errStopPaging) {
+				return err
+			}
+			return fmt.Errorf("processor failed: %w", err)
 		}
+		nextURL = getNextURL(page)
+	}
+	return nil
+}
+
+// offsetPageURLs returns the URLs of the pages from nextURL to the end of a collection of total
+// items, or nil if nextURL isn't offset-paged
+func offsetPageURLs(nextURL string, total int) []string {
+	offset, limit, ok := pageBounds(nextURL)
+	if !ok {
+		return nil
+	}
+
+	var pageURLs []string
+	for ; offset < total; offset += limit {
+		pageURLs = append(pageURLs, modifyURLPage(nextURL, offset, limit))
+	}
+	return pageURLs
+}
+
+// pageBounds returns the offset and limit of an offset-paged URL
+func pageBounds(pageURL string) (int, int, bool) {
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return 0, 0, false
+	}
+	query := parsedURL.Query()
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil {
+		return 0, 0, false
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		return 0, 0, false
+	}
+	return offset, limit, true
+}
 
-		lastErr = fmt.Errorf("server error: %d", resp.StatusCode)
+func modifyURLPage(apiURL string, offset int, limit int) string {
+	parsedURL, err := url.Parse(apiURL)
+	if err != nil {
+		return apiURL
 	}
 
-	return nil, fmt.Errorf("max retries exceeded: %w", lastErr)
+	query := parsedURL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	parsedURL.RawQuery = query.Encode()
+
+	return parsedURL.String()
+}
+
+// SleepContext sleeps for d, returning early with the context's error if it is cancelled
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
 // BatchProcessor accumulates items and processes them in batches
@@ -0,0 +1,61 @@
+package spotify_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/spotify/spotifytest"
+)
+
+func TestPagingRefetchesItemsSkippedByShrunkenPage(t *testing.T) {
+	spotify.InitializeLogger(zap.NewNop())
+
+	server := spotifytest.NewServer()
+	defer server.Close()
+	server.AddUser("alice-token", &spotifytest.Library{User: spotify.User{Id: "alice"}})
+
+	const trackCount = 130
+	items := make([]spotify.UsersSavedTrackItem, trackCount)
+	for i := range items {
+		items[i].Track = spotify.Track{Id: fmt.Sprintf("track-%03d", i)}
+	}
+	server.SetPlaylistTracks("playlist-1", items)
+
+	// The second page fails once, so it is retried with a smaller limit
+	var failed atomic.Bool
+	server.FailRequests(func(r *http.Request) int {
+		if r.URL.Path == "/v1/playlists/playlist-1/tracks" && r.URL.Query().Get("offset") == "50" && !failed.Swap(true) {
+			return http.StatusBadGateway
+		}
+		return 0
+	})
+
+	var trackIds []string
+	err := server.Client().GetPlaylistsTracks(context.Background(), "alice-token", "playlist-1", func(tracks []*spotify.Track) error {
+		for _, track := range tracks {
+			trackIds = append(trackIds, track.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("getting playlist tracks: %v", err)
+	}
+	if !failed.Load() {
+		t.Fatal("the second page never failed")
+	}
+
+	if len(trackIds) != trackCount {
+		t.Fatalf("got %d tracks, want %d", len(trackIds), trackCount)
+	}
+	for i, trackId := range trackIds {
+		if want := items[i].Track.Id; trackId != want {
+			t.Fatalf("track %d = %s, want %s", i, trackId, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package detect
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	clickFrequency = 1000 // Hz
+	clickLength    = 20 * time.Millisecond
+)
+
+// GenerateClickTrack returns a metronome at bpm: a short decaying 1 kHz click on every beat. It is
+// meant for checking the estimator against a known tempo.
+func GenerateClickTrack(bpm float64, duration time.Duration, sampleRate int) *Signal {
+	samples := make([]float64, int(duration.Seconds()*float64(sampleRate)))
+	if bpm <= 0 {
+		return &Signal{Samples: samples, SampleRate: sampleRate}
+	}
+
+	clickSamples := int(clickLength.Seconds() * float64(sampleRate))
+	beatSamples := 60 / bpm * float64(sampleRate)
+	for beat := 0.0; int(beat) < len(samples); beat += beatSamples {
+		start := int(math.Round(beat))
+		for i := 0; i < clickSamples && start+i < len(samples); i++ {
+			t := float64(i) / float64(sampleRate)
+			decay := math.Exp(-t / (clickLength.Seconds() / 5))
+			samples[start+i] += 0.8 * decay * math.Sin(2*math.Pi*clickFrequency*t)
+		}
+	}
+	return &Signal{Samples: samples, SampleRate: sampleRate}
+}
@@ -0,0 +1,224 @@
+package detect
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// Signal is mono audio with samples in [-1, 1]
+type Signal struct {
+	Samples    []float64
+	SampleRate int
+}
+
+// Duration returns the length of the signal in seconds
+func (s *Signal) Duration() float64 {
+	if s.SampleRate == 0 {
+		return 0
+	}
+	return float64(len(s.Samples)) / float64(s.SampleRate)
+}
+
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// Decode reads a WAV or MP3 stream, detected from its header, and mixes it down to mono
+func Decode(r io.Reader) (*Signal, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(12)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading audio header: %w", err)
+	}
+
+	switch {
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return DecodeWAV(br)
+	case len(header) >= 3 && string(header[0:3]) == "ID3",
+		len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return DecodeMP3(br)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// DecodeMP3 decodes an MP3 stream and mixes it down to mono
+func DecodeMP3(r io.Reader) (*Signal, error) {
+	decoder, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("opening mp3: %w", err)
+	}
+
+	// go-mp3 always produces 16-bit little-endian stereo
+	pcm, err := io.ReadAll(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("decoding mp3: %w", err)
+	}
+
+	frames := len(pcm) / 4
+	samples := make([]float64, frames)
+	for i := range frames {
+		left := int16(binary.LittleEndian.Uint16(pcm[i*4:]))
+		right := int16(binary.LittleEndian.Uint16(pcm[i*4+2:]))
+		samples[i] = (float64(left) + float64(right)) / 2 / 32768
+	}
+	return &Signal{Samples: samples, SampleRate: decoder.SampleRate()}, nil
+}
+
+const (
+	wavFormatPCM        = 1
+	wavFormatIEEEFloat  = 3
+	wavFormatExtensible = 0xFFFE
+	// maxWAVFormatChunkSize bounds the format chunk, which is 40 bytes at most in practice, so a corrupt
+	// header can't make the decoder allocate gigabytes
+	maxWAVFormatChunkSize = 256
+)
+
+// DecodeWAV decodes a PCM (8, 16, 24 or 32-bit) or 32/64-bit float WAV stream and mixes it down to mono
+func DecodeWAV(r io.Reader) (*Signal, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("reading wav header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	var format, channels, bitsPerSample uint16
+	var sampleRate uint32
+	haveFormat := false
+
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, fmt.Errorf("reading wav chunk: %w", err)
+		}
+		chunkId := string(chunkHeader[0:4])
+		chunkSize := binary.LittleEndian.Uint32(chunkHeader[4:8])
+
+		switch chunkId {
+		case "fmt ":
+			if chunkSize > maxWAVFormatChunkSize {
+				return nil, fmt.Errorf("wav format chunk too long: %d bytes", chunkSize)
+			}
+			chunk := make([]byte, chunkSize)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, fmt.Errorf("reading wav format: %w", err)
+			}
+			if len(chunk) < 16 {
+				return nil, fmt.Errorf("wav format chunk too short: %d bytes", len(chunk))
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = binary.LittleEndian.Uint16(chunk[2:4])
+			sampleRate = binary.LittleEndian.Uint32(chunk[4:8])
+			bitsPerSample = binary.LittleEndian.Uint16(chunk[14:16])
+			if format == wavFormatExtensible && len(chunk) >= 26 {
+				// The real format is the first two bytes of the sub-format GUID
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, fmt.Errorf("wav data chunk before format chunk")
+			}
+			if channels == 0 || sampleRate == 0 {
+				return nil, fmt.Errorf("invalid wav format: %d channels at %d Hz", channels, sampleRate)
+			}
+			data, err := io.ReadAll(io.LimitReader(r, int64(chunkSize)))
+			if err != nil {
+				return nil, fmt.Errorf("reading wav data: %w", err)
+			}
+			samples, err := wavSamples(data, format, bitsPerSample, int(channels))
+			if err != nil {
+				return nil, err
+			}
+			return &Signal{Samples: samples, SampleRate: int(sampleRate)}, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(chunkSize)); err != nil {
+				return nil, fmt.Errorf("skipping wav chunk %q: %w", chunkId, err)
+			}
+		}
+		// Chunks are word aligned
+		if chunkSize%2 == 1 && chunkId != "data" {
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return nil, fmt.Errorf("skipping wav padding: %w", err)
+			}
+		}
+	}
+}
+
+func wavSamples(data []byte, format uint16, bitsPerSample uint16, channels int) ([]float64, error) {
+	bytesPerSample := int(bitsPerSample) / 8
+	if bytesPerSample == 0 {
+		return nil, fmt.Errorf("invalid wav sample size: %d bits", bitsPerSample)
+	}
+
+	var sample func([]byte) float64
+	switch {
+	case format == wavFormatPCM && bitsPerSample == 8:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format == wavFormatPCM && bitsPerSample == 16:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case format == wavFormatPCM && bitsPerSample == 24:
+		sample = func(b []byte) float64 {
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float64(v) / 8388608
+		}
+	case format == wavFormatPCM && bitsPerSample == 32:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648 }
+	case format == wavFormatIEEEFloat && bitsPerSample == 32:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	case format == wavFormatIEEEFloat && bitsPerSample == 64:
+		sample = func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }
+	default:
+		return nil, fmt.Errorf("%w: wav format %d with %d-bit samples", ErrUnsupportedFormat, format, bitsPerSample)
+	}
+
+	frameSize := bytesPerSample * channels
+	frames := len(data) / frameSize
+	samples := make([]float64, frames)
+	for i := range frames {
+		var sum float64
+		for ch := range channels {
+			offset := i*frameSize + ch*bytesPerSample
+			sum += sample(data[offset : offset+bytesPerSample])
+		}
+		samples[i] = sum / float64(channels)
+	}
+	return samples, nil
+}
+
+// EncodeWAV writes the signal as a 16-bit mono PCM WAV
+func EncodeWAV(w io.Writer, s *Signal) error {
+	dataSize := len(s.Samples) * 2
+	var buf bytes.Buffer
+	buf.Grow(44 + dataSize)
+
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVE")
+
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(wavFormatPCM))
+	binary.Write(&buf, binary.LittleEndian, uint16(1))
+	binary.Write(&buf, binary.LittleEndian, uint32(s.SampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(s.SampleRate*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	for _, v := range s.Samples {
+		v = math.Max(-1, math.Min(1, v))
+		binary.Write(&buf, binary.LittleEndian, int16(math.Round(v*32767)))
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,162 @@
+package detect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// wavFile builds a WAV file with a format chunk, an unknown odd-sized chunk and the data
+func wavFile(format uint16, channels uint16, sampleRate uint32, bitsPerSample uint16, data []byte) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+
+	body.WriteString("fmt ")
+	binary.Write(&body, binary.LittleEndian, uint32(16))
+	binary.Write(&body, binary.LittleEndian, format)
+	binary.Write(&body, binary.LittleEndian, channels)
+	binary.Write(&body, binary.LittleEndian, sampleRate)
+	blockAlign := channels * bitsPerSample / 8
+	binary.Write(&body, binary.LittleEndian, sampleRate*uint32(blockAlign))
+	binary.Write(&body, binary.LittleEndian, blockAlign)
+	binary.Write(&body, binary.LittleEndian, bitsPerSample)
+
+	body.WriteString("LIST")
+	binary.Write(&body, binary.LittleEndian, uint32(3))
+	body.Write([]byte{1, 2, 3, 0})
+
+	body.WriteString("data")
+	binary.Write(&body, binary.LittleEndian, uint32(len(data)))
+	body.Write(data)
+
+	var file bytes.Buffer
+	file.WriteString("RIFF")
+	binary.Write(&file, binary.LittleEndian, uint32(body.Len()))
+	file.Write(body.Bytes())
+	return file.Bytes()
+}
+
+func TestDecodeWAVRoundTrip(t *testing.T) {
+	signal := GenerateClickTrack(120, 2*time.Second, testSampleRate)
+	var buf bytes.Buffer
+	if err := EncodeWAV(&buf, signal); err != nil {
+		t.Fatalf("encoding wav: %v", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decoding wav: %v", err)
+	}
+	if decoded.SampleRate != signal.SampleRate {
+		t.Errorf("sample rate = %d, want %d", decoded.SampleRate, signal.SampleRate)
+	}
+	if len(decoded.Samples) != len(signal.Samples) {
+		t.Fatalf("decoded %d samples, want %d", len(decoded.Samples), len(signal.Samples))
+	}
+	for i, sample := range signal.Samples {
+		// 16-bit samples are accurate to about 1/32768
+		if math.Abs(decoded.Samples[i]-sample) > 1e-4 {
+			t.Fatalf("sample %d = %g, want %g", i, decoded.Samples[i], sample)
+		}
+	}
+}
+
+func TestDecodeWAVFormats(t *testing.T) {
+	float32Bits := func(v float32) []byte { return binary.LittleEndian.AppendUint32(nil, math.Float32bits(v)) }
+	float64Bits := func(v float64) []byte { return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)) }
+
+	tests := []struct {
+		name          string
+		format        uint16
+		channels      uint16
+		bitsPerSample uint16
+		data          []byte
+		wanted        []float64
+	}{
+		{name: "8-bit", format: wavFormatPCM, channels: 1, bitsPerSample: 8, data: []byte{128, 192, 0}, wanted: []float64{0, 0.5, -1}},
+		{name: "16-bit stereo", format: wavFormatPCM, channels: 2, bitsPerSample: 16, data: []byte{0x00, 0x40, 0x00, 0xC0, 0x00, 0x40, 0x00, 0x40}, wanted: []float64{0, 0.5}},
+		{name: "24-bit", format: wavFormatPCM, channels: 1, bitsPerSample: 24, data: []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0xC0}, wanted: []float64{0.5, -0.5}},
+		{name: "32-bit", format: wavFormatPCM, channels: 1, bitsPerSample: 32, data: []byte{0x00, 0x00, 0x00, 0x40}, wanted: []float64{0.5}},
+		{name: "32-bit float", format: wavFormatIEEEFloat, channels: 1, bitsPerSample: 32, data: append(float32Bits(0.25), float32Bits(-0.75)...), wanted: []float64{0.25, -0.75}},
+		{name: "64-bit float", format: wavFormatIEEEFloat, channels: 1, bitsPerSample: 64, data: float64Bits(-0.5), wanted: []float64{-0.5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signal, err := DecodeWAV(bytes.NewReader(wavFile(test.format, test.channels, 8000, test.bitsPerSample, test.data)))
+			if err != nil {
+				t.Fatalf("decoding wav: %v", err)
+			}
+			if signal.SampleRate != 8000 {
+				t.Errorf("sample rate = %d, want 8000", signal.SampleRate)
+			}
+			if len(signal.Samples) != len(test.wanted) {
+				t.Fatalf("samples = %v, want %v", signal.Samples, test.wanted)
+			}
+			for i, wanted := range test.wanted {
+				if math.Abs(signal.Samples[i]-wanted) > 1e-6 {
+					t.Errorf("samples = %v, want %v", signal.Samples, test.wanted)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeWAVUnsupportedFormat(t *testing.T) {
+	// A-law isn't supported
+	_, err := DecodeWAV(bytes.NewReader(wavFile(6, 1, 8000, 8, []byte{0, 0})))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("decoding an a-law wav returned %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestDecodeWAVOversizedFormatChunk(t *testing.T) {
+	file := wavFile(wavFormatPCM, 1, 8000, 16, []byte{0, 0})
+	// Claim a 4 GiB format chunk; the decoder must refuse it rather than allocate it
+	binary.LittleEndian.PutUint32(file[16:20], math.MaxUint32)
+	if _, err := DecodeWAV(bytes.NewReader(file)); err == nil {
+		t.Errorf("decoding a wav with a 4 GiB format chunk succeeded, want an error")
+	}
+}
+
+// silentMP3 builds frames of a 128 kbps, 44.1 kHz mono MP3 whose side information is all zero,
+// which decode to silence
+func silentMP3(frames int) []byte {
+	const frameLength = 144 * 128000 / 44100
+	var buf bytes.Buffer
+	for range frames {
+		frame := make([]byte, frameLength)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0xC0})
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeMP3(t *testing.T) {
+	signal, err := Decode(bytes.NewReader(silentMP3(20)))
+	if err != nil {
+		t.Fatalf("decoding mp3: %v", err)
+	}
+	if signal.SampleRate != 44100 {
+		t.Errorf("sample rate = %d, want 44100", signal.SampleRate)
+	}
+	// Each MPEG-1 layer III frame is 1152 samples
+	if len(signal.Samples) != 20*1152 {
+		t.Errorf("decoded %d samples, want %d", len(signal.Samples), 20*1152)
+	}
+	for i, sample := range signal.Samples {
+		if sample != 0 {
+			t.Fatalf("sample %d = %g, want silence", i, sample)
+		}
+	}
+}
+
+func TestDecodeUnsupportedFormat(t *testing.T) {
+	_, err := Decode(bytes.NewReader([]byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00")))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("decoding an ogg stream returned %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
@@ -0,0 +1,252 @@
+// Package detect estimates the tempo of audio in pure Go: decoded audio is turned into a
+// spectral-flux onset envelope, whose periodicity is scored with a comb filter over its
+// autocorrelation.
+package detect
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+	"slices"
+)
+
+// Options tune Estimate. Zero fields use the defaults.
+type Options struct {
+	MinBPM float64 // default 60
+	MaxBPM float64 // default 200
+}
+
+// Result is a tempo estimate
+type Result struct {
+	BPM float64
+	// Confidence is how periodic the onsets are at BPM, from 0 (no pulse) to 1 (a metronome)
+	Confidence float64
+}
+
+var ErrTooShort = errors.New("audio too short to estimate tempo")
+
+const (
+	defaultMinBPM = 60
+	defaultMaxBPM = 200
+
+	// analysisRate is the sample rate the signal is decimated to before the spectral analysis
+	analysisRate = 11025
+	frameSize    = 512
+	hopSize      = 64
+
+	// combHarmonics is how many multiples of a beat period the comb filter scores
+	combHarmonics = 4
+	bpmStep       = 0.05
+
+	// multipleTempoRatio is how close the score of a multiple of the best tempo has to come to be preferred
+	multipleTempoRatio = 0.8
+
+	minDurationSeconds = 4
+)
+
+// Estimate returns the tempo of the signal
+func Estimate(s *Signal, opts Options) (Result, error) {
+	minBPM, maxBPM := opts.MinBPM, opts.MaxBPM
+	if minBPM <= 0 {
+		minBPM = defaultMinBPM
+	}
+	if maxBPM <= minBPM {
+		maxBPM = defaultMaxBPM
+	}
+	if s == nil || s.SampleRate <= 0 || s.Duration() < minDurationSeconds {
+		return Result{}, ErrTooShort
+	}
+
+	samples, rate := decimate(s.Samples, s.SampleRate)
+	envelope := onsetEnvelope(samples)
+	fps := float64(rate) / hopSize
+
+	maxLag := int(math.Ceil(fps*60/minBPM*combHarmonics)) + 2
+	if len(envelope) < maxLag*2 {
+		return Result{}, ErrTooShort
+	}
+	acf := autocorrelation(envelope, maxLag)
+	if acf[0] <= 0 {
+		// Silence or a constant signal has no onsets at all
+		return Result{}, nil
+	}
+
+	score := func(bpm float64) float64 {
+		lag := fps * 60 / bpm
+		var sum float64
+		for k := 1; k <= combHarmonics; k++ {
+			sum += interpolate(acf, lag*float64(k))
+		}
+		return sum / combHarmonics
+	}
+
+	var scores []float64
+	bestBPM, bestScore := 0.0, math.Inf(-1)
+	for bpm := minBPM; bpm <= maxBPM; bpm += bpmStep {
+		sc := score(bpm)
+		scores = append(scores, sc)
+		if sc > bestScore {
+			bestBPM, bestScore = bpm, sc
+		}
+	}
+
+	// A pulse at T also scores highly at T/2 and T/3, so prefer a multiple that scores nearly as well
+	baseBPM, baseScore := bestBPM, bestScore
+	multipleScore := math.Inf(-1)
+	for _, multiple := range []float64{2, 3} {
+		candidate := baseBPM * multiple
+		if candidate > maxBPM {
+			continue
+		}
+		if sc := score(candidate); sc >= multipleTempoRatio*baseScore && sc > multipleScore {
+			bestBPM, bestScore, multipleScore = candidate, sc, sc
+		}
+	}
+
+	// Confidence combines how strongly the envelope repeats at the beat period with how much the
+	// winning tempo stands out from the typical candidate
+	periodicity := clamp01(bestScore / acf[0])
+	median := medianOf(scores)
+	prominence := 0.0
+	if bestScore > 0 {
+		prominence = clamp01((bestScore - median) / bestScore)
+	}
+
+	return Result{
+		BPM:        math.Round(bestBPM*10) / 10,
+		Confidence: math.Round(math.Sqrt(periodicity*prominence)*100) / 100,
+	}, nil
+}
+
+// decimate low-passes by averaging and downsamples to roughly analysisRate
+func decimate(samples []float64, rate int) ([]float64, int) {
+	factor := max(1, int(math.Round(float64(rate)/analysisRate)))
+	if factor == 1 {
+		return samples, rate
+	}
+	out := make([]float64, len(samples)/factor)
+	for i := range out {
+		var sum float64
+		for _, v := range samples[i*factor : (i+1)*factor] {
+			sum += v
+		}
+		out[i] = sum / float64(factor)
+	}
+	return out, rate / factor
+}
+
+// onsetEnvelope returns the half-wave rectified spectral flux of each hop, with its local mean removed
+func onsetEnvelope(samples []float64) []float64 {
+	if len(samples) < frameSize {
+		return nil
+	}
+	frames := (len(samples)-frameSize)/hopSize + 1
+	window := hannWindow(frameSize)
+	buf := make([]complex128, frameSize)
+	prev := make([]float64, frameSize/2+1)
+	cur := make([]float64, frameSize/2+1)
+	flux := make([]float64, frames)
+
+	for f := range frames {
+		offset := f * hopSize
+		for i := range frameSize {
+			buf[i] = complex(samples[offset+i]*window[i], 0)
+		}
+		fft(buf)
+		var sum float64
+		for bin := range cur {
+			// Log compression keeps loud bins from dominating
+			cur[bin] = math.Log1p(100 * cmplx.Abs(buf[bin]))
+			if d := cur[bin] - prev[bin]; d > 0 && f > 0 {
+				sum += d
+			}
+		}
+		flux[f] = sum
+		prev, cur = cur, prev
+	}
+
+	// Subtract a moving average of about a quarter second so only peaks remain
+	const meanWindow = 43
+	envelope := make([]float64, frames)
+	var running float64
+	for i := range frames {
+		running += flux[i]
+		if i >= meanWindow {
+			running -= flux[i-meanWindow]
+		}
+		mean := running / float64(min(i+1, meanWindow))
+		envelope[i] = math.Max(0, flux[i]-mean)
+	}
+	return envelope
+}
+
+// autocorrelation returns the unbiased autocorrelation of x for lags 0 to maxLag
+func autocorrelation(x []float64, maxLag int) []float64 {
+	acf := make([]float64, maxLag+1)
+	n := len(x)
+	for lag := 0; lag <= maxLag && lag < n; lag++ {
+		var sum float64
+		for i := 0; i+lag < n; i++ {
+			sum += x[i] * x[i+lag]
+		}
+		acf[lag] = sum / float64(n-lag)
+	}
+	return acf
+}
+
+// interpolate reads x at a fractional index
+func interpolate(x []float64, at float64) float64 {
+	i := int(at)
+	if i < 0 || i+1 >= len(x) {
+		return 0
+	}
+	frac := at - float64(i)
+	return x[i]*(1-frac) + x[i+1]*frac
+}
+
+func hannWindow(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	}
+	return w
+}
+
+// fft is an in-place iterative radix-2 FFT; len(x) must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
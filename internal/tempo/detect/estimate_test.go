@@ -0,0 +1,71 @@
+package detect
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+const (
+	testSampleRate  = 22050
+	testDuration    = 20 * time.Second
+	testBPMAccuracy = 1
+)
+
+func TestEstimateClickTrack(t *testing.T) {
+	for _, bpm := range []float64{90, 120, 170} {
+		result, err := Estimate(GenerateClickTrack(bpm, testDuration, testSampleRate), Options{})
+		if err != nil {
+			t.Fatalf("estimating %g BPM click track: %v", bpm, err)
+		}
+		if math.Abs(result.BPM-bpm) > testBPMAccuracy {
+			t.Errorf("click track at %g BPM estimated at %g BPM", bpm, result.BPM)
+		}
+		if result.Confidence < 0.8 {
+			t.Errorf("click track at %g BPM has confidence %g, want at least 0.8", bpm, result.Confidence)
+		}
+	}
+}
+
+func TestEstimatePrefersMultipleTempo(t *testing.T) {
+	tests := []struct {
+		name   string
+		bpm    float64
+		opts   Options
+		wanted float64
+	}{
+		// A click every third of a second pulses at 60 and 90 BPM too, but 180 is the beat
+		{name: "multiple in range", bpm: 180, wanted: 180},
+		// The beat is above the range, so its half is the best tempo left
+		{name: "multiple above range", bpm: 150, opts: Options{MaxBPM: 100}, wanted: 75},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Estimate(GenerateClickTrack(test.bpm, testDuration, testSampleRate), test.opts)
+			if err != nil {
+				t.Fatalf("estimating tempo: %v", err)
+			}
+			if math.Abs(result.BPM-test.wanted) > testBPMAccuracy {
+				t.Errorf("click track at %g BPM estimated at %g BPM, want %g", test.bpm, result.BPM, test.wanted)
+			}
+		})
+	}
+}
+
+func TestEstimateTooShort(t *testing.T) {
+	_, err := Estimate(GenerateClickTrack(120, time.Second, testSampleRate), Options{})
+	if !errors.Is(err, ErrTooShort) {
+		t.Errorf("estimating a one second track returned %v, want %v", err, ErrTooShort)
+	}
+}
+
+func TestEstimateSilence(t *testing.T) {
+	result, err := Estimate(GenerateClickTrack(0, testDuration, testSampleRate), Options{})
+	if err != nil {
+		t.Fatalf("estimating silence: %v", err)
+	}
+	if result.BPM != 0 || result.Confidence != 0 {
+		t.Errorf("silence estimated at %g BPM with confidence %g, want 0", result.BPM, result.Confidence)
+	}
+}
@@ -0,0 +1,10 @@
+package tempo
+
+import "go.uber.org/zap"
+
+var logger *zap.Logger
+
+// InitializeLogger sets the logger for the tempo package.
+func InitializeLogger(l *zap.Logger) {
+	logger = l
+}
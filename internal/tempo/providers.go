@@ -0,0 +1,293 @@
+package tempo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+	"github.com/rcong315/RunDJServer/internal/spotify"
+	"github.com/rcong315/RunDJServer/internal/tempo/detect"
+)
+
+// --- Cache ---
+
+// CacheProvider returns the tempos already stored on track rows, keeping the provider that produced them
+type CacheProvider struct{}
+
+func NewCacheProvider() *CacheProvider {
+	return &CacheProvider{}
+}
+
+func (p *CacheProvider) Name() string { return "cache" }
+
+func (p *CacheProvider) Lookup(ctx context.Context, tracks []Track) (map[string]*Estimate, error) {
+	stored, err := db.GetTrackAudioFeatures(ctx, trackIds(tracks))
+	if err != nil {
+		return nil, err
+	}
+
+	estimates := make(map[string]*Estimate, len(stored))
+	for trackId, audioFeatures := range stored {
+		estimates[trackId] = &Estimate{
+			BPM:           audioFeatures.Tempo,
+			TimeSignature: audioFeatures.TimeSignature,
+			Confidence:    audioFeatures.TempoConfidence,
+			Provider:      audioFeatures.TempoProvider,
+			Features:      audioFeatures,
+		}
+	}
+	return estimates, nil
+}
+
+// --- Spotify ---
+
+// spotifyConfidence is the confidence given to Spotify's tempos, which come from its own audio analysis
+const spotifyConfidence = 0.9
+
+// SpotifyProvider reads tempos from Spotify's /audio-features endpoint
+type SpotifyProvider struct {
+	client *spotify.Client
+}
+
+func NewSpotifyProvider(client *spotify.Client) *SpotifyProvider {
+	return &SpotifyProvider{client: client}
+}
+
+func (p *SpotifyProvider) Name() string { return "spotify" }
+
+func (p *SpotifyProvider) Lookup(ctx context.Context, tracks []Track) (map[string]*Estimate, error) {
+	features, err := p.client.GetAudioFeatures(ctx, trackIds(tracks))
+	if err != nil {
+		return nil, err
+	}
+
+	estimates := make(map[string]*Estimate, len(features))
+	for trackId, f := range features {
+		estimates[trackId] = &Estimate{
+			BPM:           f.Tempo,
+			TimeSignature: f.TimeSignature,
+			Confidence:    spotifyConfidence,
+			Features: &db.AudioFeatures{
+				Danceability:      f.Danceability,
+				Energy:            f.Energy,
+				Key:               f.Key,
+				Loudness:          f.Loudness,
+				Mode:              f.Mode,
+				Speechiness:       f.Speechiness,
+				Acousticness:      f.Acousticness,
+				Instrumentallness: f.Instrumentallness,
+				Liveness:          f.Liveness,
+				Valence:           f.Valence,
+				Tempo:             f.Tempo,
+				Duration:          f.Duration,
+				TimeSignature:     f.TimeSignature,
+			},
+		}
+	}
+	return estimates, nil
+}
+
+// --- HTTP lookup service ---
+
+const (
+	httpProviderBatchSize = 100
+	// httpDefaultConfidence is used when the service doesn't report a confidence
+	httpDefaultConfidence = 0.5
+)
+
+// HTTPLookupRequest is the body POSTed to a BPM lookup service at <baseURL>/v1/tempo
+type HTTPLookupRequest struct {
+	Tracks []Track `json:"tracks"`
+}
+
+// HTTPLookupResponse lists the tempos the service knows; unknown tracks are left out
+type HTTPLookupResponse struct {
+	Tempos []HTTPTempo `json:"tempos"`
+}
+
+type HTTPTempo struct {
+	Id            string   `json:"id"`
+	BPM           float64  `json:"bpm"`
+	TimeSignature int      `json:"time_signature,omitempty"`
+	Confidence    *float64 `json:"confidence,omitempty"`
+}
+
+// HTTPProvider asks a generic BPM lookup service. See tempotest for a local stand-in.
+type HTTPProvider struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewHTTPProvider(baseURL string, httpClient *http.Client) *HTTPProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &HTTPProvider{baseURL: baseURL, httpClient: httpClient}
+}
+
+func (p *HTTPProvider) Name() string { return "http" }
+
+func (p *HTTPProvider) Lookup(ctx context.Context, tracks []Track) (map[string]*Estimate, error) {
+	estimates := make(map[string]*Estimate, len(tracks))
+	for i := 0; i < len(tracks); i += httpProviderBatchSize {
+		batch := tracks[i:min(i+httpProviderBatchSize, len(tracks))]
+		tempos, err := p.lookupBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tempos {
+			confidence := httpDefaultConfidence
+			if t.Confidence != nil {
+				confidence = *t.Confidence
+			}
+			estimates[t.Id] = &Estimate{
+				BPM:           t.BPM,
+				TimeSignature: t.TimeSignature,
+				Confidence:    confidence,
+			}
+		}
+	}
+	return estimates, nil
+}
+
+func (p *HTTPProvider) lookupBatch(ctx context.Context, tracks []Track) ([]HTTPTempo, error) {
+	body, err := json.Marshal(HTTPLookupRequest{Tracks: tracks})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling tempo lookup request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/v1/tempo", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating tempo lookup request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sending tempo lookup request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("tempo lookup returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var response HTTPLookupResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decoding tempo lookup response: %w", err)
+	}
+	return response.Tempos, nil
+}
+
+func trackIds(tracks []Track) []string {
+	ids := make([]string, len(tracks))
+	for i, track := range tracks {
+		ids[i] = track.Id
+	}
+	return ids
+}
+
+// --- Audio analysis ---
+
+const (
+	// defaultAudioMinConfidence is the confidence below which an estimate from audio is discarded
+	defaultAudioMinConfidence = 0.3
+	audioProviderConcurrency  = 4
+	// maxPreviewBytes bounds the download of a preview clip; 30s previews are well under 1 MB
+	maxPreviewBytes = 10 << 20
+)
+
+// AudioProvider estimates tempos from the tracks' preview clips with detect.Estimate. It is the
+// provider of last resort: it downloads and analyses audio, so it is the slowest.
+type AudioProvider struct {
+	httpClient    *http.Client
+	minConfidence float64
+}
+
+func NewAudioProvider(httpClient *http.Client, minConfidence float64) *AudioProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if minConfidence <= 0 {
+		minConfidence = defaultAudioMinConfidence
+	}
+	return &AudioProvider{httpClient: httpClient, minConfidence: minConfidence}
+}
+
+func (p *AudioProvider) Name() string { return "audio" }
+
+// Lookup analyses the tracks that have a preview URL. Tracks whose preview can't be fetched or
+// decoded are logged and left out rather than failing the batch.
+func (p *AudioProvider) Lookup(ctx context.Context, tracks []Track) (map[string]*Estimate, error) {
+	var mu sync.Mutex
+	estimates := make(map[string]*Estimate)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(audioProviderConcurrency)
+	for _, track := range tracks {
+		if track.PreviewURL == "" {
+			continue
+		}
+		g.Go(func() error {
+			result, err := p.analyse(gctx, track.PreviewURL)
+			if err != nil {
+				if gctx.Err() != nil {
+					return gctx.Err()
+				}
+				logger.Debug("Could not estimate tempo from preview",
+					zap.String("trackId", track.Id),
+					zap.Error(err))
+				return nil
+			}
+			if result.Confidence < p.minConfidence {
+				logger.Debug("Discarding low confidence tempo estimate",
+					zap.String("trackId", track.Id),
+					zap.Float64("bpm", result.BPM),
+					zap.Float64("confidence", result.Confidence))
+				return nil
+			}
+
+			mu.Lock()
+			estimates[track.Id] = &Estimate{BPM: result.BPM, Confidence: result.Confidence}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return estimates, nil
+}
+
+func (p *AudioProvider) analyse(ctx context.Context, previewURL string) (detect.Result, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", previewURL, nil)
+	if err != nil {
+		return detect.Result{}, fmt.Errorf("creating preview request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return detect.Result{}, fmt.Errorf("downloading preview: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return detect.Result{}, fmt.Errorf("downloading preview: status %d", resp.StatusCode)
+	}
+
+	signal, err := detect.Decode(io.LimitReader(resp.Body, maxPreviewBytes))
+	if err != nil {
+		return detect.Result{}, fmt.Errorf("decoding preview: %w", err)
+	}
+	return detect.Estimate(signal, detect.Options{})
+}
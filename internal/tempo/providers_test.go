@@ -0,0 +1,133 @@
+package tempo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/tempo"
+	"github.com/rcong315/RunDJServer/internal/tempo/tempotest"
+)
+
+func init() {
+	tempo.InitializeLogger(zap.NewNop())
+}
+
+// stubProvider answers from a fixed table and remembers which tracks it was asked for
+type stubProvider struct {
+	name      string
+	estimates map[string]*tempo.Estimate
+	err       error
+	asked     []string
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) Lookup(ctx context.Context, tracks []tempo.Track) (map[string]*tempo.Estimate, error) {
+	for _, track := range tracks {
+		p.asked = append(p.asked, track.Id)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.estimates, nil
+}
+
+func TestHTTPProviderRecordsProviderAndConfidence(t *testing.T) {
+	server := tempotest.NewServer()
+	defer server.Close()
+	server.SetTempo("known", 172, 4, 0.8)
+
+	chain := tempo.NewChain(tempo.NewHTTPProvider(server.URL, nil))
+	estimates := chain.Lookup(context.Background(), []tempo.Track{{Id: "known"}, {Id: "unknown"}})
+
+	estimate, ok := estimates["known"]
+	if !ok {
+		t.Fatalf("no estimate for a track the service knows")
+	}
+	if estimate.BPM != 172 || estimate.TimeSignature != 4 {
+		t.Errorf("estimate is %g BPM in %d, want 172 BPM in 4", estimate.BPM, estimate.TimeSignature)
+	}
+	if estimate.Provider != "http" {
+		t.Errorf("estimate provider is %q, want %q", estimate.Provider, "http")
+	}
+	if estimate.Confidence != 0.8 {
+		t.Errorf("estimate confidence is %g, want 0.8", estimate.Confidence)
+	}
+
+	audioFeatures := estimate.AudioFeatures()
+	if audioFeatures.TempoProvider != "http" || audioFeatures.TempoConfidence != 0.8 {
+		t.Errorf("audio features record provider %q with confidence %g, want %q with 0.8",
+			audioFeatures.TempoProvider, audioFeatures.TempoConfidence, "http")
+	}
+
+	if _, ok := estimates["unknown"]; ok {
+		t.Errorf("got an estimate for a track the service doesn't know")
+	}
+}
+
+func TestChainFallsThroughToNextProvider(t *testing.T) {
+	server := tempotest.NewServer()
+	defer server.Close()
+	server.SetTempo("known", 172, 4, 0.8)
+
+	fallback := &stubProvider{
+		name: "fallback",
+		estimates: map[string]*tempo.Estimate{
+			"known":   {BPM: 90, Confidence: 0.4},
+			"unknown": {BPM: 160, Confidence: 0.4},
+		},
+	}
+	chain := tempo.NewChain(tempo.NewHTTPProvider(server.URL, nil), fallback)
+	estimates := chain.Lookup(context.Background(), []tempo.Track{{Id: "known"}, {Id: "unknown"}})
+
+	if got := estimates["known"]; got == nil || got.Provider != "http" || got.BPM != 172 {
+		t.Errorf("known track estimate is %+v, want 172 BPM from http", got)
+	}
+	if got := estimates["unknown"]; got == nil || got.Provider != "fallback" || got.BPM != 160 {
+		t.Errorf("unknown track estimate is %+v, want 160 BPM from fallback", got)
+	}
+	if len(fallback.asked) != 1 || fallback.asked[0] != "unknown" {
+		t.Errorf("fallback was asked for %v, want only the track the service doesn't know", fallback.asked)
+	}
+}
+
+func TestChainSkipsFailingProvider(t *testing.T) {
+	server := tempotest.NewServer()
+	defer server.Close()
+	server.SetTempo("known", 172, 4, 0.8)
+	server.SetFailing(true)
+
+	fallback := &stubProvider{
+		name:      "fallback",
+		estimates: map[string]*tempo.Estimate{"known": {BPM: 171, Confidence: 0.4}},
+	}
+	chain := tempo.NewChain(tempo.NewHTTPProvider(server.URL, nil), fallback)
+	estimates := chain.Lookup(context.Background(), []tempo.Track{{Id: "known"}})
+
+	if server.Requests() != 1 {
+		t.Errorf("service received %d lookups, want 1", server.Requests())
+	}
+	if got := estimates["known"]; got == nil || got.Provider != "fallback" || got.BPM != 171 {
+		t.Errorf("estimate is %+v, want 171 BPM from fallback", got)
+	}
+}
+
+func TestChainIgnoresZeroTempos(t *testing.T) {
+	first := &stubProvider{
+		name:      "first",
+		estimates: map[string]*tempo.Estimate{"track": {BPM: 0, Confidence: 1}},
+	}
+	second := &stubProvider{err: errors.New("unavailable"), name: "second"}
+	chain := tempo.NewChain(first, second)
+	estimates := chain.Lookup(context.Background(), []tempo.Track{{Id: "track"}})
+
+	if len(estimates) != 0 {
+		t.Errorf("got estimates %v, want none", estimates)
+	}
+	if len(second.asked) != 1 {
+		t.Errorf("second provider was asked for %v, want the track with a zero tempo", second.asked)
+	}
+}
@@ -0,0 +1,110 @@
+// Package tempo looks up the tempo (BPM) of tracks from an ordered chain of providers, so tracks
+// still get a tempo when one source, such as Spotify's audio features, is unavailable.
+package tempo
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/rcong315/RunDJServer/internal/db"
+)
+
+// Track is what providers get to identify a track
+type Track struct {
+	Id         string   `json:"id"`
+	Name       string   `json:"name"`
+	Artists    []string `json:"artists,omitempty"`
+	ISRC       string   `json:"isrc,omitempty"`
+	DurationMS int      `json:"duration_ms"`
+	PreviewURL string   `json:"preview_url,omitempty"`
+}
+
+// Estimate is a provider's tempo for a track
+type Estimate struct {
+	BPM           float64
+	TimeSignature int
+	// Confidence is how much the estimate can be trusted, from 0 to 1
+	Confidence float64
+	// Provider is the name of the provider that produced the estimate
+	Provider string
+	// Features are the full audio features of the track, if the provider has them
+	Features *db.AudioFeatures
+}
+
+// AudioFeatures returns the audio features to store for the estimate, with the tempo and its provenance filled in
+func (e *Estimate) AudioFeatures() *db.AudioFeatures {
+	audioFeatures := &db.AudioFeatures{}
+	if e.Features != nil {
+		copied := *e.Features
+		audioFeatures = &copied
+	}
+	audioFeatures.Tempo = e.BPM
+	if e.TimeSignature > 0 {
+		audioFeatures.TimeSignature = e.TimeSignature
+	}
+	audioFeatures.TempoProvider = e.Provider
+	audioFeatures.TempoConfidence = e.Confidence
+	return audioFeatures
+}
+
+// Provider looks up tempos. Tracks it has no tempo for are left out of the result.
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, tracks []Track) (map[string]*Estimate, error)
+}
+
+// Chain asks its providers in order, each only for the tracks the previous ones had no tempo for
+type Chain struct {
+	providers []Provider
+}
+
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+// Lookup returns the first estimate with a positive BPM for each track. A failing provider is logged
+// and skipped, so the remaining providers still get a chance.
+func (c *Chain) Lookup(ctx context.Context, tracks []Track) map[string]*Estimate {
+	estimates := make(map[string]*Estimate, len(tracks))
+	remaining := tracks
+
+	for _, provider := range c.providers {
+		if len(remaining) == 0 || ctx.Err() != nil {
+			break
+		}
+
+		found, err := provider.Lookup(ctx, remaining)
+		if err != nil {
+			logger.Warn("Tempo provider failed, falling through to the next one",
+				zap.String("provider", provider.Name()),
+				zap.Int("trackCount", len(remaining)),
+				zap.Error(err))
+			continue
+		}
+
+		var missing []Track
+		for _, track := range remaining {
+			estimate, ok := found[track.Id]
+			if !ok || estimate == nil || estimate.BPM <= 0 {
+				missing = append(missing, track)
+				continue
+			}
+			if estimate.Provider == "" {
+				estimate.Provider = provider.Name()
+			}
+			estimates[track.Id] = estimate
+		}
+
+		logger.Debug("Looked up tempos",
+			zap.String("provider", provider.Name()),
+			zap.Int("requested", len(remaining)),
+			zap.Int("found", len(remaining)-len(missing)))
+		remaining = missing
+	}
+
+	if len(remaining) > 0 {
+		logger.Debug("No tempo found for tracks", zap.Int("count", len(remaining)))
+	}
+	return estimates
+}
@@ -0,0 +1,98 @@
+// Package tempotest is a local stand-in for the BPM lookup service used by tempo.HTTPProvider.
+//
+//	server := tempotest.NewServer()
+//	defer server.Close()
+//	server.SetTempo("trackId", 172, 4, 0.8)
+//	provider := tempo.NewHTTPProvider(server.URL, nil)
+package tempotest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/rcong315/RunDJServer/internal/tempo"
+)
+
+// Server answers tempo lookups from an in-memory table
+type Server struct {
+	URL string
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	tempos   map[string]tempo.HTTPTempo
+	failing  bool
+	requests int
+}
+
+func NewServer() *Server {
+	s := &Server{tempos: make(map[string]tempo.HTTPTempo)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/tempo", s.handleLookup)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetTempo makes the server know the tempo of a track
+func (s *Server) SetTempo(trackId string, bpm float64, timeSignature int, confidence float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tempos[trackId] = tempo.HTTPTempo{
+		Id:            trackId,
+		BPM:           bpm,
+		TimeSignature: timeSignature,
+		Confidence:    &confidence,
+	}
+}
+
+// SetFailing makes every lookup fail with a 503 until reset, to exercise provider fallback
+func (s *Server) SetFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+// Requests returns how many lookups the server received
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	failing := s.failing
+	s.mu.Unlock()
+
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request tempo.HTTPLookupRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	response := tempo.HTTPLookupResponse{Tempos: []tempo.HTTPTempo{}}
+	s.mu.Lock()
+	for _, track := range request.Tracks {
+		if t, ok := s.tempos[track.Id]; ok {
+			response.Tempos = append(response.Tempos, t)
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}